@@ -3,20 +3,29 @@ package main
 //go:generate swagger generate spec --scan-models --include=github.com/Tarick/naca-publications -o ../../internal/docs/swagger.json
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
 	_ "github.com/Tarick/naca-publications/internal/docs"
 
+	"github.com/Tarick/naca-publications/internal/application/dispatcher"
+	"github.com/Tarick/naca-publications/internal/application/reconciler"
 	"github.com/Tarick/naca-publications/internal/application/server"
+	"github.com/Tarick/naca-publications/internal/entity"
 	"github.com/Tarick/naca-publications/internal/logger/zaplogger"
 	"github.com/Tarick/naca-publications/internal/repository/postgresql"
+	"github.com/Tarick/naca-publications/internal/spec"
 	"github.com/Tarick/naca-publications/internal/version"
 
 	rssAPIClient "github.com/Tarick/naca-rss-feeds/pkg/apiclient"
 
+	"github.com/gofrs/uuid"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"go.uber.org/zap"
 )
 
 func main() {
@@ -27,49 +36,45 @@ func main() {
 		Short: "Publications API",
 		Long:  `Publication API`,
 		Run: func(cmd *cobra.Command, args []string) {
-			if cfgFile != "" {
-				// Use config file from the flag.
-				viper.SetConfigFile(cfgFile)
-			} else {
-				viper.AddConfigPath(".")      // optionally look for config in the working directory
-				viper.SetConfigName("config") // name of config file (without extension)
-			}
-			// If the config file is found, read it in.
-			if err := viper.ReadInConfig(); err != nil {
-				fmt.Printf("FATAL: error in config file %s. %s", viper.ConfigFileUsed(), err)
+			readConfig(cfgFile)
+			logger := newLogger(false)
+			defer logger.Sync()
+			db := newRepository(logger)
+			// publication_types table follows publication types registered in spec
+			if err := db.EnsurePublicationTypes(context.Background(), spec.PublicationTypeNames()); err != nil {
+				fmt.Println("FATAL: failure registering publication types in database, ", err)
 				os.Exit(1)
 			}
 
-			fmt.Println("Using config file:", viper.ConfigFileUsed())
-			// Init logging
-			logCfg := &zaplogger.Config{}
-			if err := viper.UnmarshalKey("logging", logCfg); err != nil {
-				fmt.Println("Failure reading 'logging' configuration:", err)
-				os.Exit(1)
+			// Outbox dispatcher delivers publications changes to backing services of publication types in background.
+			// URLs of services are configured by publication type name, rss_api_url is used for rss.
+			serviceURLs := map[string]string{spec.PublicationTypeRSS: viper.GetString("rss_api_url")}
+			for name, serviceURL := range viper.GetStringMapString("publication_services") {
+				serviceURLs[name] = serviceURL
 			}
-			logger := zaplogger.New(logCfg).Sugar()
-			defer logger.Sync()
-
-			// Create db configuration
-			databaseViperConfig := viper.Sub("database")
-			dbCfg := &postgresql.Config{}
-			if err := databaseViperConfig.UnmarshalExact(dbCfg); err != nil {
-				fmt.Println("FATAL: failure reading 'database' configuration: ", err)
+			publicationDeliveries, err := server.NewPublicationDeliveries(serviceURLs)
+			if err != nil {
+				fmt.Println("FATAL: failure creating publication types deliveries, ", err)
 				os.Exit(1)
 			}
-			// Open db
-			db, err := postgresql.New(dbCfg, postgresql.NewZapLogger(logger.Desugar()))
-			if err != nil {
-				fmt.Println("FATAL: failure creating database connection, ", err)
+			deliveries := make(map[string]dispatcher.Delivery, len(publicationDeliveries))
+			for name, delivery := range publicationDeliveries {
+				deliveries[name] = dispatcher.Delivery(delivery)
+			}
+			dispatcherCfg := dispatcher.Config{}
+			dispatcherViperConfig := viper.Sub("outbox_dispatcher")
+			if err := dispatcherViperConfig.UnmarshalExact(&dispatcherCfg); err != nil {
+				fmt.Println("FATAL: failure reading 'outbox_dispatcher' configuration, ", err)
 				os.Exit(1)
 			}
-			// Client for RSS Feeds API
-			rssFeedsAPIURL := viper.GetString("rss_api_url")
-			rssFeedsAPIClient, err := rssAPIClient.New(rssFeedsAPIURL)
+			outboxDispatcher, err := dispatcher.New(dispatcherCfg, logger, db, deliveries)
 			if err != nil {
-				fmt.Println("FATAL: failure creating RSS API Client, ", err)
+				fmt.Println("FATAL: failure creating outbox dispatcher, ", err)
 				os.Exit(1)
 			}
+			dispatcherCtx, cancelDispatcher := context.WithCancel(context.Background())
+			defer cancelDispatcher()
+			go outboxDispatcher.Run(dispatcherCtx)
 
 			// Create web server
 			serverCfg := server.Config{}
@@ -78,7 +83,11 @@ func main() {
 				fmt.Println("FATAL: failure reading 'server' configuration, ", err)
 				os.Exit(1)
 			}
-			httpServer := server.New(serverCfg, logger, db, rssFeedsAPIClient)
+			httpServer, err := server.New(serverCfg, logger, db)
+			if err != nil {
+				fmt.Println("FATAL: failure creating server, ", err)
+				os.Exit(1)
+			}
 			httpServer.StartAndServe()
 		},
 	}
@@ -90,11 +99,222 @@ func main() {
 			fmt.Println("NACA Publications API version:", version.Version, ",build on:", version.BuildTime)
 		},
 	}
+	var fix bool
+	reconcileCmd := &cobra.Command{
+		Use:   "reconcile",
+		Short: "Detect drift between publications and RSS Feeds service",
+		Long: `Compares publications of type rss with RSS Feeds service feeds and prints JSON report of missing, orphaned and mismatched feeds.
+Publications database is the source of truth, --fix repairs RSS Feeds service feeds to match it.
+Exits with code 2 if drift is left unrepaired.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			readConfig(cfgFile)
+			// stdout is reserved for the report
+			logger := newLogger(true)
+			defer logger.Sync()
+			db := newRepository(logger)
+
+			rssFeedsAPIURL := viper.GetString("rss_api_url")
+			rssFeedsAPIClient, err := rssAPIClient.New(rssFeedsAPIURL)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "FATAL: failure creating RSS API Client, ", err)
+				os.Exit(1)
+			}
+			client := &reconcilerRSSFeedsAPIClient{
+				RSSFeedsAPIClient: rssFeedsAPIClient,
+				getAllRSSFeeds: func(ctx context.Context) ([]reconciler.Feed, error) {
+					feeds, err := rssFeedsAPIClient.GetAllRSSFeeds(ctx)
+					if err != nil {
+						return nil, err
+					}
+					result := make([]reconciler.Feed, len(feeds))
+					for i, feed := range feeds {
+						result[i] = reconciler.Feed{PublicationUUID: feed.PublicationUUID, URL: feed.URL, LanguageCode: feed.LanguageCode}
+					}
+					return result, nil
+				},
+			}
+			report, err := reconciler.New(logger, db, client).Run(context.Background(), fix)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "FATAL: reconciliation failed, ", err)
+				os.Exit(1)
+			}
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(report); err != nil {
+				fmt.Fprintln(os.Stderr, "FATAL: failure writing report, ", err)
+				os.Exit(1)
+			}
+			if !report.InSync() {
+				os.Exit(2)
+			}
+		},
+	}
+	reconcileCmd.Flags().BoolVar(&fix, "fix", false, "repair RSS Feeds service feeds to match publications")
+	var purgeDays int
+	purgeCmd := &cobra.Command{
+		Use:   "purge",
+		Short: "Permanently remove deleted publishers and publications",
+		Long: `Removes publishers and publications deleted more than --days days ago, they can't be restored afterwards.
+Intended to be run on schedule, e.g. by Kubernetes CronJob.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if purgeDays < 1 {
+				fmt.Fprintln(os.Stderr, "FATAL: --days must be positive")
+				os.Exit(1)
+			}
+			readConfig(cfgFile)
+			logger := newLogger(false)
+			defer logger.Sync()
+			db := newRepository(logger)
+			deletedBefore := time.Now().AddDate(0, 0, -purgeDays)
+			publishers, publications, err := db.PurgeDeleted(context.Background(), deletedBefore)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "FATAL: failure purging deleted records, ", err)
+				os.Exit(1)
+			}
+			logger.Infof("Purged %d publishers and %d publications deleted before %s", publishers, publications, deletedBefore.Format(time.RFC3339))
+		},
+	}
+	purgeCmd.Flags().IntVar(&purgeDays, "days", 30, "remove records deleted more than this number of days ago")
+	apiKeysCmd := &cobra.Command{
+		Use:   "api-keys",
+		Short: "Manage API keys of clients",
+	}
+	var apiKeyName, apiKeyRole string
+	apiKeysCreateCmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create API key and print it, the key can't be shown again",
+		Run: func(cmd *cobra.Command, args []string) {
+			if apiKeyName == "" || !entity.IsRole(apiKeyRole) {
+				fmt.Fprintln(os.Stderr, "FATAL: --name and --role (reader, editor or admin) are required")
+				os.Exit(1)
+			}
+			readConfig(cfgFile)
+			logger := newLogger(true)
+			defer logger.Sync()
+			db := newRepository(logger)
+			key, secret, err := entity.NewAPIKey(apiKeyName, apiKeyRole)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "FATAL: failure generating API key, ", err)
+				os.Exit(1)
+			}
+			if err := db.CreateAPIKey(context.Background(), key); err != nil {
+				fmt.Fprintln(os.Stderr, "FATAL: failure creating API key, ", err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "Created API key %s (%s) with role %s\n", key.Name, key.UUID, key.Role)
+			fmt.Println(secret)
+		},
+	}
+	apiKeysCreateCmd.Flags().StringVar(&apiKeyName, "name", "", "name of API key client")
+	apiKeysCreateCmd.Flags().StringVar(&apiKeyRole, "role", entity.RoleReader, "role of API key: reader, editor or admin")
+	apiKeysListCmd := &cobra.Command{
+		Use:   "list",
+		Short: "Print API keys as JSON, keys themselves are not shown",
+		Run: func(cmd *cobra.Command, args []string) {
+			readConfig(cfgFile)
+			logger := newLogger(true)
+			defer logger.Sync()
+			db := newRepository(logger)
+			keys, err := db.GetAPIKeys(context.Background())
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "FATAL: failure getting API keys, ", err)
+				os.Exit(1)
+			}
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(keys); err != nil {
+				fmt.Fprintln(os.Stderr, "FATAL: failure writing API keys, ", err)
+				os.Exit(1)
+			}
+		},
+	}
+	apiKeysRevokeCmd := &cobra.Command{
+		Use:   "revoke API_KEY_UUID",
+		Short: "Revoke API key",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			keyUUID, err := uuid.FromString(args[0])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "FATAL: invalid API key uuid, ", err)
+				os.Exit(1)
+			}
+			readConfig(cfgFile)
+			logger := newLogger(true)
+			defer logger.Sync()
+			db := newRepository(logger)
+			if err := db.RevokeAPIKey(context.Background(), keyUUID); err != nil {
+				fmt.Fprintln(os.Stderr, "FATAL: failure revoking API key, ", err)
+				os.Exit(1)
+			}
+			fmt.Fprintln(os.Stderr, "Revoked API key", keyUUID)
+		},
+	}
+	apiKeysCmd.AddCommand(apiKeysCreateCmd, apiKeysListCmd, apiKeysRevokeCmd)
+
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is ./config.yaml)")
 	rootCmd.AddCommand(versionCmd)
+	rootCmd.AddCommand(reconcileCmd)
+	rootCmd.AddCommand(purgeCmd)
+	rootCmd.AddCommand(apiKeysCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 }
+
+// reconcilerRSSFeedsAPIClient adapts RSS Feeds API client to reconciler, since RSS Feeds entities can't be imported
+type reconcilerRSSFeedsAPIClient struct {
+	server.RSSFeedsAPIClient
+	getAllRSSFeeds func(context.Context) ([]reconciler.Feed, error)
+}
+
+func (c *reconcilerRSSFeedsAPIClient) GetAllRSSFeeds(ctx context.Context) ([]reconciler.Feed, error) {
+	return c.getAllRSSFeeds(ctx)
+}
+
+// readConfig reads config file into viper
+func readConfig(cfgFile string) {
+	if cfgFile != "" {
+		// Use config file from the flag.
+		viper.SetConfigFile(cfgFile)
+	} else {
+		viper.AddConfigPath(".")      // optionally look for config in the working directory
+		viper.SetConfigName("config") // name of config file (without extension)
+	}
+	// If the config file is found, read it in.
+	if err := viper.ReadInConfig(); err != nil {
+		fmt.Printf("FATAL: error in config file %s. %s", viper.ConfigFileUsed(), err)
+		os.Exit(1)
+	}
+	fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
+}
+
+// newLogger creates logger from 'logging' configuration, optionally forcing output to stderr
+func newLogger(toStderr bool) *zap.SugaredLogger {
+	logCfg := &zaplogger.Config{}
+	if err := viper.UnmarshalKey("logging", logCfg); err != nil {
+		fmt.Println("Failure reading 'logging' configuration:", err)
+		os.Exit(1)
+	}
+	if toStderr {
+		logCfg.OutputPaths = []string{"stderr"}
+	}
+	return zaplogger.New(logCfg).Sugar()
+}
+
+// newRepository opens db using 'database' configuration
+func newRepository(logger *zap.SugaredLogger) *postgresql.Repository {
+	databaseViperConfig := viper.Sub("database")
+	dbCfg := &postgresql.Config{}
+	if err := databaseViperConfig.UnmarshalExact(dbCfg); err != nil {
+		fmt.Println("FATAL: failure reading 'database' configuration: ", err)
+		os.Exit(1)
+	}
+	db, err := postgresql.New(dbCfg, postgresql.NewZapLogger(logger.Desugar()))
+	if err != nil {
+		fmt.Println("FATAL: failure creating database connection, ", err)
+		os.Exit(1)
+	}
+	return db
+}
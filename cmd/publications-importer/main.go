@@ -1,9 +1,14 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/Tarick/naca-publications/internal/application/importer"
 	"github.com/Tarick/naca-publications/internal/version"
@@ -13,44 +18,152 @@ import (
 )
 
 func main() {
-	var publicationsAPIURL string
+	var publicationsAPIURL, publicationsAPIToken string
+	var concurrency int
+	var upsert bool
+	var format string
+	var opmlOptions importer.OPMLOptions
+	// addAPIFlags adds flags of Publications API connection to command
+	addAPIFlags := func(cmd *cobra.Command) {
+		cmd.Flags().StringVar(&publicationsAPIURL, "url", "", "base URL to publications api, e.g. http://publication-api:8080")
+		cmd.MarkFlagRequired("url")
+		cmd.Flags().StringVar(&publicationsAPIToken, "token", os.Getenv("PUBLICATIONS_API_TOKEN"), "API key or JWT of editor, PUBLICATIONS_API_TOKEN environment variable by default")
+	}
 	// rootCmd represents the base command when called without any subcommands
 	rootCmd := &cobra.Command{
-		Use:     "publications-importer",
-		Short:   "Publications importer",
-		Long:    `Publication importer is used to import Publisher and their publications information. Requires running APIs, url to Publications API and accepts JSON filename as parameter.`,
-		Example: `publications-importer --url http://publications publications.json`,
+		Use:   "publications-importer",
+		Short: "Publications importer",
+		Long:  `Publication importer is used to import Publisher and their publications information. Requires running APIs, url to Publications API and accepts JSON or OPML filename as parameter.`,
+		Example: `publications-importer --url http://publications publications.json
+publications-importer --url http://publications --upsert --opml-language de feeds.opml`,
 		// Positional arg - one filename of the feed with entries
 		Args: cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			// Client for RSS Feeds API
-			if len(args) > 1 {
-				fmt.Println("Number of parameters more than 1, we accept only one filename: ", args)
+			ip := importer.Importer{
+				APIClient:   apiclient.NewWithToken(publicationsAPIURL, publicationsAPIToken),
+				Concurrency: concurrency,
+				Progress:    os.Stderr,
+				Upsert:      upsert,
+			}
+			err := ip.RunImport(interruptibleContext(), readEntries(args[0], format, opmlOptions))
+			if err != nil {
+				fmt.Println("Error running import: ", err)
 				os.Exit(1)
 			}
-			// Open filename and pass to importer
-			if fp, err := os.Open(args[0]); err == nil {
-				defer fp.Close()
-				bytes, _ := ioutil.ReadAll(fp)
-				ip := importer.Importer{
-					APIClient: apiclient.New(publicationsAPIURL),
-				}
-				err := ip.RunImport(bytes)
+		},
+	}
+	addAPIFlags(rootCmd)
+	rootCmd.PersistentFlags().StringVar(&format, "format", importer.FormatAuto, "format of the file: json, opml or auto to detect it by content")
+	rootCmd.PersistentFlags().StringVar(&opmlOptions.GroupBy, "opml-group-by", importer.OPMLGroupByOutline, "publishers of OPML feeds: outline groups, with feeds outside of groups by site host, or host of feed sites")
+	rootCmd.PersistentFlags().StringVar(&opmlOptions.LanguageCode, "opml-language", "en", "language code of OPML feeds without language attribute")
+	rootCmd.PersistentFlags().StringVar(&opmlOptions.Description, "opml-description", "", "description of OPML feeds without description attribute, feed title by default")
+	rootCmd.Flags().IntVar(&concurrency, "concurrency", 4, "number of publishers imported in parallel")
+	rootCmd.Flags().BoolVar(&upsert, "upsert", false, "update existing publishers, found by name or url, and their publications, found by name, instead of skipping them")
+
+	var prune, planJSON, autoApprove bool
+	var planPath string
+	planCmd := &cobra.Command{
+		Use:     "plan",
+		Short:   "Show changes making Publications API match the file",
+		Long:    `Compares publishers and publications of the file with Publications API and prints changes to create, update and, with --prune, delete them. Publishers are matched by name or url, publications by publisher and name.`,
+		Example: `publications-importer plan --url http://publications --prune --json publications.json`,
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ip := importer.Importer{APIClient: apiclient.NewWithToken(publicationsAPIURL, publicationsAPIToken)}
+			plan, err := ip.Plan(interruptibleContext(), readEntries(args[0], format, opmlOptions), prune)
+			if err != nil {
+				fmt.Println("Error planning changes: ", err)
+				os.Exit(1)
+			}
+			if planJSON {
+				err = plan.WriteJSON(os.Stdout)
+			} else {
+				err = plan.WriteText(os.Stdout)
+			}
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		},
+	}
+	addAPIFlags(planCmd)
+	planCmd.Flags().BoolVar(&prune, "prune", false, "delete publishers and publications missing in the file")
+	planCmd.Flags().BoolVar(&planJSON, "json", false, "print plan as JSON")
+	rootCmd.AddCommand(planCmd)
+
+	applyCmd := &cobra.Command{
+		Use:   "apply",
+		Short: "Make Publications API match the file",
+		Long: `Plans changes as plan command, prints them and applies them after confirmation.
+With --plan, applies plan saved by plan command with --json, if the file still results in the same plan, without confirmation.`,
+		Example: `publications-importer apply --url http://publications --prune --auto-approve publications.json
+publications-importer plan --url http://publications --json publications.json > plan.json
+publications-importer apply --url http://publications --plan plan.json publications.json`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := interruptibleContext()
+			ip := importer.Importer{APIClient: apiclient.NewWithToken(publicationsAPIURL, publicationsAPIToken)}
+			var saved *importer.Plan
+			if planPath != "" {
+				saved = readPlan(planPath)
+				prune = saved.Prune
+			}
+			plan, err := ip.Plan(ctx, readEntries(args[0], format, opmlOptions), prune)
+			if err != nil {
+				fmt.Println("Error planning changes: ", err)
+				os.Exit(1)
+			}
+			plan.WriteText(os.Stdout)
+			if saved != nil {
+				matches, err := saved.Matches(plan)
 				if err != nil {
-					fmt.Println("Error running import: ", err)
+					fmt.Println("Error comparing plans: ", err)
 					os.Exit(1)
 				}
-			} else if os.IsNotExist(err) {
-				fmt.Printf("Path '%s' does not exist", args[0])
+				// Publications API or the file has changed since plan was reviewed
+				if !matches {
+					fmt.Printf("\nPlan differs from saved plan %s, review the new plan and save it again.\n", planPath)
+					os.Exit(1)
+				}
+			}
+			if plan.Empty() {
+				return
+			}
+			if saved == nil && !autoApprove && !confirm("\nDo you want to apply these changes? Only 'yes' will be accepted: ") {
+				fmt.Println("Apply cancelled.")
 				os.Exit(1)
-			} else {
-				fmt.Println(err)
+			}
+			if err := ip.Apply(ctx, plan); err != nil {
+				fmt.Println("Error applying changes: ", err)
 				os.Exit(1)
 			}
 		},
 	}
-	rootCmd.Flags().StringVar(&publicationsAPIURL, "url", "", "base URL to publications api, e.g. http://publication-api:8080")
-	rootCmd.MarkFlagRequired("url")
+	addAPIFlags(applyCmd)
+	applyCmd.Flags().BoolVar(&prune, "prune", false, "delete publishers and publications missing in the file")
+	applyCmd.Flags().BoolVar(&autoApprove, "auto-approve", false, "apply changes without confirmation")
+	applyCmd.Flags().StringVar(&planPath, "plan", "", "apply reviewed plan saved by plan command with --json, --prune of the plan is used")
+	rootCmd.AddCommand(applyCmd)
+
+	validateCmd := &cobra.Command{
+		Use:     "validate",
+		Short:   "Validate the file without calling Publications API",
+		Long:    `Validates publishers and publications of the file with the same rules as Publications API and finds duplicates of publishers names and urls and of publications names of publisher. Prints every problem with its JSON path and exits with non-zero code, if any is found.`,
+		Example: `publications-importer validate publications.json`,
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			problems := importer.Validate(readEntries(args[0], format, opmlOptions))
+			for _, problem := range problems {
+				fmt.Println(problem)
+			}
+			if len(problems) > 0 {
+				fmt.Printf("Found %d problems in %s\n", len(problems), args[0])
+				os.Exit(1)
+			}
+			fmt.Println("File is valid:", args[0])
+		},
+	}
+	rootCmd.AddCommand(validateCmd)
 
 	versionCmd := &cobra.Command{
 		Use:   "version",
@@ -67,3 +180,58 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// readEntries returns entries of the file in format, exits if it cannot be read
+func readEntries(path string, format string, opmlOptions importer.OPMLOptions) []importer.Entrie {
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		fmt.Printf("Path '%s' does not exist\n", path)
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	entries, err := importer.ReadEntries(bytes, format, opmlOptions)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return entries
+}
+
+// readPlan returns plan saved to path, exits if it cannot be read
+func readPlan(path string) *importer.Plan {
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer file.Close()
+	plan, err := importer.ReadPlan(file)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return plan
+}
+
+// confirm asks user for confirmation on stdin
+func confirm(prompt string) bool {
+	fmt.Print(prompt)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(answer) == "yes"
+}
+
+// interruptibleContext returns context cancelled by Ctrl-C, second Ctrl-C terminates immediately
+func interruptibleContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signalChan
+		signal.Stop(signalChan)
+		fmt.Fprintln(os.Stderr, "\nInterrupted, cancelling import...")
+		cancel()
+	}()
+	return ctx
+}
@@ -15,6 +15,7 @@ require (
 	github.com/go-chi/stampede v0.4.4
 	github.com/go-ozzo/ozzo-validation/v4 v4.3.0
 	github.com/gofrs/uuid v4.0.0+incompatible
+	github.com/jackc/pgconn v1.8.0
 	github.com/jackc/pgx/v4 v4.10.1
 	github.com/prometheus/client_golang v1.9.0
 	github.com/spf13/cobra v1.1.1
@@ -0,0 +1,202 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+)
+
+// Dispatcher delivers outbox events to downstream services
+type Dispatcher struct {
+	config     Config
+	logger     Logger
+	repository OutboxRepository
+	deliveries map[string]Delivery
+}
+
+// Delivery delivers outbox event to backing service of publication type
+type Delivery func(context.Context, *entity.OutboxEvent) error
+
+// OutboxRepository stores events to be delivered
+type OutboxRepository interface {
+	ClaimOutboxEvents(context.Context, int, time.Duration) ([]*entity.OutboxEvent, error)
+	MarkOutboxEventDispatched(context.Context, *entity.OutboxEvent) error
+	MarkOutboxEventFailed(context.Context, *entity.OutboxEvent, time.Time) error
+	DeadLetterOutboxEvent(context.Context, *entity.OutboxEvent) error
+	CountOutboxEvents(context.Context) (int, int, error)
+}
+
+// errNoDelivery is returned when backing service of event publication type is not configured, such events are kept pending
+var errNoDelivery = errors.New("no delivery configured for publication type of outbox event")
+
+// Config defines dispatcher configuration, durations are in seconds
+type Config struct {
+	PollInterval int `mapstructure:"poll_interval"`
+	BatchSize    int `mapstructure:"batch_size"`
+	// Lease is time given to deliver claimed event, before it is available to other dispatchers again
+	Lease       int `mapstructure:"lease"`
+	MaxAttempts int `mapstructure:"max_attempts"`
+	MinBackoff  int `mapstructure:"min_backoff"`
+	MaxBackoff  int `mapstructure:"max_backoff"`
+	// DeliveryTimeout is deadline of downstream service call delivering event, Lease by default
+	DeliveryTimeout int `mapstructure:"delivery_timeout"`
+}
+
+// New creates dispatcher, which delivers events with deliveries by publication type name.
+// Events of publication types without delivery are kept pending until it is configured.
+func New(config Config, logger Logger, repository OutboxRepository, deliveries map[string]Delivery) (*Dispatcher, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &Dispatcher{
+		config:     config,
+		logger:     logger,
+		repository: repository,
+		deliveries: deliveries,
+	}, nil
+}
+
+// validate checks that polling, batches, leases, attempts and backoffs are positive, delivery timeout is optional
+func (c Config) validate() error {
+	for _, field := range []struct {
+		name  string
+		value int
+	}{
+		{"poll_interval", c.PollInterval},
+		{"batch_size", c.BatchSize},
+		{"lease", c.Lease},
+		{"max_attempts", c.MaxAttempts},
+		{"min_backoff", c.MinBackoff},
+		{"max_backoff", c.MaxBackoff},
+	} {
+		if field.value <= 0 {
+			return fmt.Errorf("outbox dispatcher %s must be positive, got %d", field.name, field.value)
+		}
+	}
+	if c.MaxBackoff < c.MinBackoff {
+		return fmt.Errorf("outbox dispatcher max_backoff %d must not be less than min_backoff %d", c.MaxBackoff, c.MinBackoff)
+	}
+	if c.DeliveryTimeout < 0 {
+		return fmt.Errorf("outbox dispatcher delivery_timeout must not be negative, got %d", c.DeliveryTimeout)
+	}
+	return nil
+}
+
+// Run polls outbox and delivers events until context is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	d.logger.Info("Starting outbox dispatcher")
+	ticker := time.NewTicker(time.Duration(d.config.PollInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		d.dispatch(ctx)
+		d.updateGauges(ctx)
+		select {
+		case <-ctx.Done():
+			d.logger.Info("Stopped outbox dispatcher")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatch delivers batches of claimed events until there are no more events due
+func (d *Dispatcher) dispatch(ctx context.Context) {
+	for ctx.Err() == nil {
+		events, err := d.repository.ClaimOutboxEvents(ctx, d.config.BatchSize, time.Duration(d.config.Lease)*time.Second)
+		if err != nil {
+			d.logger.Error("Failure claiming outbox events: ", err)
+			return
+		}
+		for _, event := range events {
+			d.process(ctx, event)
+		}
+		if len(events) < d.config.BatchSize {
+			return
+		}
+	}
+}
+
+// process delivers event and records result of delivery
+func (d *Dispatcher) process(ctx context.Context, event *entity.OutboxEvent) {
+	event.Attempts++
+	deliveryCtx, cancel := context.WithTimeout(ctx, d.deliveryTimeout())
+	err := d.deliver(deliveryCtx, event)
+	cancel()
+	if errors.Is(err, errNoDelivery) {
+		// Not a delivery failure, retry later without spending attempts
+		event.Attempts--
+		nextAttemptAt := time.Now().Add(time.Duration(d.config.MaxBackoff) * time.Second)
+		d.logger.Warn(fmt.Sprintf("Outbox event %v postponed until %v: %s", event, nextAttemptAt, err))
+		if err := d.repository.MarkOutboxEventFailed(ctx, event, nextAttemptAt); err != nil {
+			d.logger.Error(fmt.Sprintf("Failure postponing outbox event %v: %s", event, err))
+		}
+		return
+	}
+	if err == nil {
+		d.logger.Debug("Dispatched outbox event ", event)
+		eventsDispatched.WithLabelValues(event.Type).Inc()
+		if err := d.repository.MarkOutboxEventDispatched(ctx, event); err != nil {
+			d.logger.Error(fmt.Sprintf("Failure marking outbox event %v dispatched: %s", event, err))
+		}
+		return
+	}
+	event.LastError = err.Error()
+	eventsFailed.WithLabelValues(event.Type).Inc()
+	if event.Attempts >= d.config.MaxAttempts {
+		d.logger.Error(fmt.Sprintf("Outbox event %v delivery failed %d times, dead lettering: %s", event, event.Attempts, err))
+		eventsDeadLettered.WithLabelValues(event.Type).Inc()
+		if err := d.repository.DeadLetterOutboxEvent(ctx, event); err != nil {
+			d.logger.Error(fmt.Sprintf("Failure dead lettering outbox event %v: %s", event, err))
+		}
+		return
+	}
+	nextAttemptAt := time.Now().Add(d.backoff(event.Attempts))
+	d.logger.Warn(fmt.Sprintf("Outbox event %v delivery failed, retrying at %v: %s", event, nextAttemptAt, err))
+	if err := d.repository.MarkOutboxEventFailed(ctx, event, nextAttemptAt); err != nil {
+		d.logger.Error(fmt.Sprintf("Failure marking outbox event %v failed: %s", event, err))
+	}
+}
+
+// deliveryTimeout returns deadline of delivery, which is never longer than lease of claimed event
+func (d *Dispatcher) deliveryTimeout() time.Duration {
+	if d.config.DeliveryTimeout <= 0 || d.config.DeliveryTimeout > d.config.Lease {
+		return time.Duration(d.config.Lease) * time.Second
+	}
+	return time.Duration(d.config.DeliveryTimeout) * time.Second
+}
+
+// backoff returns exponential delay before next delivery attempt
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	backoff := time.Duration(d.config.MinBackoff) * time.Second
+	maxBackoff := time.Duration(d.config.MaxBackoff) * time.Second
+	for i := 1; i < attempts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		return maxBackoff
+	}
+	return backoff
+}
+
+// deliver calls backing service of event publication type
+func (d *Dispatcher) deliver(ctx context.Context, event *entity.OutboxEvent) error {
+	publicationType, _ := event.PublicationChange()
+	delivery, ok := d.deliveries[publicationType]
+	if !ok {
+		return errNoDelivery
+	}
+	return delivery(ctx, event)
+}
+
+func (d *Dispatcher) updateGauges(ctx context.Context) {
+	pending, deadLettered, err := d.repository.CountOutboxEvents(ctx)
+	if err != nil {
+		d.logger.Error("Failure counting outbox events: ", err)
+		return
+	}
+	eventsPending.Set(float64(pending))
+	eventsDeadLetteredStored.Set(float64(deadLettered))
+}
@@ -0,0 +1,171 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/gofrs/uuid"
+	"go.uber.org/zap"
+)
+
+// fakeOutboxRepository returns claimed events by batches and records results of their delivery
+type fakeOutboxRepository struct {
+	pending      []*entity.OutboxEvent
+	claims       []int
+	dispatched   []*entity.OutboxEvent
+	failed       []*entity.OutboxEvent
+	nextAttempts []time.Time
+	deadLettered []*entity.OutboxEvent
+}
+
+func (repo *fakeOutboxRepository) ClaimOutboxEvents(_ context.Context, limit int, _ time.Duration) ([]*entity.OutboxEvent, error) {
+	repo.claims = append(repo.claims, limit)
+	if limit > len(repo.pending) {
+		limit = len(repo.pending)
+	}
+	events := repo.pending[:limit]
+	repo.pending = repo.pending[limit:]
+	return events, nil
+}
+
+func (repo *fakeOutboxRepository) MarkOutboxEventDispatched(_ context.Context, e *entity.OutboxEvent) error {
+	repo.dispatched = append(repo.dispatched, e)
+	return nil
+}
+
+func (repo *fakeOutboxRepository) MarkOutboxEventFailed(_ context.Context, e *entity.OutboxEvent, nextAttemptAt time.Time) error {
+	repo.failed = append(repo.failed, e)
+	repo.nextAttempts = append(repo.nextAttempts, nextAttemptAt)
+	return nil
+}
+
+func (repo *fakeOutboxRepository) DeadLetterOutboxEvent(_ context.Context, e *entity.OutboxEvent) error {
+	repo.deadLettered = append(repo.deadLettered, e)
+	return nil
+}
+
+func (repo *fakeOutboxRepository) CountOutboxEvents(context.Context) (int, int, error) {
+	return len(repo.pending), len(repo.deadLettered), nil
+}
+
+var testConfig = Config{PollInterval: 1, BatchSize: 2, Lease: 30, MaxAttempts: 3, MinBackoff: 10, MaxBackoff: 60}
+
+func newEvent(eventType string, attempts int) *entity.OutboxEvent {
+	return &entity.OutboxEvent{AggregateUUID: uuid.Must(uuid.NewV4()), Type: eventType, Payload: []byte(`{}`), Attempts: attempts}
+}
+
+func TestDispatcherProcess(t *testing.T) {
+	errFailed := errors.New("service is down")
+	tests := []struct {
+		name             string
+		event            *entity.OutboxEvent
+		deliveryErr      error
+		wantDispatched   bool
+		wantDeadLettered bool
+		wantFailed       bool
+		wantAttempts     int
+		wantLastError    string
+		// wantDelay is the delay of the next attempt of failed event
+		wantDelay time.Duration
+	}{
+		{name: "delivered", event: newEvent("rss.created", 0),
+			wantDispatched: true, wantAttempts: 1},
+		{name: "first failure", event: newEvent("rss.created", 0), deliveryErr: errFailed,
+			wantFailed: true, wantAttempts: 1, wantLastError: errFailed.Error(), wantDelay: 10 * time.Second},
+		{name: "second failure backs off exponentially", event: newEvent("rss.created", 1), deliveryErr: errFailed,
+			wantFailed: true, wantAttempts: 2, wantLastError: errFailed.Error(), wantDelay: 20 * time.Second},
+		{name: "last failure is dead lettered", event: newEvent("rss.created", 2), deliveryErr: errFailed,
+			wantDeadLettered: true, wantAttempts: 3, wantLastError: errFailed.Error()},
+		{name: "no delivery postpones without attempt", event: newEvent("unknown.created", 1),
+			wantFailed: true, wantAttempts: 1, wantDelay: 60 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeOutboxRepository{}
+			deliveries := map[string]Delivery{
+				"rss": func(ctx context.Context, _ *entity.OutboxEvent) error {
+					if _, ok := ctx.Deadline(); !ok {
+						t.Error("delivery has no deadline")
+					}
+					return tt.deliveryErr
+				},
+			}
+			d, err := New(testConfig, zap.NewNop().Sugar(), repo, deliveries)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			d.process(context.Background(), tt.event)
+			if got := len(repo.dispatched) == 1; got != tt.wantDispatched {
+				t.Errorf("dispatched = %v, want %v", got, tt.wantDispatched)
+			}
+			if got := len(repo.deadLettered) == 1; got != tt.wantDeadLettered {
+				t.Errorf("dead lettered = %v, want %v", got, tt.wantDeadLettered)
+			}
+			if got := len(repo.failed) == 1; got != tt.wantFailed {
+				t.Fatalf("failed = %v, want %v", got, tt.wantFailed)
+			}
+			if tt.event.Attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", tt.event.Attempts, tt.wantAttempts)
+			}
+			if tt.event.LastError != tt.wantLastError {
+				t.Errorf("last error = %q, want %q", tt.event.LastError, tt.wantLastError)
+			}
+			if tt.wantFailed {
+				delay := repo.nextAttempts[0].Sub(start)
+				if delay < tt.wantDelay || delay > tt.wantDelay+time.Second {
+					t.Errorf("next attempt delay = %v, want %v", delay, tt.wantDelay)
+				}
+			}
+		})
+	}
+}
+
+func TestDispatcherBackoff(t *testing.T) {
+	d := &Dispatcher{config: testConfig}
+	for attempts, want := range map[int]time.Duration{1: 10 * time.Second, 2: 20 * time.Second, 3: 40 * time.Second, 4: 60 * time.Second, 10: 60 * time.Second} {
+		if got := d.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestDispatcherDispatchesBatchesUntilOutboxIsEmpty(t *testing.T) {
+	repo := &fakeOutboxRepository{}
+	for i := 0; i < 5; i++ {
+		repo.pending = append(repo.pending, newEvent("rss.updated", 0))
+	}
+	delivered := 0
+	d, err := New(testConfig, zap.NewNop().Sugar(), repo, map[string]Delivery{
+		"rss": func(context.Context, *entity.OutboxEvent) error {
+			delivered++
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.dispatch(context.Background())
+	if delivered != 5 || len(repo.dispatched) != 5 {
+		t.Errorf("delivered %d and dispatched %d events, want 5", delivered, len(repo.dispatched))
+	}
+	// The last batch is not full, so outbox is not claimed again
+	if len(repo.claims) != 3 {
+		t.Errorf("claimed %d batches, want 3", len(repo.claims))
+	}
+}
+
+func TestNewRejectsInvalidConfig(t *testing.T) {
+	for name, config := range map[string]Config{
+		"zero batch size":           {PollInterval: 1, BatchSize: 0, Lease: 30, MaxAttempts: 3, MinBackoff: 10, MaxBackoff: 60},
+		"max backoff less than min": {PollInterval: 1, BatchSize: 2, Lease: 30, MaxAttempts: 3, MinBackoff: 60, MaxBackoff: 10},
+		"negative delivery timeout": {PollInterval: 1, BatchSize: 2, Lease: 30, MaxAttempts: 3, MinBackoff: 10, MaxBackoff: 60, DeliveryTimeout: -1},
+	} {
+		if _, err := New(config, zap.NewNop().Sugar(), &fakeOutboxRepository{}, nil); err == nil {
+			t.Errorf("%s: config is accepted", name)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package dispatcher
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics are registered in default registry, exposed by API server on /metrics
+var (
+	eventsDispatched = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "publications",
+		Subsystem: "outbox",
+		Name:      "events_dispatched_total",
+		Help:      "Number of outbox events delivered to downstream services.",
+	}, []string{"event_type"})
+	eventsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "publications",
+		Subsystem: "outbox",
+		Name:      "event_delivery_failures_total",
+		Help:      "Number of failed outbox event delivery attempts.",
+	}, []string{"event_type"})
+	eventsDeadLettered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "publications",
+		Subsystem: "outbox",
+		Name:      "events_dead_lettered_total",
+		Help:      "Number of outbox events dead lettered after exhausting delivery attempts.",
+	}, []string{"event_type"})
+	eventsPending = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "publications",
+		Subsystem: "outbox",
+		Name:      "events_pending",
+		Help:      "Number of outbox events waiting for delivery.",
+	})
+	eventsDeadLetteredStored = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "publications",
+		Subsystem: "outbox",
+		Name:      "events_dead_lettered",
+		Help:      "Number of dead lettered outbox events stored in outbox.",
+	})
+)
@@ -1,5 +1,11 @@
 package importer
 
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
 type Publisher struct {
 	Name string `json:"name"`
 	URL  string `json:"url"`
@@ -23,3 +29,38 @@ type Entrie struct {
 	Publisher    Publisher     `json:"publisher"`
 	Publications []Publication `json:"publications"`
 }
+
+// Formats of import file
+const (
+	FormatAuto string = "auto"
+	FormatJSON string = "json"
+	FormatOPML string = "opml"
+)
+
+// ReadEntries parses import file in format, auto format is detected by content: XML is OPML, JSON otherwise.
+// OPML outlines are converted to entries with options.
+func ReadEntries(bytes []byte, format string, options OPMLOptions) ([]Entrie, error) {
+	if format == FormatAuto || format == "" {
+		format = detectFormat(bytes)
+	}
+	switch format {
+	case FormatJSON:
+		var entries []Entrie
+		if err := json.Unmarshal(bytes, &entries); err != nil {
+			return nil, fmt.Errorf("Cannot read json from file: %s", err)
+		}
+		return entries, nil
+	case FormatOPML:
+		return readOPML(bytes, options)
+	}
+	return nil, fmt.Errorf("unknown format %s, must be one of: %s, %s, %s", format, FormatAuto, FormatJSON, FormatOPML)
+}
+
+// detectFormat returns OPML for content starting with XML tag after byte order mark and spaces
+func detectFormat(bytes []byte) string {
+	content := strings.TrimLeft(strings.TrimPrefix(string(bytes), "\ufeff"), " \t\r\n")
+	if strings.HasPrefix(content, "<") {
+		return FormatOPML
+	}
+	return FormatJSON
+}
@@ -2,8 +2,12 @@ package importer
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	// "github.com/Tarick/naca-publications/internal/entity"
 
@@ -11,6 +15,9 @@ import (
 	"github.com/gofrs/uuid"
 )
 
+// progressInterval is how often progress line is updated
+const progressInterval = 500 * time.Millisecond
+
 type ImportError struct {
 	Publisher   Publisher
 	Publication Publication
@@ -24,54 +31,238 @@ func (e *ImportError) String() string {
 type PublicationsAPIClient interface {
 	CreatePublisher(ctx context.Context, name string, url string) (entity.Publisher, error)
 	CreatePublication(ctx context.Context, name string, description string, languageCode string, publisherUUID uuid.UUID, publicationType string, config interface{}) (entity.Publication, error)
+	GetPublishers(ctx context.Context) ([]entity.Publisher, error)
+	GetPublisher(ctx context.Context, publisherUUID uuid.UUID) (entity.Publisher, error)
+	GetPublisherPublications(ctx context.Context, publisherUUID uuid.UUID) ([]entity.Publication, error)
+	UpdatePublisher(ctx context.Context, publisherUUID uuid.UUID, name string, url string) (entity.Publisher, error)
+	UpdatePublication(ctx context.Context, publicationUUID uuid.UUID, name string, description string, languageCode string, publisherUUID uuid.UUID, publicationType string, config interface{}) (entity.Publication, error)
+	DeletePublisher(ctx context.Context, publisherUUID uuid.UUID) error
+	DeletePublication(ctx context.Context, publicationUUID uuid.UUID) error
 }
 
 type Importer struct {
 	APIClient PublicationsAPIClient
+	// Concurrency is number of entries imported in parallel, 1 if not set.
+	// Publications of entry are imported one by one after its publisher.
+	Concurrency int
+	// Progress is updated with processed, failed and skipped counts, if it is set
+	Progress io.Writer
+	// Upsert finds existing publishers by name or url and publications by publisher and name,
+	// creates missing and updates changed ones, reporting result of each
+	Upsert bool
+}
+
+// importResult is outcome of importing publisher or publication
+type importResult string
+
+const (
+	resultCreated   importResult = "created"
+	resultUpdated   importResult = "updated"
+	resultUnchanged importResult = "unchanged"
+	// resultSkipped is existing entity, which isn't updated without upsert, or publication of failed publisher
+	resultSkipped importResult = "skipped"
+	resultFailed  importResult = "failed"
+)
+
+// importStats counts processed publishers and publications by result
+type importStats struct {
+	total     int64
+	processed int64
+	created   int64
+	updated   int64
+	unchanged int64
+	skipped   int64
+	failed    int64
+	started   time.Time
 }
 
-// Actual importer
-func (ip *Importer) RunImport(bytes []byte) error {
-	var entries []Entrie
-	if err := json.Unmarshal(bytes, &entries); err != nil {
-		return fmt.Errorf("Cannot read json from file: %s", err)
+func (st *importStats) add(result importResult, count int64) {
+	atomic.AddInt64(&st.processed, count)
+	counter := map[importResult]*int64{
+		resultCreated:   &st.created,
+		resultUpdated:   &st.updated,
+		resultUnchanged: &st.unchanged,
+		resultSkipped:   &st.skipped,
+		resultFailed:    &st.failed,
+	}[result]
+	atomic.AddInt64(counter, count)
+}
+
+func (st *importStats) String() string {
+	return fmt.Sprintf("processed %d/%d: created %d, updated %d, unchanged %d, skipped %d, failed %d",
+		atomic.LoadInt64(&st.processed), st.total, atomic.LoadInt64(&st.created), atomic.LoadInt64(&st.updated),
+		atomic.LoadInt64(&st.unchanged), atomic.LoadInt64(&st.skipped), atomic.LoadInt64(&st.failed))
+}
+
+// progress returns counts with estimated time to finish import
+func (st *importStats) progress() string {
+	processed := atomic.LoadInt64(&st.processed)
+	eta := "unknown"
+	if processed > 0 {
+		elapsed := time.Since(st.started)
+		eta = (elapsed / time.Duration(processed) * time.Duration(st.total-processed)).Round(time.Second).String()
 	}
-	var importErrors []ImportError
-	fmt.Println("Starting processing of", len(entries), "entries")
+	return fmt.Sprintf("%s, ETA %s", st, eta)
+}
+
+// Actual importer of entries, read with ReadEntries. Cancelling ctx stops import, cancelling requests in flight.
+func (ip *Importer) RunImport(ctx context.Context, entries []Entrie) error {
+	stats := &importStats{started: time.Now()}
 	for _, entrie := range entries {
-		ctx := context.Background()
-		publisher, err := ip.APIClient.CreatePublisher(ctx, entrie.Publisher.Name, entrie.Publisher.URL)
+		stats.total += int64(1 + len(entrie.Publications))
+	}
+	concurrency := ip.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var existing *existingPublishers
+	if ip.Upsert {
+		publishers, err := ip.APIClient.GetPublishers(ctx)
 		if err != nil {
-			importErrors = append(importErrors, ImportError{
-				Publisher: entrie.Publisher,
-				Error:     err,
-			})
-			continue
+			return fmt.Errorf("failure getting existing publishers: %w", err)
 		}
-		for _, publication := range entrie.Publications {
-			ctx := context.Background()
-			if _, err := ip.APIClient.CreatePublication(
-				ctx,
-				publication.Name,
-				publication.Description,
-				publication.LanguageCode,
-				publisher.UUID,
-				publication.Type,
-				publication.Config); err != nil {
-				importErrors = append(importErrors, ImportError{
-					Publisher:   entrie.Publisher,
-					Publication: publication,
-					Error:       err,
-				})
+		existing = newExistingPublishers(publishers)
+	}
+	fmt.Println("Starting processing of", len(entries), "entries with concurrency", concurrency)
+	var (
+		importErrors []ImportError
+		reports      = make([][]upsertReport, len(entries))
+		mu           sync.Mutex
+		wg           sync.WaitGroup
+	)
+	jobs := make(chan int)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				var errs []ImportError
+				if ip.Upsert {
+					reports[i], errs = ip.upsertEntrie(ctx, entries[i], existing, stats)
+				} else {
+					errs = ip.importEntrie(ctx, entries[i], stats)
+				}
+				if len(errs) > 0 {
+					mu.Lock()
+					importErrors = append(importErrors, errs...)
+					mu.Unlock()
+				}
 			}
+		}()
+	}
+	stopProgress := ip.reportProgress(stats)
+feed:
+	for i := range entries {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
 		}
 	}
-	if len(importErrors) > 0 {
-		for _, importError := range importErrors {
-			fmt.Println(importError)
+	close(jobs)
+	wg.Wait()
+	stopProgress()
+
+	// Upsert reports are printed in order of entries
+	for _, entrieReports := range reports {
+		for _, report := range entrieReports {
+			fmt.Println(report)
 		}
+	}
+	for _, importError := range importErrors {
+		fmt.Println(importError)
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("import cancelled, %s", stats)
+	}
+	if len(importErrors) > 0 {
 		return fmt.Errorf("import failed for %d entries", len(importErrors))
 	}
-	fmt.Println("Import finished successfully")
+	fmt.Println("Import finished successfully,", stats)
 	return nil
 }
+
+// importEntrie creates publisher and then its publications. Existing ones are skipped, publications of failed publisher as well.
+// Requests failed due to cancellation are not counted.
+func (ip *Importer) importEntrie(ctx context.Context, entrie Entrie, stats *importStats) []ImportError {
+	if ctx.Err() != nil {
+		return nil
+	}
+	publisher, err := ip.APIClient.CreatePublisher(ctx, entrie.Publisher.Name, entrie.Publisher.URL)
+	// Existing publisher is already imported, its publications are added to it
+	var conflict *entity.ConflictError
+	if errors.As(err, &conflict) && conflict.UUID != uuid.Nil {
+		stats.add(resultSkipped, 1)
+		publisher.UUID, err = conflict.UUID, nil
+	} else if err == nil {
+		stats.add(resultCreated, 1)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		stats.add(resultFailed, 1)
+		stats.add(resultSkipped, int64(len(entrie.Publications)))
+		return []ImportError{{
+			Publisher: entrie.Publisher,
+			Error:     err,
+		}}
+	}
+	var importErrors []ImportError
+	for _, publication := range entrie.Publications {
+		if ctx.Err() != nil {
+			return importErrors
+		}
+		_, err := ip.APIClient.CreatePublication(
+			ctx,
+			publication.Name,
+			publication.Description,
+			publication.LanguageCode,
+			publisher.UUID,
+			publication.Type,
+			publication.Config)
+		switch {
+		case errors.Is(err, entity.ErrAlreadyExists):
+			stats.add(resultSkipped, 1)
+		case err == nil:
+			stats.add(resultCreated, 1)
+		case ctx.Err() != nil:
+			return importErrors
+		default:
+			stats.add(resultFailed, 1)
+			importErrors = append(importErrors, ImportError{
+				Publisher:   entrie.Publisher,
+				Publication: publication,
+				Error:       err,
+			})
+		}
+	}
+	return importErrors
+}
+
+// reportProgress updates progress line until returned function is called
+func (ip *Importer) reportProgress(stats *importStats) func() {
+	if ip.Progress == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fmt.Fprintf(ip.Progress, "\r%s", stats.progress())
+			case <-done:
+				fmt.Fprintf(ip.Progress, "\r%s\n", stats.progress())
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
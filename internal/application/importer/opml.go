@@ -0,0 +1,244 @@
+package importer
+
+// This file contains conversion of OPML subscription lists of feed readers to entries
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Tarick/naca-publications/internal/spec"
+)
+
+// Ways to find publishers of OPML feeds
+const (
+	// OPMLGroupByOutline makes publisher of each outline group, feeds outside of groups are grouped by host
+	OPMLGroupByOutline string = "outline"
+	// OPMLGroupByHost makes publisher of each host of feeds web site, groups are ignored
+	OPMLGroupByHost string = "host"
+)
+
+// OPMLOptions define conversion of OPML feeds to publishers and RSS publications
+type OPMLOptions struct {
+	// GroupBy is OPMLGroupByOutline or OPMLGroupByHost
+	GroupBy string
+	// LanguageCode of publications, which have no language attribute
+	LanguageCode string
+	// Description of publications, which have no description attribute. Title of feed is used, if it is empty.
+	Description string
+}
+
+type opmlDocument struct {
+	XMLName  xml.Name      `xml:"opml"`
+	Outlines []opmlOutline `xml:"body>outline"`
+}
+
+// opmlOutline is either feed with xmlUrl or group of outlines
+type opmlOutline struct {
+	Text        string        `xml:"text,attr"`
+	Title       string        `xml:"title,attr"`
+	XMLURL      string        `xml:"xmlUrl,attr"`
+	HTMLURL     string        `xml:"htmlUrl,attr"`
+	Description string        `xml:"description,attr"`
+	Language    string        `xml:"language,attr"`
+	Outlines    []opmlOutline `xml:"outline"`
+}
+
+func (o *opmlOutline) name() string {
+	if o.Title != "" {
+		return o.Title
+	}
+	return o.Text
+}
+
+// opmlEntries collects entries in order of their first feeds, publishers are identified by key
+type opmlEntries struct {
+	options OPMLOptions
+	entries []Entrie
+	index   map[string]int
+	// feeds are xmlUrls added to entry, by entry index
+	feeds map[int]map[string]bool
+	// names are publications names of entry, by entry index
+	names map[int]map[string]bool
+	// hosts are URLs of feeds sites of outline groups without URL, by entry index. URL of group is chosen from them.
+	hosts map[int][]string
+}
+
+// readOPML converts feeds of OPML document to RSS publications of publishers found by options.GroupBy.
+// Feed is added to publisher only once, if it's listed several times, publications with the same name are numbered.
+// Publishers URLs are unique, document with groups, which don't get URL of their own, is an error.
+func readOPML(content []byte, options OPMLOptions) ([]Entrie, error) {
+	if options.GroupBy == "" {
+		options.GroupBy = OPMLGroupByOutline
+	}
+	if options.GroupBy != OPMLGroupByOutline && options.GroupBy != OPMLGroupByHost {
+		return nil, fmt.Errorf("unknown OPML grouping %s, must be one of: %s, %s", options.GroupBy, OPMLGroupByOutline, OPMLGroupByHost)
+	}
+	document := opmlDocument{}
+	// Only UTF-8 documents are supported
+	if err := xml.NewDecoder(bytes.NewReader(content)).Decode(&document); err != nil {
+		return nil, fmt.Errorf("Cannot read opml from file: %s", err)
+	}
+	collected := &opmlEntries{
+		options: options,
+		index:   map[string]int{},
+		feeds:   map[int]map[string]bool{},
+		names:   map[int]map[string]bool{},
+		hosts:   map[int][]string{},
+	}
+	for _, outline := range document.Outlines {
+		if err := collected.add(outline, nil); err != nil {
+			return nil, err
+		}
+	}
+	if err := collected.setGroupsURLs(); err != nil {
+		return nil, err
+	}
+	return collected.entries, nil
+}
+
+// add adds feed or feeds of group. group is the closest outline group of feed, nil for top level feeds.
+func (c *opmlEntries) add(outline opmlOutline, group *opmlOutline) error {
+	if outline.XMLURL == "" {
+		for _, child := range outline.Outlines {
+			if err := c.add(child, &outline); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	siteURL := outline.HTMLURL
+	if siteURL == "" {
+		siteURL = outline.XMLURL
+	}
+	host, err := hostURL(siteURL)
+	if err != nil {
+		return fmt.Errorf("feed %q: %w", outline.name(), err)
+	}
+	var key string
+	var publisher Publisher
+	if group != nil && c.options.GroupBy == OPMLGroupByOutline {
+		// Group has no url of its own usually, so it is chosen from its feeds sites by setGroupsURLs
+		key = "outline:" + group.name()
+		publisher = Publisher{Name: group.name(), URL: group.HTMLURL}
+	} else {
+		// Publishers of http and https, with and without www, sites are the same
+		name := strings.TrimPrefix(host.Hostname(), "www.")
+		key = "host:" + name
+		publisher = Publisher{Name: name, URL: host.String()}
+	}
+	i, ok := c.index[key]
+	if !ok {
+		i = len(c.entries)
+		c.index[key] = i
+		c.entries = append(c.entries, Entrie{Publisher: publisher, Publications: []Publication{}})
+		c.feeds[i] = map[string]bool{}
+		c.names[i] = map[string]bool{}
+	}
+	if c.feeds[i][outline.XMLURL] {
+		return nil
+	}
+	c.feeds[i][outline.XMLURL] = true
+	if c.entries[i].Publisher.URL == "" {
+		c.hosts[i] = appendNew(c.hosts[i], host.String())
+	}
+	publication := c.publication(outline)
+	publication.Name = uniqueName(c.names[i], publication.Name)
+	c.entries[i].Publications = append(c.entries[i].Publications, publication)
+	return nil
+}
+
+// setGroupsURLs sets URL of outline groups without URL to the first site of their feeds, which is not URL of other publisher.
+// Publishers with the same URL are an error.
+func (c *opmlEntries) setGroupsURLs() error {
+	// publishers indexes by URLs
+	urls := map[string]int{}
+	for i, entrie := range c.entries {
+		if entrie.Publisher.URL == "" {
+			continue
+		}
+		if j, ok := urls[entrie.Publisher.URL]; ok {
+			return fmt.Errorf("publishers %q and %q have the same url %s", c.entries[j].Publisher.Name, entrie.Publisher.Name, entrie.Publisher.URL)
+		}
+		urls[entrie.Publisher.URL] = i
+	}
+	for i := range c.entries {
+		publisher := &c.entries[i].Publisher
+		if publisher.URL != "" {
+			continue
+		}
+		for _, host := range c.hosts[i] {
+			if _, ok := urls[host]; !ok {
+				publisher.URL = host
+				urls[host] = i
+				break
+			}
+		}
+		if publisher.URL == "" {
+			return fmt.Errorf("group %q has no url and sites of its feeds are urls of other publishers, e.g. %q: set htmlUrl of the group outline or group by host",
+				publisher.Name, c.entries[urls[c.hosts[i][0]]].Publisher.Name)
+		}
+	}
+	return nil
+}
+
+// uniqueName returns name, numbered if it is in names already, and adds it to names
+func uniqueName(names map[string]bool, name string) string {
+	unique := name
+	for n := 2; names[unique]; n++ {
+		unique = fmt.Sprintf("%s (%d)", name, n)
+	}
+	names[unique] = true
+	return unique
+}
+
+// appendNew appends value to values, if it's not there yet
+func appendNew(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+// publication returns RSS publication of feed, missing name, description and language are taken from options or feed
+func (c *opmlEntries) publication(outline opmlOutline) Publication {
+	name := outline.name()
+	if name == "" {
+		name = outline.XMLURL
+	}
+	description := outline.Description
+	if description == "" {
+		description = c.options.Description
+	}
+	if description == "" {
+		description = name
+	}
+	// Language of OPML is language tag, e.g. en-us
+	languageCode := c.options.LanguageCode
+	if language := strings.ToLower(strings.TrimSpace(outline.Language)); len(language) >= 2 {
+		languageCode = language[:2]
+	}
+	return Publication{
+		Name:         name,
+		Description:  description,
+		LanguageCode: languageCode,
+		Type:         spec.PublicationTypeRSS,
+		Config:       &spec.RSSPublicationConfig{URL: outline.XMLURL},
+	}
+}
+
+// hostURL returns scheme and host of absolute URL
+func hostURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("url %s is not absolute", rawURL)
+	}
+	return &url.URL{Scheme: u.Scheme, Host: u.Host}, nil
+}
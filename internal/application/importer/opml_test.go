@@ -0,0 +1,187 @@
+package importer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Tarick/naca-publications/internal/spec"
+)
+
+// describeEntries returns publishers and their publications, one per line
+func describeEntries(entries []Entrie) []string {
+	lines := []string{}
+	for _, entrie := range entries {
+		lines = append(lines, fmt.Sprintf("%s %s", entrie.Publisher.Name, entrie.Publisher.URL))
+		for _, publication := range entrie.Publications {
+			config, _ := publication.Config.(*spec.RSSPublicationConfig)
+			if publication.Type != spec.PublicationTypeRSS || config == nil {
+				lines = append(lines, fmt.Sprintf("\tunexpected publication %+v", publication))
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("\t%s|%s|%s|%s", publication.Name, publication.Description, publication.LanguageCode, config.URL))
+		}
+	}
+	return lines
+}
+
+func opml(outlines string) []byte {
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?><opml version="2.0"><head><title>Feeds</title></head><body>` + outlines + `</body></opml>`)
+}
+
+func TestReadOPML(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+		options OPMLOptions
+		want    []string
+		wantErr string
+	}{
+		{name: "groups and feeds outside of groups",
+			content: opml(`
+				<outline text="Tech">
+					<outline text="Go Blog" type="rss" xmlUrl="https://go.dev/blog/feed.atom" htmlUrl="https://go.dev/blog" description="Go news" language="en-US"/>
+					<outline title="LWN.net" text="lwn" xmlUrl="https://lwn.net/headlines/rss"/>
+				</outline>
+				<outline text="Example" xmlUrl="http://www.example.com/feed.xml"/>
+				<outline xmlUrl="https://example.com/other.xml"/>`),
+			options: OPMLOptions{LanguageCode: "de"},
+			want: []string{
+				"Tech https://go.dev",
+				"\tGo Blog|Go news|en|https://go.dev/blog/feed.atom",
+				"\tLWN.net|LWN.net|de|https://lwn.net/headlines/rss",
+				"example.com http://www.example.com",
+				"\tExample|Example|de|http://www.example.com/feed.xml",
+				"\thttps://example.com/other.xml|https://example.com/other.xml|de|https://example.com/other.xml",
+			}},
+		{name: "group by host",
+			content: opml(`
+				<outline text="Tech">
+					<outline text="Go Blog" xmlUrl="https://go.dev/blog/feed.atom" htmlUrl="https://go.dev/blog"/>
+					<outline text="LWN" xmlUrl="https://lwn.net/headlines/rss"/>
+				</outline>
+				<outline text="Go Releases" xmlUrl="https://go.dev/releases.atom"/>
+				<outline text="Go Security" xmlUrl="http://www.go.dev/security.atom"/>`),
+			options: OPMLOptions{GroupBy: OPMLGroupByHost, LanguageCode: "en", Description: "Imported"},
+			want: []string{
+				"go.dev https://go.dev",
+				"\tGo Blog|Imported|en|https://go.dev/blog/feed.atom",
+				"\tGo Releases|Imported|en|https://go.dev/releases.atom",
+				"\tGo Security|Imported|en|http://www.go.dev/security.atom",
+				"lwn.net https://lwn.net",
+				"\tLWN|Imported|en|https://lwn.net/headlines/rss",
+			}},
+		{name: "feeds of nested groups",
+			content: opml(`
+				<outline text="Tech">
+					<outline text="Feed A" xmlUrl="https://a.com/feed"/>
+					<outline text="Languages"><outline text="Feed B" xmlUrl="https://b.com/feed"/></outline>
+				</outline>`),
+			options: OPMLOptions{LanguageCode: "en"},
+			want: []string{
+				"Tech https://a.com",
+				"\tFeed A|Feed A|en|https://a.com/feed",
+				"Languages https://b.com",
+				"\tFeed B|Feed B|en|https://b.com/feed",
+			}},
+		{name: "repeated feeds and groups",
+			content: opml(`
+				<outline text="Tech"><outline text="Go Blog" xmlUrl="https://go.dev/blog/feed.atom"/></outline>
+				<outline text="Tech"><outline text="Go Blog again" xmlUrl="https://go.dev/blog/feed.atom"/></outline>`),
+			options: OPMLOptions{LanguageCode: "en"},
+			want: []string{
+				"Tech https://go.dev",
+				"\tGo Blog|Go Blog|en|https://go.dev/blog/feed.atom",
+			}},
+		{name: "duplicate publication names are numbered",
+			content: opml(`
+				<outline text="Blogs">
+					<outline text="Comments" xmlUrl="https://a.com/comments.xml"/>
+					<outline text="Comments" xmlUrl="https://b.com/comments.xml"/>
+					<outline text="Comments (2)" xmlUrl="https://c.com/comments.xml"/>
+				</outline>
+				<outline text="Comments" xmlUrl="https://d.com/comments.xml"/>`),
+			options: OPMLOptions{LanguageCode: "en"},
+			want: []string{
+				"Blogs https://a.com",
+				"\tComments|Comments|en|https://a.com/comments.xml",
+				"\tComments (2)|Comments|en|https://b.com/comments.xml",
+				"\tComments (2) (2)|Comments (2)|en|https://c.com/comments.xml",
+				"d.com https://d.com",
+				"\tComments|Comments|en|https://d.com/comments.xml",
+			}},
+		{name: "groups get urls of other feeds sites, if first is taken",
+			content: opml(`
+				<outline text="Feedburner"><outline text="Feed A" xmlUrl="https://feeds.example.com/a"/></outline>
+				<outline text="Mixed">
+					<outline text="Feed B" xmlUrl="https://feeds.example.com/b"/>
+					<outline text="Feed C" xmlUrl="https://c.com/feed"/>
+				</outline>
+				<outline text="Own site" xmlUrl="https://own.com/feed"/>`),
+			options: OPMLOptions{LanguageCode: "en"},
+			want: []string{
+				"Feedburner https://feeds.example.com",
+				"\tFeed A|Feed A|en|https://feeds.example.com/a",
+				"Mixed https://c.com",
+				"\tFeed B|Feed B|en|https://feeds.example.com/b",
+				"\tFeed C|Feed C|en|https://c.com/feed",
+				"own.com https://own.com",
+				"\tOwn site|Own site|en|https://own.com/feed",
+			}},
+		{name: "group url from outline",
+			content: opml(`<outline text="Tech" htmlUrl="https://tech.example.com"><outline text="Feed A" xmlUrl="https://a.com/feed"/></outline>`),
+			options: OPMLOptions{LanguageCode: "en"},
+			want: []string{
+				"Tech https://tech.example.com",
+				"\tFeed A|Feed A|en|https://a.com/feed",
+			}},
+		{name: "groups without url of their own",
+			content: opml(`
+				<outline text="Tech"><outline text="Feed A" xmlUrl="https://feeds.example.com/a"/></outline>
+				<outline text="News"><outline text="Feed B" xmlUrl="https://feeds.example.com/b"/></outline>`),
+			options: OPMLOptions{LanguageCode: "en"},
+			wantErr: `group "News" has no url and sites of its feeds are urls of other publishers, e.g. "Tech": set htmlUrl of the group outline or group by host`},
+		{name: "group with url of other publisher",
+			content: opml(`
+				<outline text="Tech"><outline text="Feed A" xmlUrl="https://a.com/feed" htmlUrl="https://own.com"/></outline>
+				<outline text="Own site" xmlUrl="https://own.com/feed"/>`),
+			options: OPMLOptions{LanguageCode: "en"},
+			wantErr: `group "Tech" has no url and sites of its feeds are urls of other publishers, e.g. "own.com": set htmlUrl of the group outline or group by host`},
+		{name: "groups with the same url",
+			content: opml(`
+				<outline text="Tech" htmlUrl="https://example.com"><outline text="Feed A" xmlUrl="https://a.com/feed"/></outline>
+				<outline text="News" htmlUrl="https://example.com"><outline text="Feed B" xmlUrl="https://b.com/feed"/></outline>`),
+			options: OPMLOptions{LanguageCode: "en"},
+			wantErr: `publishers "Tech" and "News" have the same url https://example.com`},
+		{name: "relative feed url",
+			content: opml(`<outline text="Relative" xmlUrl="/feed.xml"/>`),
+			wantErr: `feed "Relative": url /feed.xml is not absolute`},
+		{name: "unknown grouping",
+			content: opml(``),
+			options: OPMLOptions{GroupBy: "tag"},
+			wantErr: "unknown OPML grouping tag, must be one of: outline, host"},
+		{name: "not OPML",
+			content: []byte(`<rss version="2.0"></rss>`),
+			wantErr: "Cannot read opml from file: expected element type <opml> but have <rss>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := readOPML(tt.content, tt.options)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := describeEntries(entries); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("entries:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+			if problems := Validate(entries); len(problems) != 0 {
+				t.Errorf("problems of entries: %v", problems)
+			}
+		})
+	}
+}
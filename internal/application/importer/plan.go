@@ -0,0 +1,511 @@
+package importer
+
+// This file contains declarative sync: plan of changes making Publications API match the file, and its apply
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/gofrs/uuid"
+)
+
+// PlanAction is change of publisher or publication
+type PlanAction string
+
+const (
+	PlanCreate PlanAction = "create"
+	PlanUpdate PlanAction = "update"
+	PlanDelete PlanAction = "delete"
+)
+
+// Kinds of changed entities
+const (
+	PlanKindPublisher   string = "publisher"
+	PlanKindPublication string = "publication"
+)
+
+// FieldChange is stored and desired value of changed field
+type FieldChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// PlanChange is one change of plan. Entities are identified by UUID, if they exist, and by names.
+// Desired state of entity is applied on create and update, so plan saved as JSON can be applied.
+type PlanChange struct {
+	Action PlanAction `json:"action"`
+	Kind   string     `json:"kind"`
+	// UUID of updated or deleted entity
+	UUID *uuid.UUID `json:"uuid,omitempty"`
+	// Publisher is publisher name, of publication as well
+	Publisher string `json:"publisher"`
+	// PublisherUUID is UUID of existing publisher of publication
+	PublisherUUID *uuid.UUID `json:"publisher_uuid,omitempty"`
+	Publication   string     `json:"publication,omitempty"`
+	// Fields are created or changed fields, by their names in API
+	Fields map[string]FieldChange `json:"fields,omitempty"`
+	// DesiredPublisher is set on create and update of publisher
+	DesiredPublisher *Publisher `json:"desired_publisher,omitempty"`
+	// DesiredPublication is set on create and update of publication
+	DesiredPublication *Publication `json:"desired_publication,omitempty"`
+}
+
+// PlanSummary counts changes by action
+type PlanSummary struct {
+	Create int `json:"create"`
+	Update int `json:"update"`
+	Delete int `json:"delete"`
+}
+
+// Plan is ordered list of changes, which makes Publications API match the file.
+// Publications of publisher are changed after it, deletes of publishers not in the file are last.
+type Plan struct {
+	Prune   bool         `json:"prune"`
+	Changes []PlanChange `json:"changes"`
+	Summary PlanSummary  `json:"summary"`
+}
+
+func (p *Plan) add(change PlanChange) {
+	p.Changes = append(p.Changes, change)
+	switch change.Action {
+	case PlanCreate:
+		p.Summary.Create++
+	case PlanUpdate:
+		p.Summary.Update++
+	case PlanDelete:
+		p.Summary.Delete++
+	}
+}
+
+// Empty is true when there's nothing to change
+func (p *Plan) Empty() bool {
+	return len(p.Changes) == 0
+}
+
+// Matches compares plans as JSON documents, so that plan read from JSON matches the same fresh plan
+func (p *Plan) Matches(other *Plan) (bool, error) {
+	a, err := planDocument(p)
+	if err != nil {
+		return false, err
+	}
+	b, err := planDocument(other)
+	if err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(a, b), nil
+}
+
+// planDocument returns plan as generic JSON value
+func planDocument(p *Plan) (interface{}, error) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	var document interface{}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+// ReadPlan reads plan written by WriteJSON. Changes are checked to be applicable: of known kinds and actions,
+// with UUIDs of changed entities and desired states, and publications are created under existing or created publishers.
+func ReadPlan(r io.Reader) (*Plan, error) {
+	plan := &Plan{}
+	if err := json.NewDecoder(r).Decode(plan); err != nil {
+		return nil, fmt.Errorf("cannot read plan: %w", err)
+	}
+	createdPublishers := map[string]bool{}
+	for i, change := range plan.Changes {
+		if err := change.validate(createdPublishers); err != nil {
+			return nil, fmt.Errorf("cannot read plan: change %d: %w", i, err)
+		}
+		if change.Kind == PlanKindPublisher && change.Action == PlanCreate {
+			createdPublishers[change.Publisher] = true
+		}
+	}
+	return plan, nil
+}
+
+// validate checks that change can be applied after publishers of createdPublishers names are created
+func (c PlanChange) validate(createdPublishers map[string]bool) error {
+	if c.Kind != PlanKindPublisher && c.Kind != PlanKindPublication {
+		return fmt.Errorf("unknown kind %q, must be %s or %s", c.Kind, PlanKindPublisher, PlanKindPublication)
+	}
+	if _, ok := planSymbols[c.Action]; !ok {
+		return fmt.Errorf("unknown action %q of %s, must be %s, %s or %s", c.Action, c.Kind, PlanCreate, PlanUpdate, PlanDelete)
+	}
+	if (c.Action == PlanDelete || c.Action == PlanUpdate) && c.UUID == nil {
+		return fmt.Errorf("%s of %s without uuid", c.Action, c.Kind)
+	}
+	if c.Action == PlanCreate || c.Action == PlanUpdate {
+		if (c.Kind == PlanKindPublisher && c.DesiredPublisher == nil) ||
+			(c.Kind == PlanKindPublication && c.DesiredPublication == nil) {
+			return fmt.Errorf("%s of %s without desired state", c.Action, c.Kind)
+		}
+	}
+	if c.Kind == PlanKindPublication && c.Action == PlanUpdate && c.PublisherUUID == nil {
+		return fmt.Errorf("update of publication %q without publisher uuid", c.Publication)
+	}
+	if c.Kind == PlanKindPublication && c.Action == PlanCreate && c.PublisherUUID == nil && !createdPublishers[c.Publisher] {
+		return fmt.Errorf("create of publication %q without publisher uuid, publisher %q isn't created before it", c.Publication, c.Publisher)
+	}
+	return nil
+}
+
+// Plan compares entries of file to publishers and publications of Publications API.
+// Publishers are matched by name or url, publications by publisher and name, as in upsert.
+// With prune, publishers and publications missing in the file are deleted.
+// Files with duplicate publishers names or urls, or publications names of publisher are rejected.
+func (ip *Importer) Plan(ctx context.Context, entries []Entrie, prune bool) (*Plan, error) {
+	if problems := duplicateProblems(entries); len(problems) > 0 {
+		descriptions := make([]string, len(problems))
+		for i, problem := range problems {
+			descriptions[i] = problem.String()
+		}
+		return nil, fmt.Errorf("file has duplicates: %s", strings.Join(descriptions, "; "))
+	}
+	publishers, err := ip.APIClient.GetPublishers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failure getting existing publishers: %w", err)
+	}
+	existing := newExistingPublishers(publishers)
+	plan := &Plan{Prune: prune}
+	// matched are existing publishers of entries by UUID, with index of entry
+	matched := map[uuid.UUID]int{}
+	for i, entrie := range entries {
+		found, err := existing.find(entrie.Publisher.Name, entrie.Publisher.URL)
+		if err != nil {
+			return nil, fmt.Errorf("publisher %q of entry %d: %w", entrie.Publisher.Name, i, err)
+		}
+		if found == nil {
+			plan.add(PlanChange{
+				Action:    PlanCreate,
+				Kind:      PlanKindPublisher,
+				Publisher: entrie.Publisher.Name,
+				Fields: map[string]FieldChange{
+					"name": {New: entrie.Publisher.Name},
+					"url":  {New: entrie.Publisher.URL},
+				},
+				DesiredPublisher: &Publisher{Name: entrie.Publisher.Name, URL: entrie.Publisher.URL},
+			})
+			for _, publication := range entrie.Publications {
+				plan.add(publicationCreateChange(entrie.Publisher.Name, nil, publication))
+			}
+			continue
+		}
+		if j, ok := matched[found.UUID]; ok {
+			return nil, fmt.Errorf("publisher %q of entry %d matches the same publisher %s as entry %d", entrie.Publisher.Name, i, found.UUID, j)
+		}
+		matched[found.UUID] = i
+		publisherUUID := found.UUID
+		fields := map[string]FieldChange{}
+		if found.Name != entrie.Publisher.Name {
+			fields["name"] = FieldChange{Old: found.Name, New: entrie.Publisher.Name}
+		}
+		if found.URL != entrie.Publisher.URL {
+			fields["url"] = FieldChange{Old: found.URL, New: entrie.Publisher.URL}
+		}
+		if len(fields) > 0 {
+			plan.add(PlanChange{
+				Action:           PlanUpdate,
+				Kind:             PlanKindPublisher,
+				UUID:             &publisherUUID,
+				Publisher:        entrie.Publisher.Name,
+				Fields:           fields,
+				DesiredPublisher: &Publisher{Name: entrie.Publisher.Name, URL: entrie.Publisher.URL},
+			})
+		}
+		if err := ip.planPublications(ctx, plan, entrie, publisherUUID, prune); err != nil {
+			return nil, fmt.Errorf("publisher %q of entry %d: %w", entrie.Publisher.Name, i, err)
+		}
+	}
+	if prune {
+		unmatched := []entity.Publisher{}
+		for _, publisher := range publishers {
+			if _, ok := matched[publisher.UUID]; !ok {
+				unmatched = append(unmatched, publisher)
+			}
+		}
+		sort.Slice(unmatched, func(i, j int) bool { return unmatched[i].Name < unmatched[j].Name })
+		for _, publisher := range unmatched {
+			publisherUUID := publisher.UUID
+			plan.add(PlanChange{
+				Action:    PlanDelete,
+				Kind:      PlanKindPublisher,
+				UUID:      &publisherUUID,
+				Publisher: publisher.Name,
+			})
+		}
+	}
+	return plan, nil
+}
+
+// planPublications adds changes of publications of existing publisher
+func (ip *Importer) planPublications(ctx context.Context, plan *Plan, entrie Entrie, publisherUUID uuid.UUID, prune bool) error {
+	publications, err := ip.APIClient.GetPublisherPublications(ctx, publisherUUID)
+	if err != nil {
+		return fmt.Errorf("failure getting publications: %w", err)
+	}
+	existingPublications := make(map[string]entity.Publication, len(publications))
+	for _, publication := range publications {
+		existingPublications[publication.Name] = publication
+	}
+	// Publications names of publisher are unique in file, it is checked before planning
+	inFile := map[string]bool{}
+	for _, publication := range entrie.Publications {
+		inFile[publication.Name] = true
+		found, ok := existingPublications[publication.Name]
+		if !ok {
+			plan.add(publicationCreateChange(entrie.Publisher.Name, &publisherUUID, publication))
+			continue
+		}
+		if found.Type != publication.Type {
+			return fmt.Errorf("type of publication %q cannot be changed from %s to %s", publication.Name, found.Type, publication.Type)
+		}
+		fields := map[string]FieldChange{}
+		if found.Description != publication.Description {
+			fields["description"] = FieldChange{Old: found.Description, New: publication.Description}
+		}
+		if found.LanguageCode != publication.LanguageCode {
+			fields["language_code"] = FieldChange{Old: found.LanguageCode, New: publication.LanguageCode}
+		}
+		configMatches, err := publicationConfigMatches(publication.Config, found.Config)
+		if err != nil {
+			return fmt.Errorf("publication %q: %w", publication.Name, err)
+		}
+		if !configMatches {
+			fields["config"] = FieldChange{Old: found.Config, New: publication.Config}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		publicationUUID := found.UUID
+		desired := publication
+		plan.add(PlanChange{
+			Action:             PlanUpdate,
+			Kind:               PlanKindPublication,
+			UUID:               &publicationUUID,
+			Publisher:          entrie.Publisher.Name,
+			PublisherUUID:      &publisherUUID,
+			Publication:        publication.Name,
+			Fields:             fields,
+			DesiredPublication: &desired,
+		})
+	}
+	if !prune {
+		return nil
+	}
+	sort.Slice(publications, func(i, j int) bool { return publications[i].Name < publications[j].Name })
+	for _, publication := range publications {
+		if inFile[publication.Name] {
+			continue
+		}
+		publicationUUID := publication.UUID
+		plan.add(PlanChange{
+			Action:        PlanDelete,
+			Kind:          PlanKindPublication,
+			UUID:          &publicationUUID,
+			Publisher:     entrie.Publisher.Name,
+			PublisherUUID: &publisherUUID,
+			Publication:   publication.Name,
+		})
+	}
+	return nil
+}
+
+// publicationCreateChange returns creation of publication, publisherUUID is nil when publisher is created by plan
+func publicationCreateChange(publisherName string, publisherUUID *uuid.UUID, publication Publication) PlanChange {
+	return PlanChange{
+		Action:        PlanCreate,
+		Kind:          PlanKindPublication,
+		Publisher:     publisherName,
+		PublisherUUID: publisherUUID,
+		Publication:   publication.Name,
+		Fields: map[string]FieldChange{
+			"name":             {New: publication.Name},
+			"description":      {New: publication.Description},
+			"language_code":    {New: publication.LanguageCode},
+			"publication_type": {New: publication.Type},
+			"config":           {New: publication.Config},
+		},
+		DesiredPublication: &publication,
+	}
+}
+
+// planSymbols are prefixes of changes in text plan
+var planSymbols = map[PlanAction]string{
+	PlanCreate: "+",
+	PlanUpdate: "~",
+	PlanDelete: "-",
+}
+
+func (c PlanChange) String() string {
+	var target string
+	if c.Kind == PlanKindPublisher {
+		target = fmt.Sprintf("publisher %q", c.Publisher)
+	} else {
+		target = fmt.Sprintf("publication %q of publisher %q", c.Publication, c.Publisher)
+	}
+	msg := fmt.Sprintf("%s %s %s", planSymbols[c.Action], c.Action, target)
+	if c.UUID != nil {
+		msg += fmt.Sprintf(" (%s)", c.UUID)
+	}
+	if c.Action == PlanDelete && c.Kind == PlanKindPublisher {
+		msg += ", together with its publications"
+	}
+	return msg
+}
+
+// WriteText writes plan in readable form, with changed fields of each change
+func (p *Plan) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if p.Empty() {
+		fmt.Fprintln(bw, "No changes. Publications API matches the file.")
+		return bw.Flush()
+	}
+	for _, change := range p.Changes {
+		fmt.Fprintln(bw, " ", change)
+		names := make([]string, 0, len(change.Fields))
+		for name := range change.Fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			field := change.Fields[name]
+			if change.Action == PlanCreate {
+				fmt.Fprintf(bw, "      %s: %s\n", name, planValue(field.New))
+			} else {
+				fmt.Fprintf(bw, "      %s: %s -> %s\n", name, planValue(field.Old), planValue(field.New))
+			}
+		}
+	}
+	fmt.Fprintf(bw, "\nPlan: %d to create, %d to update, %d to delete.\n", p.Summary.Create, p.Summary.Update, p.Summary.Delete)
+	if !p.Prune {
+		fmt.Fprintln(bw, "Publishers and publications missing in the file are kept, use --prune to delete them.")
+	}
+	return bw.Flush()
+}
+
+// WriteJSON writes plan as JSON document
+func (p *Plan) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(p)
+}
+
+// planValue formats field value as compact JSON
+func planValue(v interface{}) string {
+	if raw, ok := v.(json.RawMessage); ok {
+		return strings.TrimSpace(string(raw))
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// Apply makes changes of plan one by one, reporting each of them. Plan may be read from JSON with ReadPlan.
+// Publications of publisher, which failed to be created, are skipped. Cancelling ctx stops apply.
+func (ip *Importer) Apply(ctx context.Context, plan *Plan) error {
+	// createdPublishers are UUIDs of publishers created by plan, by name
+	createdPublishers := map[string]uuid.UUID{}
+	failedPublishers := map[string]bool{}
+	failed := 0
+	for _, change := range plan.Changes {
+		if ctx.Err() != nil {
+			return fmt.Errorf("apply cancelled, %d changes failed", failed)
+		}
+		if change.Kind == PlanKindPublication && failedPublishers[change.Publisher] {
+			fmt.Println(change, "skipped: publisher failed")
+			continue
+		}
+		err := ip.applyChange(ctx, change, createdPublishers)
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("apply cancelled, %d changes failed", failed)
+			}
+			failed++
+			if change.Kind == PlanKindPublisher {
+				failedPublishers[change.Publisher] = true
+			}
+			fmt.Println(change, "failed:", err)
+			continue
+		}
+		fmt.Println(change, "done")
+	}
+	if failed > 0 {
+		return fmt.Errorf("apply failed for %d of %d changes", failed, len(plan.Changes))
+	}
+	fmt.Printf("Apply finished successfully: %d created, %d updated, %d deleted\n", plan.Summary.Create, plan.Summary.Update, plan.Summary.Delete)
+	return nil
+}
+
+// applyChange makes change, publications of publishers created by plan are created under them by publisher name
+func (ip *Importer) applyChange(ctx context.Context, change PlanChange, createdPublishers map[string]uuid.UUID) error {
+	switch change.Kind {
+	case PlanKindPublisher:
+		switch change.Action {
+		case PlanCreate:
+			publisher, err := ip.APIClient.CreatePublisher(ctx, change.DesiredPublisher.Name, change.DesiredPublisher.URL)
+			if err != nil {
+				return err
+			}
+			createdPublishers[change.Publisher] = publisher.UUID
+			return nil
+		case PlanUpdate:
+			_, err := ip.APIClient.UpdatePublisher(ctx, *change.UUID, change.DesiredPublisher.Name, change.DesiredPublisher.URL)
+			return err
+		case PlanDelete:
+			return ip.APIClient.DeletePublisher(ctx, *change.UUID)
+		}
+	case PlanKindPublication:
+		publication := change.DesiredPublication
+		switch change.Action {
+		case PlanCreate:
+			var publisherUUID uuid.UUID
+			if change.PublisherUUID != nil {
+				publisherUUID = *change.PublisherUUID
+			} else if created, ok := createdPublishers[change.Publisher]; ok {
+				publisherUUID = created
+			} else {
+				return fmt.Errorf("publisher %q isn't created", change.Publisher)
+			}
+			_, err := ip.APIClient.CreatePublication(
+				ctx,
+				publication.Name,
+				publication.Description,
+				publication.LanguageCode,
+				publisherUUID,
+				publication.Type,
+				publication.Config)
+			return err
+		case PlanUpdate:
+			if change.PublisherUUID == nil {
+				return errors.New("update of publication without publisher uuid")
+			}
+			_, err := ip.APIClient.UpdatePublication(
+				ctx,
+				*change.UUID,
+				publication.Name,
+				publication.Description,
+				publication.LanguageCode,
+				*change.PublisherUUID,
+				publication.Type,
+				publication.Config)
+			return err
+		case PlanDelete:
+			return ip.APIClient.DeletePublication(ctx, *change.UUID)
+		}
+	}
+	return fmt.Errorf("unknown change %s of %s", change.Action, change.Kind)
+}
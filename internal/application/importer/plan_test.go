@@ -0,0 +1,316 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/gofrs/uuid"
+)
+
+// planTestState stores publishers and publications, which differ from planTestEntries:
+// Example has changed and extra publications, Renamed has other name, Alpha Gone and Zeta Gone are not in the file
+func planTestState(c *fakeAPIClient) {
+	example := c.addPublisher("Example", "https://example.com")
+	c.addPublication(example.UUID, Publication{Name: "News", Description: "All news", LanguageCode: "en", Type: "rss", Config: map[string]interface{}{"url": "https://example.com/news.xml"}})
+	c.addPublication(example.UUID, Publication{Name: "Sport", Description: "Old sport", LanguageCode: "en", Type: "rss", Config: map[string]interface{}{"url": "https://example.com/sport.xml"}})
+	c.addPublication(example.UUID, Publication{Name: "Old", Description: "Old news", LanguageCode: "en", Type: "rss", Config: map[string]interface{}{"url": "https://example.com/old.xml"}})
+	c.addPublisher("Zeta Gone", "https://zeta.com")
+	c.addPublisher("Former", "https://renamed.com")
+	c.addPublisher("Alpha Gone", "https://alpha.com")
+}
+
+var planTestEntries = []Entrie{
+	{
+		Publisher: Publisher{Name: "Example", URL: "https://example.com"},
+		Publications: []Publication{
+			{Name: "News", Description: "All news", LanguageCode: "en", Type: "rss", Config: map[string]interface{}{"url": "https://example.com/news.xml"}},
+			{Name: "Sport", Description: "Sport news", LanguageCode: "en", Type: "rss", Config: map[string]interface{}{"url": "https://example.com/sport.xml"}},
+		},
+	},
+	{
+		Publisher: Publisher{Name: "New", URL: "https://new.com"},
+		Publications: []Publication{
+			{Name: "Feed", Description: "New feed", LanguageCode: "de", Type: "rss", Config: map[string]interface{}{"url": "https://new.com/feed.xml"}},
+		},
+	},
+	{Publisher: Publisher{Name: "Renamed", URL: "https://renamed.com"}},
+}
+
+// publisher returns stored publisher with name
+func (c *fakeAPIClient) publisher(name string) (*entity.Publisher, bool) {
+	for i, p := range c.publishers {
+		if p.Name == name {
+			return &c.publishers[i], true
+		}
+	}
+	return nil, false
+}
+
+// describeChanges returns changes without UUIDs, which differ between runs
+func describeChanges(plan *Plan) []string {
+	var changes []string
+	for _, c := range plan.Changes {
+		changes = append(changes, fmt.Sprintf("%s %s %s/%s", c.Action, c.Kind, c.Publisher, c.Publication))
+	}
+	return changes
+}
+
+func TestPlan(t *testing.T) {
+	tests := []struct {
+		name        string
+		prune       bool
+		wantChanges []string
+		wantSummary PlanSummary
+	}{
+		{
+			name: "without prune",
+			wantChanges: []string{
+				"update publication Example/Sport",
+				"create publisher New/",
+				"create publication New/Feed",
+				"update publisher Renamed/",
+			},
+			wantSummary: PlanSummary{Create: 2, Update: 2},
+		},
+		{
+			name:  "with prune",
+			prune: true,
+			wantChanges: []string{
+				"update publication Example/Sport",
+				"delete publication Example/Old",
+				"create publisher New/",
+				"create publication New/Feed",
+				"update publisher Renamed/",
+				"delete publisher Alpha Gone/",
+				"delete publisher Zeta Gone/",
+			},
+			wantSummary: PlanSummary{Create: 2, Update: 2, Delete: 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeAPIClient{}
+			planTestState(client)
+			ip := &Importer{APIClient: client}
+			plan, err := ip.Plan(context.Background(), planTestEntries, tt.prune)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := describeChanges(plan); strings.Join(got, "\n") != strings.Join(tt.wantChanges, "\n") {
+				t.Errorf("changes:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.wantChanges, "\n"))
+			}
+			if plan.Summary != tt.wantSummary {
+				t.Errorf("summary = %+v, want %+v", plan.Summary, tt.wantSummary)
+			}
+			// Changed entities are identified by UUIDs of stored ones
+			for _, c := range plan.Changes {
+				if c.UUID == nil {
+					continue
+				}
+				name := c.Publication
+				if c.Kind == PlanKindPublisher {
+					p, err := client.GetPublisher(context.Background(), *c.UUID)
+					if err != nil {
+						t.Errorf("%s: %s", c, err)
+					}
+					name = p.Name
+				} else {
+					for _, p := range client.publications {
+						if p.UUID == *c.UUID {
+							name = p.Name
+						}
+					}
+				}
+				if c.Action == PlanUpdate && c.Kind == PlanKindPublisher {
+					if name != "Former" {
+						t.Errorf("%s changes publisher %q", c, name)
+					}
+				} else if name != c.Publisher && name != c.Publication {
+					t.Errorf("%s changes %q", c, name)
+				}
+			}
+			update := plan.Changes[0]
+			if want := (FieldChange{Old: "Old sport", New: "Sport news"}); len(update.Fields) != 1 || update.Fields["description"] != want {
+				t.Errorf("fields of %s = %+v, want description %+v", update, update.Fields, want)
+			}
+		})
+	}
+}
+
+func TestPlanRejectsInvalidEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []Entrie
+		errs    map[string]error
+	}{
+		{name: "duplicate publishers", entries: []Entrie{
+			{Publisher: Publisher{Name: "Example", URL: "https://example.com"}},
+			{Publisher: Publisher{Name: "Example", URL: "https://example.org"}},
+		}},
+		{name: "duplicate publications", entries: []Entrie{
+			{Publisher: Publisher{Name: "Example", URL: "https://example.com"}, Publications: []Publication{{Name: "News"}, {Name: "News"}}},
+		}},
+		{name: "entries of the same publisher", entries: []Entrie{
+			{Publisher: Publisher{Name: "Example", URL: "https://example.org"}},
+			{Publisher: Publisher{Name: "Example Inc", URL: "https://example.com"}},
+		}},
+		{name: "name and url of different publishers", entries: []Entrie{
+			{Publisher: Publisher{Name: "Example", URL: "https://zeta.com"}},
+		}},
+		{name: "changed publication type", entries: []Entrie{
+			{Publisher: Publisher{Name: "Example", URL: "https://example.com"}, Publications: []Publication{{Name: "News", Type: "scrapped"}}},
+		}},
+		{name: "failure getting publishers", entries: planTestEntries, errs: map[string]error{"GetPublishers": errors.New("failed")}},
+		{name: "failure getting publications", entries: planTestEntries, errs: map[string]error{"GetPublisherPublications": errors.New("failed")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeAPIClient{errs: tt.errs}
+			planTestState(client)
+			ip := &Importer{APIClient: client}
+			if plan, err := ip.Plan(context.Background(), tt.entries, true); err == nil {
+				t.Errorf("plan is made: %v", describeChanges(plan))
+			}
+		})
+	}
+}
+
+func TestApplySavedPlan(t *testing.T) {
+	client := &fakeAPIClient{}
+	planTestState(client)
+	ip := &Importer{APIClient: client}
+	ctx := context.Background()
+	plan, err := ip.Plan(ctx, planTestEntries, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := &bytes.Buffer{}
+	if err := plan.WriteJSON(saved); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadPlan(bytes.NewReader(saved.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := ip.Plan(ctx, planTestEntries, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matches, err := read.Matches(fresh); err != nil || !matches {
+		t.Fatalf("saved plan doesn't match fresh one: %v", err)
+	}
+	if matches, err := read.Matches(&Plan{Prune: true}); err != nil || matches {
+		t.Errorf("saved plan matches empty one: %v", err)
+	}
+
+	if err := ip.Apply(ctx, read); err != nil {
+		t.Fatal(err)
+	}
+	// Publication of created publisher is created under it
+	created, _ := client.publisher("New")
+	var feed *entity.Publication
+	for i, p := range client.publications {
+		if p.Name == "Feed" {
+			feed = &client.publications[i]
+		}
+	}
+	if feed == nil || created == nil || feed.PublisherUUID != created.UUID {
+		t.Errorf("publication %+v, want created under publisher %+v", feed, created)
+	}
+	if p, _ := client.publisher("Renamed"); p == nil || p.URL != "https://renamed.com" {
+		t.Errorf("renamed publisher %+v", p)
+	}
+	if len(client.publishers) != 3 || len(client.publications) != 3 {
+		t.Errorf("%d publishers and %d publications after apply, want 3 of each", len(client.publishers), len(client.publications))
+	}
+	after, err := ip.Plan(ctx, planTestEntries, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.Empty() {
+		t.Errorf("changes after apply: %v", describeChanges(after))
+	}
+	if matches, err := read.Matches(after); err != nil || matches {
+		t.Errorf("applied plan matches fresh one: %v", err)
+	}
+}
+
+func TestApplySkipsPublicationsOfFailedPublisher(t *testing.T) {
+	client := &fakeAPIClient{}
+	planTestState(client)
+	ip := &Importer{APIClient: client}
+	ctx := context.Background()
+	plan, err := ip.Plan(ctx, planTestEntries, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.errs = map[string]error{"CreatePublisher": errors.New("failed")}
+	client.calls = nil
+	if err := ip.Apply(ctx, plan); err == nil {
+		t.Error("apply with failed change succeeds")
+	}
+	if want := "UpdatePublication,CreatePublisher,UpdatePublisher"; strings.Join(client.calls, ",") != want {
+		t.Errorf("calls = %v, want %s", client.calls, want)
+	}
+}
+
+func TestReadPlanRejectsInvalidChanges(t *testing.T) {
+	changeUUID := uuid.Must(uuid.NewV4()).String()
+	publisher := `"desired_publisher":{"name":"Example","url":"https://example.com"}`
+	publication := `"desired_publication":{"name":"News","type":"rss"}`
+	tests := []struct {
+		name    string
+		changes string
+		wantErr bool
+	}{
+		{name: "publication of created publisher", changes: `{"action":"create","kind":"publisher","publisher":"Example",` + publisher + `},
+			{"action":"create","kind":"publication","publisher":"Example","publication":"News",` + publication + `}`},
+		{name: "publication of existing publisher", changes: `{"action":"create","kind":"publication","publisher":"Example","publisher_uuid":"` + changeUUID + `",` + publication + `}`},
+		{name: "deletes", changes: `{"action":"delete","kind":"publication","uuid":"` + changeUUID + `"},{"action":"delete","kind":"publisher","uuid":"` + changeUUID + `"}`},
+
+		{name: "unknown kind", changes: `{"action":"delete","kind":"feed","uuid":"` + changeUUID + `"}`, wantErr: true},
+		{name: "no kind", changes: `{"action":"create",` + publisher + `}`, wantErr: true},
+		{name: "unknown action", changes: `{"action":"rename","kind":"publisher","uuid":"` + changeUUID + `",` + publisher + `}`, wantErr: true},
+		{name: "update without uuid", changes: `{"action":"update","kind":"publisher",` + publisher + `}`, wantErr: true},
+		{name: "delete without uuid", changes: `{"action":"delete","kind":"publication"}`, wantErr: true},
+		{name: "create without desired state", changes: `{"action":"create","kind":"publisher","publisher":"Example"}`, wantErr: true},
+		{name: "update without desired state", changes: `{"action":"update","kind":"publication","uuid":"` + changeUUID + `","publisher_uuid":"` + changeUUID + `"}`, wantErr: true},
+		{name: "update of publication without publisher uuid", changes: `{"action":"update","kind":"publication","uuid":"` + changeUUID + `",` + publication + `}`, wantErr: true},
+		{name: "publication without publisher", changes: `{"action":"create","kind":"publication","publisher":"Example","publication":"News",` + publication + `}`, wantErr: true},
+		{name: "publication before its publisher", changes: `{"action":"create","kind":"publication","publisher":"Example","publication":"News",` + publication + `},
+			{"action":"create","kind":"publisher","publisher":"Example",` + publisher + `}`, wantErr: true},
+		{name: "invalid uuid", changes: `{"action":"delete","kind":"publisher","uuid":"example"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadPlan(strings.NewReader(`{"prune":true,"changes":[` + tt.changes + `]}`))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyChangeRejectsUnknownChanges(t *testing.T) {
+	changeUUID := uuid.Must(uuid.NewV4())
+	client := &fakeAPIClient{}
+	ip := &Importer{APIClient: client}
+	for _, change := range []PlanChange{
+		{Action: PlanDelete, Kind: "feed", UUID: &changeUUID},
+		{Action: "rename", Kind: PlanKindPublisher, UUID: &changeUUID, DesiredPublisher: &Publisher{}},
+		{Action: "rename", Kind: PlanKindPublication, UUID: &changeUUID, DesiredPublication: &Publication{}},
+		{Action: PlanCreate, Kind: PlanKindPublication, Publisher: "Example", DesiredPublication: &Publication{}},
+	} {
+		if err := ip.applyChange(context.Background(), change, map[string]uuid.UUID{}); err == nil {
+			t.Errorf("change %+v is applied", change)
+		}
+	}
+	if len(client.calls) != 0 {
+		t.Errorf("calls = %v, want none", client.calls)
+	}
+}
@@ -0,0 +1,268 @@
+package importer
+
+// This file contains upsert mode of import: existing publishers and publications are found and updated instead of skipped
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/gofrs/uuid"
+)
+
+// upsertReport is result of upserting publisher or publication
+type upsertReport struct {
+	Publisher   string
+	Publication string
+	Result      importResult
+	Error       error
+}
+
+func (r upsertReport) String() string {
+	var msg string
+	if r.Publication == "" {
+		msg = fmt.Sprintf("publisher %q: %s", r.Publisher, r.Result)
+	} else {
+		msg = fmt.Sprintf("publication %q of publisher %q: %s", r.Publication, r.Publisher, r.Result)
+	}
+	if r.Error != nil {
+		msg += ": " + r.Error.Error()
+	}
+	return msg
+}
+
+// existingPublishers indexes publishers by name and url, it is shared by import workers
+type existingPublishers struct {
+	mu     sync.Mutex
+	byName map[string]entity.Publisher
+	byURL  map[string]entity.Publisher
+}
+
+func newExistingPublishers(publishers []entity.Publisher) *existingPublishers {
+	existing := &existingPublishers{
+		byName: make(map[string]entity.Publisher, len(publishers)),
+		byURL:  make(map[string]entity.Publisher, len(publishers)),
+	}
+	for _, publisher := range publishers {
+		existing.set(publisher)
+	}
+	return existing
+}
+
+// find returns publisher with name or url. Name and url of different publishers is an error.
+func (e *existingPublishers) find(name string, url string) (*entity.Publisher, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	byName, nameFound := e.byName[name]
+	byURL, urlFound := e.byURL[url]
+	switch {
+	case nameFound && urlFound && byName.UUID != byURL.UUID:
+		return nil, fmt.Errorf("name matches publisher %s, url matches other publisher %s", byName.UUID, byURL.UUID)
+	case nameFound:
+		return &byName, nil
+	case urlFound:
+		return &byURL, nil
+	}
+	return nil, nil
+}
+
+// set adds created or replaces updated publisher
+func (e *existingPublishers) set(publisher entity.Publisher) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for name, p := range e.byName {
+		if p.UUID == publisher.UUID {
+			delete(e.byName, name)
+		}
+	}
+	for url, p := range e.byURL {
+		if p.UUID == publisher.UUID {
+			delete(e.byURL, url)
+		}
+	}
+	e.byName[publisher.Name] = publisher
+	e.byURL[publisher.URL] = publisher
+}
+
+// upsertEntrie creates or updates publisher and then its publications, reporting result of each.
+// Requests failed due to cancellation are not counted nor reported.
+func (ip *Importer) upsertEntrie(ctx context.Context, entrie Entrie, existing *existingPublishers, stats *importStats) ([]upsertReport, []ImportError) {
+	if ctx.Err() != nil {
+		return nil, nil
+	}
+	publisherUUID, result, err := ip.upsertPublisher(ctx, entrie.Publisher, existing)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil
+		}
+		stats.add(resultFailed, 1)
+		stats.add(resultSkipped, int64(len(entrie.Publications)))
+		reports := []upsertReport{{Publisher: entrie.Publisher.Name, Result: resultFailed, Error: err}}
+		for _, publication := range entrie.Publications {
+			reports = append(reports, upsertReport{Publisher: entrie.Publisher.Name, Publication: publication.Name, Result: resultSkipped})
+		}
+		return reports, []ImportError{{Publisher: entrie.Publisher, Error: err}}
+	}
+	stats.add(result, 1)
+	reports := []upsertReport{{Publisher: entrie.Publisher.Name, Result: result}}
+
+	var publications []entity.Publication
+	if result != resultCreated {
+		if publications, err = ip.APIClient.GetPublisherPublications(ctx, publisherUUID); err != nil {
+			if ctx.Err() != nil {
+				return reports, nil
+			}
+			err = fmt.Errorf("failure getting publications of publisher: %w", err)
+			stats.add(resultFailed, int64(len(entrie.Publications)))
+			var importErrors []ImportError
+			for _, publication := range entrie.Publications {
+				reports = append(reports, upsertReport{Publisher: entrie.Publisher.Name, Publication: publication.Name, Result: resultFailed, Error: err})
+				importErrors = append(importErrors, ImportError{Publisher: entrie.Publisher, Publication: publication, Error: err})
+			}
+			return reports, importErrors
+		}
+	}
+	existingPublications := make(map[string]entity.Publication, len(publications))
+	for _, publication := range publications {
+		existingPublications[publication.Name] = publication
+	}
+	var importErrors []ImportError
+	for _, publication := range entrie.Publications {
+		if ctx.Err() != nil {
+			return reports, importErrors
+		}
+		var found *entity.Publication
+		if p, ok := existingPublications[publication.Name]; ok {
+			found = &p
+		}
+		result, err := ip.upsertPublication(ctx, publisherUUID, publication, found)
+		if err != nil {
+			if ctx.Err() != nil {
+				return reports, importErrors
+			}
+			result = resultFailed
+			importErrors = append(importErrors, ImportError{
+				Publisher:   entrie.Publisher,
+				Publication: publication,
+				Error:       err,
+			})
+		}
+		stats.add(result, 1)
+		reports = append(reports, upsertReport{Publisher: entrie.Publisher.Name, Publication: publication.Name, Result: result, Error: err})
+	}
+	return reports, importErrors
+}
+
+// upsertPublisher creates publisher or updates existing one with the same name or url, returning its UUID
+func (ip *Importer) upsertPublisher(ctx context.Context, publisher Publisher, existing *existingPublishers) (uuid.UUID, importResult, error) {
+	found, err := existing.find(publisher.Name, publisher.URL)
+	if err != nil {
+		return uuid.Nil, resultFailed, err
+	}
+	if found == nil {
+		created, err := ip.APIClient.CreatePublisher(ctx, publisher.Name, publisher.URL)
+		var conflict *entity.ConflictError
+		if errors.As(err, &conflict) && conflict.UUID != uuid.Nil {
+			// Publisher is created concurrently by other entry or client with the same name or url, it is updated as found one
+			current, err := ip.APIClient.GetPublisher(ctx, conflict.UUID)
+			if err != nil {
+				return conflict.UUID, resultFailed, fmt.Errorf("failure getting conflicting publisher %s: %w", conflict.UUID, err)
+			}
+			existing.set(current)
+			return ip.updatePublisher(ctx, publisher, current, existing)
+		}
+		if err != nil {
+			return uuid.Nil, resultFailed, err
+		}
+		existing.set(created)
+		return created.UUID, resultCreated, nil
+	}
+	return ip.updatePublisher(ctx, publisher, *found, existing)
+}
+
+// updatePublisher updates found publisher, if it differs from imported one
+func (ip *Importer) updatePublisher(ctx context.Context, publisher Publisher, found entity.Publisher, existing *existingPublishers) (uuid.UUID, importResult, error) {
+	if found.Name == publisher.Name && found.URL == publisher.URL {
+		return found.UUID, resultUnchanged, nil
+	}
+	updated, err := ip.APIClient.UpdatePublisher(ctx, found.UUID, publisher.Name, publisher.URL)
+	if err != nil {
+		return found.UUID, resultFailed, err
+	}
+	existing.set(updated)
+	return updated.UUID, resultUpdated, nil
+}
+
+// upsertPublication creates publication if it isn't found or updates found one, if it differs
+func (ip *Importer) upsertPublication(ctx context.Context, publisherUUID uuid.UUID, publication Publication, found *entity.Publication) (importResult, error) {
+	if found == nil {
+		_, err := ip.APIClient.CreatePublication(
+			ctx,
+			publication.Name,
+			publication.Description,
+			publication.LanguageCode,
+			publisherUUID,
+			publication.Type,
+			publication.Config)
+		if err != nil {
+			return resultFailed, err
+		}
+		return resultCreated, nil
+	}
+	if found.Type != publication.Type {
+		return resultFailed, fmt.Errorf("publication type %s cannot be changed to %s", found.Type, publication.Type)
+	}
+	configMatches, err := publicationConfigMatches(publication.Config, found.Config)
+	if err != nil {
+		return resultFailed, err
+	}
+	if found.Description == publication.Description && found.LanguageCode == publication.LanguageCode && configMatches {
+		return resultUnchanged, nil
+	}
+	if _, err := ip.APIClient.UpdatePublication(
+		ctx,
+		found.UUID,
+		publication.Name,
+		publication.Description,
+		publication.LanguageCode,
+		publisherUUID,
+		publication.Type,
+		publication.Config); err != nil {
+		return resultFailed, err
+	}
+	return resultUpdated, nil
+}
+
+// publicationConfigMatches compares imported config to stored one.
+// Stored config may have fields with defaults, which are missing in imported config, so only imported fields are compared.
+func publicationConfigMatches(config PublicationConfig, stored json.RawMessage) (bool, error) {
+	// Both configs are compared as generic JSON values
+	raw, err := json.Marshal(config)
+	if err != nil {
+		return false, fmt.Errorf("failure encoding publication config: %w", err)
+	}
+	var imported, current interface{}
+	if err := json.Unmarshal(raw, &imported); err != nil {
+		return false, fmt.Errorf("failure decoding publication config: %w", err)
+	}
+	if len(stored) > 0 {
+		if err := json.Unmarshal(stored, &current); err != nil {
+			return false, fmt.Errorf("failure decoding stored publication config: %w", err)
+		}
+	}
+	importedFields, ok := imported.(map[string]interface{})
+	currentFields, currentOk := current.(map[string]interface{})
+	if !ok || !currentOk {
+		return reflect.DeepEqual(imported, current), nil
+	}
+	for field, value := range importedFields {
+		if currentValue, ok := currentFields[field]; !ok || !reflect.DeepEqual(value, currentValue) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
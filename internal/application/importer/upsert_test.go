@@ -0,0 +1,553 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/gofrs/uuid"
+)
+
+// fakeAPIClient keeps publishers and publications in memory like Publications API, recording names of called methods.
+// Methods with errors set return them instead.
+type fakeAPIClient struct {
+	PublicationsAPIClient
+	mu           sync.Mutex
+	publishers   []entity.Publisher
+	publications []entity.Publication
+	errs         map[string]error
+	calls        []string
+}
+
+// call records method and returns its error, must be called under lock
+func (c *fakeAPIClient) call(method string) error {
+	c.calls = append(c.calls, method)
+	return c.errs[method]
+}
+
+func (c *fakeAPIClient) addPublisher(name string, url string) entity.Publisher {
+	publisher := entity.Publisher{UUID: uuid.Must(uuid.NewV4()), Name: name, URL: url}
+	c.publishers = append(c.publishers, publisher)
+	return publisher
+}
+
+func (c *fakeAPIClient) addPublication(publisherUUID uuid.UUID, publication Publication) entity.Publication {
+	p := newTestPublication(uuid.Must(uuid.NewV4()), publisherUUID, publication)
+	c.publications = append(c.publications, p)
+	return p
+}
+
+func newTestPublication(publicationUUID uuid.UUID, publisherUUID uuid.UUID, publication Publication) entity.Publication {
+	config, _ := json.Marshal(publication.Config)
+	return entity.Publication{
+		UUID:          publicationUUID,
+		PublisherUUID: publisherUUID,
+		Name:          publication.Name,
+		Description:   publication.Description,
+		LanguageCode:  publication.LanguageCode,
+		Type:          publication.Type,
+		Config:        config,
+	}
+}
+
+func (c *fakeAPIClient) CreatePublisher(_ context.Context, name string, url string) (entity.Publisher, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("CreatePublisher"); err != nil {
+		return entity.Publisher{}, err
+	}
+	for _, p := range c.publishers {
+		if p.Name == name || p.URL == url {
+			return entity.Publisher{}, &entity.ConflictError{UUID: p.UUID}
+		}
+	}
+	return c.addPublisher(name, url), nil
+}
+
+func (c *fakeAPIClient) GetPublishers(context.Context) ([]entity.Publisher, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("GetPublishers"); err != nil {
+		return nil, err
+	}
+	return append([]entity.Publisher(nil), c.publishers...), nil
+}
+
+func (c *fakeAPIClient) GetPublisher(_ context.Context, publisherUUID uuid.UUID) (entity.Publisher, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("GetPublisher"); err != nil {
+		return entity.Publisher{}, err
+	}
+	for _, p := range c.publishers {
+		if p.UUID == publisherUUID {
+			return p, nil
+		}
+	}
+	return entity.Publisher{}, entity.ErrNotFound
+}
+
+func (c *fakeAPIClient) UpdatePublisher(_ context.Context, publisherUUID uuid.UUID, name string, url string) (entity.Publisher, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("UpdatePublisher"); err != nil {
+		return entity.Publisher{}, err
+	}
+	for i, p := range c.publishers {
+		if p.UUID == publisherUUID {
+			c.publishers[i].Name, c.publishers[i].URL = name, url
+			return c.publishers[i], nil
+		}
+	}
+	return entity.Publisher{}, entity.ErrNotFound
+}
+
+func (c *fakeAPIClient) GetPublisherPublications(_ context.Context, publisherUUID uuid.UUID) ([]entity.Publication, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("GetPublisherPublications"); err != nil {
+		return nil, err
+	}
+	var publications []entity.Publication
+	for _, p := range c.publications {
+		if p.PublisherUUID == publisherUUID {
+			publications = append(publications, p)
+		}
+	}
+	return publications, nil
+}
+
+func (c *fakeAPIClient) CreatePublication(_ context.Context, name string, description string, languageCode string, publisherUUID uuid.UUID, publicationType string, config interface{}) (entity.Publication, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("CreatePublication"); err != nil {
+		return entity.Publication{}, err
+	}
+	return c.addPublication(publisherUUID, Publication{Name: name, Description: description, LanguageCode: languageCode, Type: publicationType, Config: config}), nil
+}
+
+func (c *fakeAPIClient) UpdatePublication(_ context.Context, publicationUUID uuid.UUID, name string, description string, languageCode string, publisherUUID uuid.UUID, publicationType string, config interface{}) (entity.Publication, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("UpdatePublication"); err != nil {
+		return entity.Publication{}, err
+	}
+	for i, p := range c.publications {
+		if p.UUID == publicationUUID {
+			c.publications[i] = newTestPublication(publicationUUID, publisherUUID,
+				Publication{Name: name, Description: description, LanguageCode: languageCode, Type: publicationType, Config: config})
+			return c.publications[i], nil
+		}
+	}
+	return entity.Publication{}, entity.ErrNotFound
+}
+
+func (c *fakeAPIClient) DeletePublisher(_ context.Context, publisherUUID uuid.UUID) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("DeletePublisher"); err != nil {
+		return err
+	}
+	for i, p := range c.publishers {
+		if p.UUID == publisherUUID {
+			c.publishers = append(c.publishers[:i], c.publishers[i+1:]...)
+			// Publications are deleted together with publisher
+			publications := c.publications[:0]
+			for _, publication := range c.publications {
+				if publication.PublisherUUID != publisherUUID {
+					publications = append(publications, publication)
+				}
+			}
+			c.publications = publications
+			return nil
+		}
+	}
+	return entity.ErrNotFound
+}
+
+func (c *fakeAPIClient) DeletePublication(_ context.Context, publicationUUID uuid.UUID) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("DeletePublication"); err != nil {
+		return err
+	}
+	for i, p := range c.publications {
+		if p.UUID == publicationUUID {
+			c.publications = append(c.publications[:i], c.publications[i+1:]...)
+			return nil
+		}
+	}
+	return entity.ErrNotFound
+}
+
+func TestUpsertEntrie(t *testing.T) {
+	feed := Publication{Name: "News", Description: "All news", LanguageCode: "en", Type: "rss", Config: map[string]interface{}{"url": "https://example.com/news.xml"}}
+	sport := Publication{Name: "Sport", Description: "Sport news", LanguageCode: "en", Type: "rss", Config: map[string]interface{}{"url": "https://example.com/sport.xml"}}
+	entrie := Entrie{Publisher: Publisher{Name: "Example", URL: "https://example.com"}, Publications: []Publication{feed, sport}}
+	changed := func(p Publication, change func(*Publication)) Publication {
+		change(&p)
+		return p
+	}
+	errFailed := errors.New("failed")
+
+	tests := []struct {
+		name string
+		// setup stores publishers and publications in API, indexed ones are known to importer before import
+		setup       func(c *fakeAPIClient) (indexed []entity.Publisher)
+		errs        map[string]error
+		wantReports []string
+		wantCalls   []string
+		wantErrors  int
+	}{
+		{
+			name:  "new publisher with publications",
+			setup: func(c *fakeAPIClient) []entity.Publisher { return nil },
+			wantReports: []string{
+				`publisher "Example": created`,
+				`publication "News" of publisher "Example": created`,
+				`publication "Sport" of publisher "Example": created`,
+			},
+			wantCalls: []string{"CreatePublisher", "CreatePublication", "CreatePublication"},
+		},
+		{
+			name: "existing publisher and publications",
+			setup: func(c *fakeAPIClient) []entity.Publisher {
+				publisher := c.addPublisher("Example", "https://example.com")
+				c.addPublication(publisher.UUID, feed)
+				c.addPublication(publisher.UUID, sport)
+				return c.publishers
+			},
+			wantReports: []string{
+				`publisher "Example": unchanged`,
+				`publication "News" of publisher "Example": unchanged`,
+				`publication "Sport" of publisher "Example": unchanged`,
+			},
+			wantCalls: []string{"GetPublisherPublications"},
+		},
+		{
+			name: "stored config with defaults",
+			setup: func(c *fakeAPIClient) []entity.Publisher {
+				publisher := c.addPublisher("Example", "https://example.com")
+				c.addPublication(publisher.UUID, changed(feed, func(p *Publication) {
+					p.Config = map[string]interface{}{"url": "https://example.com/news.xml", "interval": 60}
+				}))
+				c.addPublication(publisher.UUID, sport)
+				return c.publishers
+			},
+			wantReports: []string{
+				`publisher "Example": unchanged`,
+				`publication "News" of publisher "Example": unchanged`,
+				`publication "Sport" of publisher "Example": unchanged`,
+			},
+			wantCalls: []string{"GetPublisherPublications"},
+		},
+		{
+			name: "changed publisher and publication, missing publication",
+			setup: func(c *fakeAPIClient) []entity.Publisher {
+				publisher := c.addPublisher("Example", "http://example.com")
+				c.addPublication(publisher.UUID, changed(feed, func(p *Publication) { p.Description = "Old news" }))
+				return c.publishers
+			},
+			wantReports: []string{
+				`publisher "Example": updated`,
+				`publication "News" of publisher "Example": updated`,
+				`publication "Sport" of publisher "Example": created`,
+			},
+			wantCalls: []string{"UpdatePublisher", "GetPublisherPublications", "UpdatePublication", "CreatePublication"},
+		},
+		{
+			name: "changed publication config and language",
+			setup: func(c *fakeAPIClient) []entity.Publisher {
+				publisher := c.addPublisher("Example", "https://example.com")
+				c.addPublication(publisher.UUID, changed(feed, func(p *Publication) { p.Config = map[string]interface{}{"url": "https://example.com/old.xml"} }))
+				c.addPublication(publisher.UUID, changed(sport, func(p *Publication) { p.LanguageCode = "de" }))
+				return c.publishers
+			},
+			wantReports: []string{
+				`publisher "Example": unchanged`,
+				`publication "News" of publisher "Example": updated`,
+				`publication "Sport" of publisher "Example": updated`,
+			},
+			wantCalls: []string{"GetPublisherPublications", "UpdatePublication", "UpdatePublication"},
+		},
+		{
+			name: "publisher found by url",
+			setup: func(c *fakeAPIClient) []entity.Publisher {
+				c.addPublisher("Old Example", "https://example.com")
+				return c.publishers
+			},
+			wantReports: []string{
+				`publisher "Example": updated`,
+				`publication "News" of publisher "Example": created`,
+				`publication "Sport" of publisher "Example": created`,
+			},
+			wantCalls: []string{"UpdatePublisher", "GetPublisherPublications", "CreatePublication", "CreatePublication"},
+		},
+		{
+			name: "publisher created concurrently",
+			setup: func(c *fakeAPIClient) []entity.Publisher {
+				publisher := c.addPublisher("Example", "https://example.com")
+				c.addPublication(publisher.UUID, feed)
+				return nil
+			},
+			wantReports: []string{
+				`publisher "Example": unchanged`,
+				`publication "News" of publisher "Example": unchanged`,
+				`publication "Sport" of publisher "Example": created`,
+			},
+			wantCalls: []string{"CreatePublisher", "GetPublisher", "GetPublisherPublications", "CreatePublication"},
+		},
+		{
+			name: "different publisher created concurrently",
+			setup: func(c *fakeAPIClient) []entity.Publisher {
+				c.addPublisher("Example", "https://www.example.com")
+				return nil
+			},
+			wantReports: []string{
+				`publisher "Example": updated`,
+				`publication "News" of publisher "Example": created`,
+				`publication "Sport" of publisher "Example": created`,
+			},
+			wantCalls: []string{"CreatePublisher", "GetPublisher", "UpdatePublisher", "GetPublisherPublications", "CreatePublication", "CreatePublication"},
+		},
+		{
+			name: "failure getting publisher created concurrently",
+			setup: func(c *fakeAPIClient) []entity.Publisher {
+				c.addPublisher("Example", "https://example.com")
+				return nil
+			},
+			errs: map[string]error{"GetPublisher": errFailed},
+			wantReports: []string{
+				`publisher "Example": failed`,
+				`publication "News" of publisher "Example": skipped`,
+				`publication "Sport" of publisher "Example": skipped`,
+			},
+			wantCalls:  []string{"CreatePublisher", "GetPublisher"},
+			wantErrors: 1,
+		},
+		{
+			name: "name and url of different publishers",
+			setup: func(c *fakeAPIClient) []entity.Publisher {
+				c.addPublisher("Example", "https://example.org")
+				c.addPublisher("Other", "https://example.com")
+				return c.publishers
+			},
+			wantReports: []string{
+				`publisher "Example": failed`,
+				`publication "News" of publisher "Example": skipped`,
+				`publication "Sport" of publisher "Example": skipped`,
+			},
+			wantErrors: 1,
+		},
+		{
+			name:  "failure creating publisher",
+			setup: func(c *fakeAPIClient) []entity.Publisher { return nil },
+			errs:  map[string]error{"CreatePublisher": errFailed},
+			wantReports: []string{
+				`publisher "Example": failed`,
+				`publication "News" of publisher "Example": skipped`,
+				`publication "Sport" of publisher "Example": skipped`,
+			},
+			wantCalls:  []string{"CreatePublisher"},
+			wantErrors: 1,
+		},
+		{
+			name: "failure updating publisher",
+			setup: func(c *fakeAPIClient) []entity.Publisher {
+				c.addPublisher("Example", "http://example.com")
+				return c.publishers
+			},
+			errs: map[string]error{"UpdatePublisher": errFailed},
+			wantReports: []string{
+				`publisher "Example": failed`,
+				`publication "News" of publisher "Example": skipped`,
+				`publication "Sport" of publisher "Example": skipped`,
+			},
+			wantCalls:  []string{"UpdatePublisher"},
+			wantErrors: 1,
+		},
+		{
+			name: "failure getting publications",
+			setup: func(c *fakeAPIClient) []entity.Publisher {
+				c.addPublisher("Example", "https://example.com")
+				return c.publishers
+			},
+			errs: map[string]error{"GetPublisherPublications": errFailed},
+			wantReports: []string{
+				`publisher "Example": unchanged`,
+				`publication "News" of publisher "Example": failed`,
+				`publication "Sport" of publisher "Example": failed`,
+			},
+			wantCalls:  []string{"GetPublisherPublications"},
+			wantErrors: 2,
+		},
+		{
+			name: "failure creating publication and changed type",
+			setup: func(c *fakeAPIClient) []entity.Publisher {
+				publisher := c.addPublisher("Example", "https://example.com")
+				c.addPublication(publisher.UUID, changed(feed, func(p *Publication) { p.Type = "scrapped" }))
+				return c.publishers
+			},
+			errs: map[string]error{"CreatePublication": errFailed},
+			wantReports: []string{
+				`publisher "Example": unchanged`,
+				`publication "News" of publisher "Example": failed`,
+				`publication "Sport" of publisher "Example": failed`,
+			},
+			wantCalls:  []string{"GetPublisherPublications", "CreatePublication"},
+			wantErrors: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeAPIClient{errs: tt.errs}
+			existing := newExistingPublishers(tt.setup(client))
+			ip := &Importer{APIClient: client}
+			stats := &importStats{}
+			reports, importErrors := ip.upsertEntrie(context.Background(), entrie, existing, stats)
+			// Errors are checked by count, reports are compared without them
+			var got []string
+			for _, r := range reports {
+				r.Error = nil
+				got = append(got, r.String())
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.wantReports, "\n") {
+				t.Errorf("reports:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.wantReports, "\n"))
+			}
+			if strings.Join(client.calls, ",") != strings.Join(tt.wantCalls, ",") {
+				t.Errorf("calls = %v, want %v", client.calls, tt.wantCalls)
+			}
+			if len(importErrors) != tt.wantErrors {
+				t.Errorf("import errors = %v, want %d", importErrors, tt.wantErrors)
+			}
+			if stats.processed != int64(len(tt.wantReports)) {
+				t.Errorf("processed = %d, want %d", stats.processed, len(tt.wantReports))
+			}
+			if tt.wantErrors > 0 {
+				return
+			}
+			// Later entries of the same publisher find it
+			found, err := existing.find(entrie.Publisher.Name, entrie.Publisher.URL)
+			if err != nil || found == nil || found.Name != entrie.Publisher.Name || found.URL != entrie.Publisher.URL {
+				t.Errorf("existing publisher %+v, %v, want imported one", found, err)
+			}
+		})
+	}
+}
+
+func TestRunImportUpsertIsIdempotent(t *testing.T) {
+	var entries []Entrie
+	for i := 0; i < 10; i++ {
+		entries = append(entries, Entrie{
+			Publisher: Publisher{Name: fmt.Sprint("Publisher ", i), URL: fmt.Sprintf("https://publisher%d.example.com", i)},
+			Publications: []Publication{
+				{Name: "News", LanguageCode: "en", Type: "rss", Config: map[string]interface{}{"url": fmt.Sprintf("https://publisher%d.example.com/news.xml", i)}},
+			},
+		})
+	}
+	client := &fakeAPIClient{}
+	ip := &Importer{APIClient: client, Concurrency: 4, Upsert: true}
+	if err := ip.RunImport(context.Background(), entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.publishers) != 10 || len(client.publications) != 10 {
+		t.Fatalf("imported %d publishers and %d publications, want 10 of each", len(client.publishers), len(client.publications))
+	}
+	client.calls = nil
+	if err := ip.RunImport(context.Background(), entries); err != nil {
+		t.Fatal(err)
+	}
+	for _, call := range client.calls {
+		if call != "GetPublishers" && call != "GetPublisherPublications" {
+			t.Errorf("repeated import calls %s", call)
+		}
+	}
+}
+
+func TestPublicationConfigMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  PublicationConfig
+		stored  string
+		want    bool
+		wantErr bool
+	}{
+		{name: "equal", config: map[string]interface{}{"url": "https://example.com/feed"}, stored: `{"url":"https://example.com/feed"}`, want: true},
+		{name: "struct config", config: struct {
+			URL string `json:"url"`
+		}{"https://example.com/feed"}, stored: `{"url":"https://example.com/feed"}`, want: true},
+		{name: "stored defaults", config: map[string]interface{}{"url": "https://example.com/feed"}, stored: `{"url":"https://example.com/feed","interval":60}`, want: true},
+		{name: "numbers", config: map[string]interface{}{"interval": 60}, stored: `{"interval":60.0}`, want: true},
+		{name: "nested", config: map[string]interface{}{"selectors": map[string]string{"title": "a"}}, stored: `{"selectors":{"title":"a"}}`, want: true},
+		{name: "different value", config: map[string]interface{}{"url": "https://example.com/feed"}, stored: `{"url":"https://example.com/other"}`},
+		{name: "field missing in stored", config: map[string]interface{}{"url": "https://example.com/feed", "interval": 60}, stored: `{"url":"https://example.com/feed"}`},
+		{name: "different nested field", config: map[string]interface{}{"selectors": map[string]string{"title": "a"}}, stored: `{"selectors":{"title":"a","link":"a"}}`},
+		{name: "nothing stored", config: map[string]interface{}{"url": "https://example.com/feed"}},
+		{name: "no config and nothing stored", want: true},
+		{name: "no config", stored: `{"url":"https://example.com/feed"}`},
+		{name: "not objects", config: []string{"a"}, stored: `["a"]`, want: true},
+		{name: "invalid stored", config: map[string]interface{}{}, stored: `{`, wantErr: true},
+		{name: "unencodable config", config: map[string]interface{}{"f": func() {}}, stored: `{}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := publicationConfigMatches(tt.config, json.RawMessage(tt.stored))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExistingPublishers(t *testing.T) {
+	example := entity.Publisher{UUID: uuid.Must(uuid.NewV4()), Name: "Example", URL: "https://example.com"}
+	other := entity.Publisher{UUID: uuid.Must(uuid.NewV4()), Name: "Other", URL: "https://other.com"}
+	existing := newExistingPublishers([]entity.Publisher{example, other})
+
+	tests := []struct {
+		name     string
+		findName string
+		findURL  string
+		want     *entity.Publisher
+		wantErr  bool
+	}{
+		{name: "by name and url", findName: "Example", findURL: "https://example.com", want: &example},
+		{name: "by name", findName: "Example", findURL: "https://www.example.com", want: &example},
+		{name: "by url", findName: "New Example", findURL: "https://example.com", want: &example},
+		{name: "not found", findName: "New", findURL: "https://new.com"},
+		{name: "name and url of different publishers", findName: "Example", findURL: "https://other.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := existing.find(tt.findName, tt.findURL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("found %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// Updated publisher replaces its old name and url
+	renamed := example
+	renamed.Name, renamed.URL = "Renamed", "https://renamed.com"
+	existing.set(renamed)
+	if got, _ := existing.find("Example", "https://example.com"); got != nil {
+		t.Errorf("found publisher %+v by old name and url", got)
+	}
+	if got, _ := existing.find("Renamed", "https://renamed.com"); got == nil || got.UUID != example.UUID {
+		t.Errorf("found publisher %+v, want renamed one", got)
+	}
+	if got, err := existing.find("Example", "https://other.com"); err != nil || got == nil || got.UUID != other.UUID {
+		t.Errorf("found publisher %+v, %v, want other one by url", got, err)
+	}
+}
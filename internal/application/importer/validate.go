@@ -0,0 +1,141 @@
+package importer
+
+// This file contains validation of import file with the same rules, which Publications API applies, without calling it
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Tarick/naca-publications/internal/spec"
+	"github.com/Tarick/naca-publications/pkg/problem"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/gofrs/uuid"
+)
+
+// Codes of problems found by file validation, in addition to validation codes of Publications API
+const (
+	ProblemDuplicate     string = "duplicate"
+	ProblemInvalidConfig string = "invalid_config"
+)
+
+// ValidationProblem is invalid value in import file
+type ValidationProblem struct {
+	// Path is JSON path of value, e.g. $[0].publications[1].config.url
+	Path   string `json:"path"`
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+func (p ValidationProblem) String() string {
+	return fmt.Sprintf("%s: %s (%s)", p.Path, p.Reason, p.Code)
+}
+
+// Validate checks entries of import file and returns all found problems, ordered by path of entry.
+// Publishers and publications are validated as Publications API does, duplicate publishers names and urls,
+// and publications names of publisher are reported as well.
+func Validate(entries []Entrie) []ValidationProblem {
+	problems := []ValidationProblem{}
+	// paths of first occurrences of publishers names and urls
+	publisherNames, publisherURLs := map[string]string{}, map[string]string{}
+	for i, entrie := range entries {
+		path := fmt.Sprintf("$[%d].publisher", i)
+		problems = append(problems, validationProblems(path+".", validatePublisher(entrie.Publisher))...)
+		problems = append(problems, duplicateProblem(publisherNames, entrie.Publisher.Name, path+".name")...)
+		problems = append(problems, duplicateProblem(publisherURLs, entrie.Publisher.URL, path+".url")...)
+
+		publicationNames := map[string]string{}
+		for j, publication := range entrie.Publications {
+			path := fmt.Sprintf("$[%d].publications[%d]", i, j)
+			problems = append(problems, validationProblems(path+".", validatePublication(publication))...)
+			problems = append(problems, duplicateProblem(publicationNames, publication.Name, path+".name")...)
+		}
+	}
+	return problems
+}
+
+// duplicateProblems returns only duplicates found by Validate
+func duplicateProblems(entries []Entrie) []ValidationProblem {
+	duplicates := []ValidationProblem{}
+	for _, p := range Validate(entries) {
+		if p.Code == ProblemDuplicate {
+			duplicates = append(duplicates, p)
+		}
+	}
+	return duplicates
+}
+
+// validatePublisher validates publisher as request body of its creation
+func validatePublisher(publisher Publisher) error {
+	body := &spec.PublisherRequestBody{Name: publisher.Name, URL: publisher.URL}
+	return body.Validate()
+}
+
+// validatePublication validates publication as request body of its creation and then its config, if publication type is known.
+// Publisher of publication is not known before import, so its UUID is not validated.
+func validatePublication(publication Publication) error {
+	config, err := json.Marshal(publication.Config)
+	if err != nil {
+		return err
+	}
+	body := &spec.PublicationRequestBody{
+		Name:          publication.Name,
+		Description:   publication.Description,
+		LanguageCode:  publication.LanguageCode,
+		PublisherUUID: uuid.Nil,
+		Type:          publication.Type,
+	}
+	errs := validation.Errors{}
+	if err := body.Validate(); err != nil {
+		var validationErrors validation.Errors
+		if !errors.As(err, &validationErrors) {
+			return err
+		}
+		errs = validationErrors
+	}
+	delete(errs, "publisher_uuid")
+	// Publication type is named type in import file, config of unknown type is not validated
+	if err, ok := errs["publication_type"]; ok {
+		delete(errs, "publication_type")
+		errs["type"] = err
+		return errs
+	}
+	if _, err := spec.DecodePublicationConfig(publication.Type, config); err != nil {
+		var validationErrors validation.Errors
+		if errors.As(err, &validationErrors) {
+			errs["config"] = validationErrors["config"]
+		} else {
+			errs["config"] = validation.NewError(ProblemInvalidConfig, err.Error())
+		}
+	}
+	return errs.Filter()
+}
+
+// validationProblems converts validation errors to problems with paths prefixed with prefix
+func validationProblems(prefix string, err error) []ValidationProblem {
+	if err == nil {
+		return nil
+	}
+	var validationErrors validation.Errors
+	if !errors.As(err, &validationErrors) {
+		return []ValidationProblem{{Path: prefix[:len(prefix)-1], Code: problem.CodeValidationInvalid, Reason: err.Error()}}
+	}
+	problems := []ValidationProblem{}
+	for _, param := range spec.InvalidParams(prefix, validationErrors) {
+		problems = append(problems, ValidationProblem{Path: param.Name, Code: param.Code, Reason: param.Reason})
+	}
+	return problems
+}
+
+// duplicateProblem reports value, which has been seen before at other path, empty values are not checked
+func duplicateProblem(seen map[string]string, value string, path string) []ValidationProblem {
+	if value == "" {
+		return nil
+	}
+	if first, ok := seen[value]; ok {
+		return []ValidationProblem{{Path: path, Code: ProblemDuplicate, Reason: fmt.Sprintf("%q is duplicate of %s", value, first)}}
+	}
+	seen[value] = path
+	return nil
+}
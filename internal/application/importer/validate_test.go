@@ -0,0 +1,106 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	rss := func(name string, url string) Publication {
+		return Publication{Name: name, Description: "Feed of " + name, LanguageCode: "en", Type: "rss", Config: map[string]interface{}{"url": url}}
+	}
+	tests := []struct {
+		name    string
+		entries []Entrie
+		// want are paths and codes of problems
+		want []string
+	}{
+		{name: "valid entries", entries: []Entrie{
+			{Publisher: Publisher{Name: "Example", URL: "https://example.com"}, Publications: []Publication{
+				rss("News", "https://example.com/news.xml"),
+				{Name: "Scrapped", Description: "Scrapped news", LanguageCode: "de", Type: "scrapped", Config: map[string]interface{}{
+					"start_url": "https://example.com/news", "item_selector": "li", "title_selector": "a", "link_selector": "a", "schedule": "*/15 * * * *"}},
+				{Name: "API", Description: "News of API", LanguageCode: "fr", Type: "api", Config: map[string]interface{}{
+					"url": "https://api.example.com", "api_key_ref": "example/key"}},
+			}},
+			{Publisher: Publisher{Name: "Other", URL: "https://other.com"}, Publications: []Publication{rss("News", "https://other.com/news.xml")}},
+			{Publisher: Publisher{Name: "Empty", URL: "https://empty.com"}},
+		}},
+		{name: "missing fields", entries: []Entrie{{Publications: []Publication{{}}}},
+			want: []string{
+				"$[0].publisher.name validation_required",
+				"$[0].publisher.url validation_required",
+				"$[0].publications[0].description validation_required",
+				"$[0].publications[0].language_code validation_required",
+				"$[0].publications[0].name validation_required",
+				"$[0].publications[0].type validation_required",
+			}},
+		{name: "invalid fields", entries: []Entrie{
+			{Publisher: Publisher{Name: "Example", URL: "https://example.com"}, Publications: []Publication{
+				{Name: "N", Description: "Feed", LanguageCode: "xx", Type: "rss", Config: map[string]interface{}{"url": "https://example.com/news.xml"}},
+			}},
+		},
+			want: []string{
+				"$[0].publications[0].description validation_length_out_of_range",
+				"$[0].publications[0].language_code validation_is_language_code_2_letter",
+				"$[0].publications[0].name validation_length_out_of_range",
+			}},
+		{name: "unknown type", entries: []Entrie{
+			{Publisher: Publisher{Name: "Example", URL: "https://example.com"}, Publications: []Publication{
+				{Name: "Podcast", Description: "Podcast feed", LanguageCode: "en", Type: "podcast", Config: "not validated"},
+			}},
+		},
+			want: []string{"$[0].publications[0].type validation_invalid"}},
+		{name: "invalid configs", entries: []Entrie{
+			{Publisher: Publisher{Name: "Example", URL: "https://example.com"}, Publications: []Publication{
+				rss("News", "not url"),
+				{Name: "Missing", Description: "Missing config", LanguageCode: "en", Type: "rss"},
+				{Name: "Text", Description: "Text config", LanguageCode: "en", Type: "rss", Config: "https://example.com/news.xml"},
+				{Name: "API", Description: "News of API", LanguageCode: "en", Type: "api", Config: map[string]interface{}{
+					"url": "https://api.example.com", "api_key_ref": "example key", "language_code": "xx"}},
+			}},
+		},
+			want: []string{
+				"$[0].publications[0].config.url validation_is_url",
+				"$[0].publications[1].config.url validation_required",
+				"$[0].publications[2].config invalid_config",
+				"$[0].publications[3].config.api_key_ref validation_match_invalid",
+				"$[0].publications[3].config.language_code validation_is_language_code_2_letter",
+			}},
+		{name: "duplicates", entries: []Entrie{
+			{Publisher: Publisher{Name: "Example", URL: "https://example.com"}, Publications: []Publication{
+				rss("News", "https://example.com/news.xml"),
+				rss("Sport", "https://example.com/sport.xml"),
+				rss("News", "https://example.com/news2.xml"),
+			}},
+			{Publisher: Publisher{Name: "Example", URL: "https://other.com"}, Publications: []Publication{rss("News", "https://other.com/news.xml")}},
+			{Publisher: Publisher{Name: "Other", URL: "https://example.com"}},
+		},
+			want: []string{
+				"$[0].publications[2].name duplicate",
+				"$[1].publisher.name duplicate",
+				"$[2].publisher.url duplicate",
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, p := range Validate(tt.entries) {
+				got = append(got, p.Path+" "+p.Code)
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("problems:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestDuplicateProblemReason(t *testing.T) {
+	problems := duplicateProblems([]Entrie{
+		{Publisher: Publisher{Name: "Example", URL: "https://example.com"}},
+		{Publisher: Publisher{Name: "Example"}},
+	})
+	if len(problems) != 1 || problems[0].Reason != `"Example" is duplicate of $[0].publisher.name` {
+		t.Errorf("duplicates = %v, want duplicate name of first publisher", problems)
+	}
+}
@@ -0,0 +1,204 @@
+package reconciler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/Tarick/naca-publications/internal/spec"
+
+	"github.com/gofrs/uuid"
+)
+
+// pageLimit is number of publications read from repository at once
+const pageLimit int = 1000
+
+// clockSkewMargin is subtracted from reconciliation start time, when it is compared with outbox times of database clock
+const clockSkewMargin = time.Minute
+
+// Reconciler detects and repairs drift between publications of type RSS and RSS Feeds service feeds.
+// Publications repository is the source of truth.
+type Reconciler struct {
+	logger            Logger
+	repository        PublicationsRepository
+	rssFeedsAPIClient RSSFeedsAPIClient
+}
+
+// PublicationsRepository is used to list publications and their not yet or recently delivered changes
+type PublicationsRepository interface {
+	GetPublicationsPage(context.Context, entity.PublicationsQuery) ([]*entity.Publication, error)
+	GetPendingOutboxAggregates(context.Context, time.Time) ([]uuid.UUID, error)
+}
+
+// RSSFeedsAPIClient is used to call RSS Feeds service
+type RSSFeedsAPIClient interface {
+	GetAllRSSFeeds(context.Context) ([]Feed, error)
+	CreateRSSFeed(context.Context, uuid.UUID, string, string) error
+	UpdateRSSFeed(context.Context, uuid.UUID, string, string) error
+	DeleteRSSFeed(context.Context, uuid.UUID) error
+}
+
+// Feed is RSS Feeds service feed, one per publication
+type Feed struct {
+	PublicationUUID uuid.UUID `json:"publication_uuid"`
+	URL             string    `json:"url"`
+	LanguageCode    string    `json:"language_code"`
+}
+
+// Mismatch is a feed, which differs from its publication
+type Mismatch struct {
+	Expected Feed `json:"expected"`
+	Actual   Feed `json:"actual"`
+}
+
+// FixError is failure to repair the feed
+type FixError struct {
+	PublicationUUID uuid.UUID `json:"publication_uuid"`
+	Error           string    `json:"error"`
+}
+
+// Report is reconciliation result
+type Report struct {
+	PublicationsChecked int `json:"publications_checked"`
+	FeedsChecked        int `json:"feeds_checked"`
+	// Missing feeds exist as publications, but not in RSS Feeds service
+	Missing []Feed `json:"missing"`
+	// Orphaned feeds exist in RSS Feeds service without publications
+	Orphaned   []Feed     `json:"orphaned"`
+	Mismatched []Mismatch `json:"mismatched"`
+	// Pending publications have changes not yet delivered by outbox dispatcher or delivered during reconciliation,
+	// so they're not compared
+	Pending   []uuid.UUID `json:"pending"`
+	Fixed     int         `json:"fixed"`
+	FixErrors []FixError  `json:"fix_errors"`
+}
+
+// InSync returns true if there is no drift left
+func (r *Report) InSync() bool {
+	return len(r.Missing)+len(r.Orphaned)+len(r.Mismatched) == r.Fixed && len(r.FixErrors) == 0
+}
+
+// New creates Reconciler
+func New(logger Logger, repository PublicationsRepository, rssFeedsAPIClient RSSFeedsAPIClient) *Reconciler {
+	return &Reconciler{
+		logger:            logger,
+		repository:        repository,
+		rssFeedsAPIClient: rssFeedsAPIClient,
+	}
+}
+
+// Run compares publications with RSS feeds and, if fix is set, repairs RSS feeds to match publications
+func (rc *Reconciler) Run(ctx context.Context, fix bool) (*Report, error) {
+	report := &Report{
+		Missing:    []Feed{},
+		Orphaned:   []Feed{},
+		Mismatched: []Mismatch{},
+		Pending:    []uuid.UUID{},
+		FixErrors:  []FixError{},
+	}
+	start := time.Now()
+	expected, err := rc.expectedFeeds(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failure getting publications: %w", err)
+	}
+	report.PublicationsChecked = len(expected)
+	feeds, err := rc.rssFeedsAPIClient.GetAllRSSFeeds(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failure getting RSS feeds: %w", err)
+	}
+	report.FeedsChecked = len(feeds)
+	actual := make(map[uuid.UUID]Feed, len(feeds))
+	for _, feed := range feeds {
+		actual[feed.PublicationUUID] = feed
+	}
+	// Publications and feeds are read at different times. Changes, which are delivered in between or not delivered yet,
+	// would be seen as drift, so publications with outbox events pending after reading or dispatched since start are skipped.
+	pendingUUIDs, err := rc.repository.GetPendingOutboxAggregates(ctx, start.Add(-clockSkewMargin))
+	if err != nil {
+		return nil, fmt.Errorf("failure getting pending outbox events: %w", err)
+	}
+	pending := make(map[uuid.UUID]bool, len(pendingUUIDs))
+	for _, u := range pendingUUIDs {
+		pending[u] = true
+	}
+
+	for publicationUUID, expectedFeed := range expected {
+		if pending[publicationUUID] {
+			report.Pending = append(report.Pending, publicationUUID)
+			continue
+		}
+		actualFeed, ok := actual[publicationUUID]
+		switch {
+		case !ok:
+			report.Missing = append(report.Missing, expectedFeed)
+		case actualFeed != expectedFeed:
+			report.Mismatched = append(report.Mismatched, Mismatch{Expected: expectedFeed, Actual: actualFeed})
+		}
+	}
+	for publicationUUID, actualFeed := range actual {
+		if _, ok := expected[publicationUUID]; !ok && !pending[publicationUUID] {
+			report.Orphaned = append(report.Orphaned, actualFeed)
+		}
+	}
+	if fix {
+		rc.fix(ctx, report)
+	}
+	return report, nil
+}
+
+// expectedFeeds returns feeds built from all RSS publications, mapped by publication UUID
+func (rc *Reconciler) expectedFeeds(ctx context.Context) (map[uuid.UUID]Feed, error) {
+	expected := map[uuid.UUID]Feed{}
+	query := entity.PublicationsQuery{
+		Page: entity.Page{Limit: pageLimit},
+		Type: spec.PublicationTypeRSS,
+	}
+	for {
+		publications, err := rc.repository.GetPublicationsPage(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		for _, publication := range publications {
+			config := spec.RSSPublicationConfig{}
+			if err := json.Unmarshal(publication.Config, &config); err != nil {
+				return nil, fmt.Errorf("failure reading publication %v config: %w", publication.UUID, err)
+			}
+			expected[publication.UUID] = Feed{
+				PublicationUUID: publication.UUID,
+				URL:             config.URL,
+				LanguageCode:    publication.LanguageCode,
+			}
+		}
+		if len(publications) < query.Limit {
+			return expected, nil
+		}
+		query.After = publications[len(publications)-1].UUID
+	}
+}
+
+// fix repairs feeds in RSS Feeds service according to the report
+func (rc *Reconciler) fix(ctx context.Context, report *Report) {
+	fixed := func(publicationUUID uuid.UUID, err error) {
+		if err != nil {
+			rc.logger.Error(fmt.Sprintf("Failure fixing RSS feed of publication %v: %s", publicationUUID, err))
+			report.FixErrors = append(report.FixErrors, FixError{PublicationUUID: publicationUUID, Error: err.Error()})
+			return
+		}
+		report.Fixed++
+	}
+	for _, feed := range report.Missing {
+		rc.logger.Info("Creating missing RSS feed ", feed)
+		fixed(feed.PublicationUUID, rc.rssFeedsAPIClient.CreateRSSFeed(ctx, feed.PublicationUUID, feed.URL, feed.LanguageCode))
+	}
+	for _, feed := range report.Orphaned {
+		rc.logger.Info("Deleting orphaned RSS feed ", feed)
+		fixed(feed.PublicationUUID, rc.rssFeedsAPIClient.DeleteRSSFeed(ctx, feed.PublicationUUID))
+	}
+	for _, mismatch := range report.Mismatched {
+		feed := mismatch.Expected
+		rc.logger.Info("Updating mismatched RSS feed ", feed)
+		fixed(feed.PublicationUUID, rc.rssFeedsAPIClient.UpdateRSSFeed(ctx, feed.PublicationUUID, feed.URL, feed.LanguageCode))
+	}
+}
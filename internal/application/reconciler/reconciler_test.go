@@ -0,0 +1,169 @@
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/gofrs/uuid"
+	"go.uber.org/zap"
+)
+
+// fakeRepository returns publications sorted by UUID by pages and pending aggregates, it records order of reads in log
+type fakeRepository struct {
+	log             *[]string
+	publications    []*entity.Publication
+	pending         []uuid.UUID
+	dispatchedSince time.Time
+}
+
+func (repo *fakeRepository) GetPublicationsPage(_ context.Context, query entity.PublicationsQuery) ([]*entity.Publication, error) {
+	*repo.log = append(*repo.log, "publications")
+	page := []*entity.Publication{}
+	for _, p := range repo.publications {
+		if p.Type == query.Type && strings.Compare(p.UUID.String(), query.After.String()) > 0 && len(page) < query.Limit {
+			page = append(page, p)
+		}
+	}
+	return page, nil
+}
+
+func (repo *fakeRepository) GetPendingOutboxAggregates(_ context.Context, dispatchedSince time.Time) ([]uuid.UUID, error) {
+	*repo.log = append(*repo.log, "pending")
+	repo.dispatchedSince = dispatchedSince
+	return repo.pending, nil
+}
+
+// fakeRSSFeedsAPIClient returns feeds and records changing calls in log, failing calls of publications in errs
+type fakeRSSFeedsAPIClient struct {
+	log   *[]string
+	feeds []Feed
+	errs  map[uuid.UUID]error
+}
+
+func (c *fakeRSSFeedsAPIClient) GetAllRSSFeeds(context.Context) ([]Feed, error) {
+	*c.log = append(*c.log, "feeds")
+	return c.feeds, nil
+}
+
+func (c *fakeRSSFeedsAPIClient) CreateRSSFeed(_ context.Context, publicationUUID uuid.UUID, url string, _ string) error {
+	*c.log = append(*c.log, "create "+url)
+	return c.errs[publicationUUID]
+}
+
+func (c *fakeRSSFeedsAPIClient) UpdateRSSFeed(_ context.Context, publicationUUID uuid.UUID, url string, _ string) error {
+	*c.log = append(*c.log, "update "+url)
+	return c.errs[publicationUUID]
+}
+
+func (c *fakeRSSFeedsAPIClient) DeleteRSSFeed(_ context.Context, publicationUUID uuid.UUID) error {
+	*c.log = append(*c.log, "delete "+publicationUUID.String())
+	return c.errs[publicationUUID]
+}
+
+func rssPublication(url string) *entity.Publication {
+	return &entity.Publication{UUID: uuid.Must(uuid.NewV4()), Type: "rss", LanguageCode: "en", Config: []byte(`{"url":"` + url + `"}`)}
+}
+
+func TestReconcilerRun(t *testing.T) {
+	inSync := rssPublication("https://example.com/in-sync")
+	missing := rssPublication("https://example.com/missing")
+	mismatched := rssPublication("https://example.com/mismatched")
+	pending := rssPublication("https://example.com/pending")
+	scrapped := &entity.Publication{UUID: uuid.Must(uuid.NewV4()), Type: "scrapped", Config: []byte(`{}`)}
+	orphaned := Feed{PublicationUUID: uuid.Must(uuid.NewV4()), URL: "https://example.com/orphaned", LanguageCode: "en"}
+	pendingDeletion := Feed{PublicationUUID: uuid.Must(uuid.NewV4()), URL: "https://example.com/deleted", LanguageCode: "en"}
+	publications := []*entity.Publication{inSync, missing, mismatched, pending, scrapped}
+	feeds := []Feed{
+		{PublicationUUID: inSync.UUID, URL: "https://example.com/in-sync", LanguageCode: "en"},
+		{PublicationUUID: mismatched.UUID, URL: "https://example.com/old", LanguageCode: "en"},
+		{PublicationUUID: pending.UUID, URL: "https://example.com/old", LanguageCode: "en"},
+		orphaned,
+		pendingDeletion,
+	}
+
+	tests := []struct {
+		name        string
+		fix         bool
+		errs        map[uuid.UUID]error
+		wantChanges []string
+		wantFixed   int
+		wantFixErrs int
+		wantInSync  bool
+	}{
+		{name: "report"},
+		{name: "fix", fix: true,
+			wantChanges: []string{"create https://example.com/missing", "delete " + orphaned.PublicationUUID.String(), "update https://example.com/mismatched"},
+			wantFixed:   3, wantInSync: true},
+		{name: "fix failure", fix: true, errs: map[uuid.UUID]error{missing.UUID: errors.New("failed")},
+			wantChanges: []string{"create https://example.com/missing", "delete " + orphaned.PublicationUUID.String(), "update https://example.com/mismatched"},
+			wantFixed:   2, wantFixErrs: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := []string{}
+			repo := &fakeRepository{log: &log, publications: publications, pending: []uuid.UUID{pending.UUID, pendingDeletion.PublicationUUID}}
+			client := &fakeRSSFeedsAPIClient{log: &log, feeds: feeds, errs: tt.errs}
+			start := time.Now()
+			report, err := New(zap.NewNop().Sugar(), repo, client).Run(context.Background(), tt.fix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Pending events are read after publications and feeds, including ones dispatched since start
+			if strings.Join(log[:3], ",") != "publications,feeds,pending" {
+				t.Errorf("reads %v, want publications, feeds and pending outbox events", log[:3])
+			}
+			if repo.dispatchedSince.After(start) {
+				t.Errorf("pending outbox events dispatched since %v, want before start %v", repo.dispatchedSince, start)
+			}
+			changes := append([]string{}, log[3:]...)
+			sort.Strings(changes)
+			if strings.Join(changes, ",") != strings.Join(tt.wantChanges, ",") {
+				t.Errorf("changes %v, want %v", changes, tt.wantChanges)
+			}
+			if report.PublicationsChecked != 4 || report.FeedsChecked != 5 {
+				t.Errorf("checked %d publications and %d feeds, want 4 and 5", report.PublicationsChecked, report.FeedsChecked)
+			}
+			if len(report.Missing) != 1 || report.Missing[0].PublicationUUID != missing.UUID {
+				t.Errorf("missing %v, want feed of %v", report.Missing, missing.UUID)
+			}
+			if len(report.Orphaned) != 1 || report.Orphaned[0] != orphaned {
+				t.Errorf("orphaned %v, want %v", report.Orphaned, orphaned)
+			}
+			if len(report.Mismatched) != 1 || report.Mismatched[0].Expected.URL != "https://example.com/mismatched" || report.Mismatched[0].Actual.URL != "https://example.com/old" {
+				t.Errorf("mismatched %v, want feed of %v", report.Mismatched, mismatched.UUID)
+			}
+			if len(report.Pending) != 1 || report.Pending[0] != pending.UUID {
+				t.Errorf("pending %v, want %v", report.Pending, pending.UUID)
+			}
+			if report.Fixed != tt.wantFixed || len(report.FixErrors) != tt.wantFixErrs {
+				t.Errorf("fixed %d with %d errors, want %d with %d errors", report.Fixed, len(report.FixErrors), tt.wantFixed, tt.wantFixErrs)
+			}
+			if report.InSync() != tt.wantInSync {
+				t.Errorf("in sync = %v, want %v", report.InSync(), tt.wantInSync)
+			}
+		})
+	}
+}
+
+func TestReconcilerReadsAllPages(t *testing.T) {
+	log := []string{}
+	repo := &fakeRepository{log: &log}
+	for i := 0; i < pageLimit+1; i++ {
+		repo.publications = append(repo.publications, rssPublication("https://example.com/feed"))
+	}
+	sort.Slice(repo.publications, func(i, j int) bool {
+		return repo.publications[i].UUID.String() < repo.publications[j].UUID.String()
+	})
+	report, err := New(zap.NewNop().Sugar(), repo, &fakeRSSFeedsAPIClient{log: &log}).Run(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.PublicationsChecked != pageLimit+1 || len(report.Missing) != pageLimit+1 {
+		t.Errorf("checked %d publications with %d missing feeds, want %d", report.PublicationsChecked, len(report.Missing), pageLimit+1)
+	}
+}
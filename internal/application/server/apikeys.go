@@ -0,0 +1,147 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/gofrs/uuid"
+)
+
+func (s *Server) apiKeysRouter() http.Handler {
+	r := chi.NewRouter()
+	// swagger:operation GET /api-keys getAPIKeys
+	// Returns API keys, including revoked ones. Keys themselves are not returned.
+	// ---
+	// responses:
+	//   '200':
+	//     description: list API keys
+	//     schema:
+	//       type: array
+	//       items:
+	//         $ref: "#/definitions/APIKeyResponseBody"
+	//   default:
+	//     $ref: "#/responses/ErrResponse"
+	r.Get("/", s.getAPIKeys)
+
+	// swagger:operation POST /api-keys createAPIKey
+	// Creates API key. The key is returned only in this response.
+	// ---
+	// parameters:
+	//  - name: body
+	//    in: body
+	//    required: true
+	//    schema:
+	//      $ref: "#/definitions/APIKeyRequestBody"
+	// responses:
+	//   '201':
+	//     description: created API key
+	//     schema:
+	//       $ref: "#/definitions/APIKeyResponseBody"
+	//   default:
+	//     $ref: "#/responses/ErrResponse"
+	r.Post("/", s.createAPIKey)
+
+	// swagger:operation DELETE /api-keys/{api_key_uuid} revokeAPIKey
+	// Revokes API key
+	// ---
+	// parameters:
+	//  - name: api_key_uuid
+	//    in: path
+	//    description: API key uuid to revoke
+	//    required: true
+	//    type: string
+	// responses:
+	//  '204':
+	//    description: Send success
+	//  default:
+	//    $ref: "#/responses/ErrResponse"
+	r.Delete("/{api_key_uuid}", s.revokeAPIKey)
+	return r
+}
+
+// APIKeyRequestBody contains information on API key creation
+// swagger:model
+type APIKeyRequestBody struct {
+	Name string `json:"name"`
+	// Role is reader, editor or admin
+	Role string `json:"role"`
+}
+
+// Bind implements Bind interface for chi Bind to map request body to request body struct
+func (b *APIKeyRequestBody) Bind(r *http.Request) error {
+	return validation.ValidateStruct(b,
+		validation.Field(&b.Name, validation.Required, validation.Length(2, 200)),
+		validation.Field(&b.Role, validation.Required, validation.In(entity.RoleReader, entity.RoleEditor, entity.RoleAdmin)),
+	)
+}
+
+// APIKeyResponseBody describes API key
+// swagger:model
+type APIKeyResponseBody struct {
+	// swagger:allOf
+	*entity.APIKey
+	// Key is returned only on creation, use it as bearer token in Authorization header
+	Key string `json:"key,omitempty"`
+}
+
+func (s *Server) getAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.repository.GetAPIKeys(r.Context())
+	if err != nil {
+		s.logger.Error(fmt.Sprint("Failure querying for API keys: ", err))
+		ErrInternal(errors.New("Failure querying database for API keys")).Render(w, r)
+		return
+	}
+	response := make([]*APIKeyResponseBody, len(keys))
+	for i, key := range keys {
+		response[i] = &APIKeyResponseBody{APIKey: key}
+	}
+	render.JSON(w, r, response)
+}
+
+func (s *Server) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	data := &APIKeyRequestBody{}
+	if err := render.Bind(r, data); err != nil {
+		ErrRequestBody(err).Render(w, r)
+		return
+	}
+	key, secret, err := entity.NewAPIKey(data.Name, data.Role)
+	if err != nil {
+		ErrInternal(err).Render(w, r)
+		return
+	}
+	if err := s.repository.CreateAPIKey(r.Context(), key); err != nil {
+		s.logger.Error(fmt.Sprintf("Failure creating API key %s: %s", key.Name, err))
+		ErrInternal(errors.New("Failure creating API key")).Render(w, r)
+		return
+	}
+	s.logger.Info(fmt.Sprintf("API key %s with role %s is created by %s", key.Name, key.Role, actor(r)))
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, &APIKeyResponseBody{APIKey: key, Key: secret})
+}
+
+func (s *Server) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyUUIDParam := chi.URLParam(r, "api_key_uuid")
+	keyUUID, err := uuid.FromString(keyUUIDParam)
+	if err != nil {
+		ErrInvalidRequest(fmt.Errorf("invalid uuid parameter %s", keyUUIDParam)).Render(w, r)
+		return
+	}
+	err = s.repository.RevokeAPIKey(r.Context(), keyUUID)
+	if errors.Is(err, entity.ErrNotFound) {
+		ErrAPIKeyNotFound.Render(w, r)
+		return
+	}
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("Failure revoking API key %s: %s", keyUUID, err))
+		ErrInternal(errors.New("Failure revoking API key")).Render(w, r)
+		return
+	}
+	s.logger.Info(fmt.Sprintf("API key %s is revoked by %s", keyUUID, actor(r)))
+	render.NoContent(w, r)
+}
@@ -0,0 +1,111 @@
+package server
+
+// This file contains audit log of publishers and publications changes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/render"
+	"github.com/gofrs/uuid"
+)
+
+// auditCursorSort marks audit log cursors, entries are ordered by ID
+const auditCursorSort string = "id"
+
+// newAuditEntry creates audit log entry of the change made by request, before and after are entity states, nil if absent
+func newAuditEntry(r *http.Request, entityType string, entityUUID uuid.UUID, action string, before interface{}, after interface{}) (*entity.AuditEntry, error) {
+	return entity.NewAuditEntry(entityType, entityUUID, action, actor(r), middleware.GetReqID(r.Context()), before, after)
+}
+
+// auditQueryFromRequest reads time range and pagination query parameters
+func auditQueryFromRequest(r *http.Request) (entity.AuditQuery, error) {
+	query := entity.AuditQuery{}
+	page, err := pageFromRequest(r, auditCursorSort)
+	if err != nil {
+		return query, err
+	}
+	query.Limit = page.Limit
+	if value, ok := page.AfterValue.(string); ok {
+		if query.AfterID, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return query, fmt.Errorf("invalid cursor parameter")
+		}
+	}
+	for param, t := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		value := r.URL.Query().Get(param)
+		if value == "" {
+			continue
+		}
+		if *t, err = time.Parse(time.RFC3339, value); err != nil {
+			return query, fmt.Errorf("invalid %s parameter %s, must be RFC3339 time", param, value)
+		}
+	}
+	return query, nil
+}
+
+// getAudit returns audit log entries of all entities
+func (s *Server) getAudit(w http.ResponseWriter, r *http.Request) {
+	query, err := auditQueryFromRequest(r)
+	if err != nil {
+		ErrInvalidRequest(err).Render(w, r)
+		return
+	}
+	query.EntityType = r.URL.Query().Get("entity_type")
+	switch query.EntityType {
+	case "", entity.AuditEntityPublisher, entity.AuditEntityPublication:
+	default:
+		ErrInvalidRequest(fmt.Errorf("invalid entity_type parameter %s", query.EntityType)).Render(w, r)
+		return
+	}
+	s.renderAuditEntries(w, r, query)
+}
+
+// getPublisherHistory returns audit log entries of publisher, including deleted one
+func (s *Server) getPublisherHistory(w http.ResponseWriter, r *http.Request) {
+	s.getEntityHistory(w, r, entity.AuditEntityPublisher, "publisher_uuid")
+}
+
+// getPublicationHistory returns audit log entries of publication, including deleted one
+func (s *Server) getPublicationHistory(w http.ResponseWriter, r *http.Request) {
+	s.getEntityHistory(w, r, entity.AuditEntityPublication, "publication_uuid")
+}
+
+func (s *Server) getEntityHistory(w http.ResponseWriter, r *http.Request, entityType string, uuidParam string) {
+	entityUUIDParam := chi.URLParam(r, uuidParam)
+	entityUUID, err := uuid.FromString(entityUUIDParam)
+	if err != nil {
+		ErrInvalidRequest(fmt.Errorf("invalid uuid parameter %s", entityUUIDParam)).Render(w, r)
+		return
+	}
+	query, err := auditQueryFromRequest(r)
+	if err != nil {
+		ErrInvalidRequest(err).Render(w, r)
+		return
+	}
+	query.EntityType, query.EntityUUID = entityType, entityUUID
+	s.renderAuditEntries(w, r, query)
+}
+
+func (s *Server) renderAuditEntries(w http.ResponseWriter, r *http.Request, query entity.AuditQuery) {
+	limit := query.Limit
+	// Request one more entry to find out if there is a next page
+	query.Limit++
+	entries, err := s.repository.GetAuditEntries(r.Context(), query)
+	if err != nil {
+		s.logger.Error(fmt.Sprint("Failure querying for audit log: ", err))
+		ErrInternal(errors.New("Failure querying database for audit log")).Render(w, r)
+		return
+	}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		setNextPageLink(w, r, limit, pageCursor{Sort: auditCursorSort, Value: strconv.FormatInt(entries[limit-1].ID, 10)})
+	}
+	render.JSON(w, r, entries)
+}
@@ -0,0 +1,142 @@
+package server
+
+// This file contains authentication of API clients with API keys or JWT bearer tokens and authorization by their roles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+)
+
+// AuthConfig defines authentication of API clients
+type AuthConfig struct {
+	// Enabled requires authentication of all requests, except for healthchecks, metrics and docs
+	Enabled bool `mapstructure:"enabled"`
+	// JWKSFile is the JSON Web Key Set to verify JWT bearer tokens, JWTs are not accepted if it is empty
+	JWKSFile string `mapstructure:"jwks_file"`
+	// JWTIssuer and JWTAudience are required in tokens, if they're set
+	JWTIssuer   string `mapstructure:"jwt_issuer"`
+	JWTAudience string `mapstructure:"jwt_audience"`
+	// JWTRoleClaim is the token claim with client role, "role" by default
+	JWTRoleClaim string `mapstructure:"jwt_role_claim"`
+	// AnonymousRole is role of all clients, when authentication is disabled, reader by default
+	AnonymousRole string `mapstructure:"anonymous_role"`
+}
+
+// identity is authenticated API client
+type identity struct {
+	// Name is recorded in audit log as actor
+	Name string
+	Role string
+	// ClientID uniquely identifies client for rate limiting, empty for anonymous clients
+	ClientID string
+}
+
+// anonymousName is name of identity of all clients, when authentication is disabled
+const anonymousName string = "anonymous"
+
+// newAnonymous returns identity of all clients, when authentication is disabled
+func newAnonymous(config AuthConfig) (*identity, error) {
+	role := config.AnonymousRole
+	if role == "" {
+		role = entity.RoleReader
+	}
+	if !entity.IsRole(role) {
+		return nil, fmt.Errorf("unknown anonymous role %s, must be one of: %s, %s, %s",
+			role, entity.RoleReader, entity.RoleEditor, entity.RoleAdmin)
+	}
+	return &identity{Name: anonymousName, Role: role}, nil
+}
+
+// actor returns name of identity, which makes the request
+func actor(r *http.Request) string {
+	if id, ok := r.Context().Value("identity").(*identity); ok {
+		return id.Name
+	}
+	return anonymousName
+}
+
+// authenticate is a middleware, which identifies client by API key or JWT in Authorization bearer token
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := s.anonymous
+		if s.auth.Enabled {
+			var err error
+			if id, err = s.identify(r); err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="publications"`)
+				ErrUnauthorized(err).Render(w, r)
+				return
+			}
+		}
+		ctx := context.WithValue(r.Context(), "identity", id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (s *Server) identify(r *http.Request) (*identity, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, errors.New("missing bearer token in Authorization header")
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	if strings.HasPrefix(token, entity.APIKeyPrefix) {
+		key, err := s.repository.GetAPIKeyByHash(r.Context(), entity.HashAPIKey(token))
+		if err != nil {
+			s.logger.Error("Failure getting API key: ", err)
+			return nil, errors.New("failure checking API key")
+		}
+		if key == nil {
+			return nil, errors.New("invalid API key")
+		}
+		return &identity{Name: "api-key:" + key.Name, Role: key.Role, ClientID: "api-key:" + key.UUID.String()}, nil
+	}
+	if s.jwks == nil {
+		return nil, errors.New("invalid API key")
+	}
+	claims, err := s.jwks.verify(token, s.auth.JWTIssuer, s.auth.JWTAudience, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	roleClaim := s.auth.JWTRoleClaim
+	if roleClaim == "" {
+		roleClaim = "role"
+	}
+	role, _ := claims[roleClaim].(string)
+	if !entity.IsRole(role) {
+		return nil, fmt.Errorf("token has no valid %s claim", roleClaim)
+	}
+	subject, _ := claims["sub"].(string)
+	return &identity{Name: "jwt:" + subject, Role: role, ClientID: "jwt:" + subject}, nil
+}
+
+// requireRole is a middleware, which allows only clients with permissions of the role
+func requireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, _ := r.Context().Value("identity").(*identity)
+			if id == nil || !entity.RoleAllows(id.Role, role) {
+				ErrForbidden(fmt.Errorf("%s role is required", role)).Render(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// authorize is a middleware, which allows reading to readers and changes to editors
+func authorize(next http.Handler) http.Handler {
+	reader, editor := requireRole(entity.RoleReader)(next), requireRole(entity.RoleEditor)(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			reader.ServeHTTP(w, r)
+		default:
+			editor.ServeHTTP(w, r)
+		}
+	})
+}
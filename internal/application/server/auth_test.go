@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/gofrs/uuid"
+	"go.uber.org/zap"
+)
+
+// fakeAPIKeysRepository keeps API keys in memory, revoked keys are not found by hash
+type fakeAPIKeysRepository struct {
+	PublicationsRepository
+	keys []*entity.APIKey
+}
+
+func (repo *fakeAPIKeysRepository) CreateAPIKey(_ context.Context, k *entity.APIKey) error {
+	k.CreatedAt = time.Now()
+	repo.keys = append(repo.keys, k)
+	return nil
+}
+
+func (repo *fakeAPIKeysRepository) GetAPIKeyByHash(_ context.Context, keyHash []byte) (*entity.APIKey, error) {
+	for _, k := range repo.keys {
+		if string(k.KeyHash) == string(keyHash) && k.RevokedAt == nil {
+			return k, nil
+		}
+	}
+	return nil, nil
+}
+
+func (repo *fakeAPIKeysRepository) GetAPIKeys(context.Context) ([]*entity.APIKey, error) {
+	return repo.keys, nil
+}
+
+func (repo *fakeAPIKeysRepository) RevokeAPIKey(_ context.Context, keyUUID uuid.UUID) error {
+	for _, k := range repo.keys {
+		if k.UUID == keyUUID && k.RevokedAt == nil {
+			now := time.Now()
+			k.RevokedAt = &now
+			return nil
+		}
+	}
+	return entity.ErrNotFound
+}
+
+// addKey stores new API key of role and returns the key
+func (repo *fakeAPIKeysRepository) addKey(t *testing.T, role string) string {
+	t.Helper()
+	k, key, err := entity.NewAPIKey(role+" client", role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.CreateAPIKey(context.Background(), k)
+	return key
+}
+
+func serve(s *Server, method string, target string, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		r.Header.Set("Authorization", token)
+	}
+	res := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(res, r)
+	return res
+}
+
+func TestAuthenticateAndAuthorize(t *testing.T) {
+	repo := &fakeAPIKeysRepository{}
+	reader, editor, admin := repo.addKey(t, entity.RoleReader), repo.addKey(t, entity.RoleEditor), repo.addKey(t, entity.RoleAdmin)
+	auth := AuthConfig{Enabled: true, JWKSFile: testJWKS(t), JWTIssuer: "https://auth.example.com/", JWTAudience: "publications-api"}
+	jwt := func(claims jwtClaims) string {
+		c := jwtClaims{"sub": "client", "iss": auth.JWTIssuer, "aud": auth.JWTAudience, "exp": time.Now().Add(time.Hour).Unix()}
+		for k, v := range claims {
+			c[k] = v
+		}
+		return "Bearer " + newJWT(t, testKeys.ec, "ES256", "ec", c)
+	}
+
+	// Requests passing authorization to handlers: publication types are read by readers,
+	// publisher without body is rejected for editors and API keys are listed for admins
+	tests := []struct {
+		name       string
+		auth       AuthConfig
+		method     string
+		target     string
+		token      string
+		wantStatus int
+	}{
+		{name: "no token", auth: auth, method: http.MethodGet, target: "/publication-types",
+			wantStatus: http.StatusUnauthorized},
+		{name: "basic authorization", auth: auth, method: http.MethodGet, target: "/publication-types", token: "Basic dXNlcjpwYXNz",
+			wantStatus: http.StatusUnauthorized},
+		{name: "unknown API key", auth: auth, method: http.MethodGet, target: "/publication-types", token: "Bearer " + entity.APIKeyPrefix + "unknown",
+			wantStatus: http.StatusUnauthorized},
+		{name: "reader API key reads", auth: auth, method: http.MethodGet, target: "/publication-types", token: "Bearer " + reader,
+			wantStatus: http.StatusOK},
+		{name: "reader API key changes", auth: auth, method: http.MethodPost, target: "/publishers", token: "Bearer " + reader,
+			wantStatus: http.StatusForbidden},
+		{name: "editor API key changes", auth: auth, method: http.MethodPost, target: "/publishers", token: "Bearer " + editor,
+			wantStatus: http.StatusBadRequest},
+		{name: "editor API key manages API keys", auth: auth, method: http.MethodGet, target: "/api-keys", token: "Bearer " + editor,
+			wantStatus: http.StatusForbidden},
+		{name: "admin API key manages API keys", auth: auth, method: http.MethodGet, target: "/api-keys", token: "Bearer " + admin,
+			wantStatus: http.StatusOK},
+		{name: "admin API key changes", auth: auth, method: http.MethodPost, target: "/publishers", token: "Bearer " + admin,
+			wantStatus: http.StatusBadRequest},
+
+		{name: "reader JWT reads", auth: auth, method: http.MethodGet, target: "/publication-types", token: jwt(jwtClaims{"role": entity.RoleReader}),
+			wantStatus: http.StatusOK},
+		{name: "reader JWT changes", auth: auth, method: http.MethodPost, target: "/publishers", token: jwt(jwtClaims{"role": entity.RoleReader}),
+			wantStatus: http.StatusForbidden},
+		{name: "editor JWT changes", auth: auth, method: http.MethodPost, target: "/publishers", token: jwt(jwtClaims{"role": entity.RoleEditor}),
+			wantStatus: http.StatusBadRequest},
+		{name: "JWT without role", auth: auth, method: http.MethodGet, target: "/publication-types", token: jwt(nil),
+			wantStatus: http.StatusUnauthorized},
+		{name: "JWT with unknown role", auth: auth, method: http.MethodGet, target: "/publication-types", token: jwt(jwtClaims{"role": "owner"}),
+			wantStatus: http.StatusUnauthorized},
+		{name: "expired JWT", auth: auth, method: http.MethodGet, target: "/publication-types",
+			token:      jwt(jwtClaims{"role": entity.RoleReader, "exp": time.Now().Add(-time.Minute).Unix()}),
+			wantStatus: http.StatusUnauthorized},
+		{name: "JWT with custom role claim", auth: AuthConfig{Enabled: true, JWKSFile: auth.JWKSFile, JWTRoleClaim: "publications_role"},
+			method: http.MethodPost, target: "/publishers", token: jwt(jwtClaims{"publications_role": entity.RoleEditor, "role": entity.RoleReader}),
+			wantStatus: http.StatusBadRequest},
+		{name: "JWT without JWKS", auth: AuthConfig{Enabled: true}, method: http.MethodGet, target: "/publication-types", token: jwt(jwtClaims{"role": entity.RoleReader}),
+			wantStatus: http.StatusUnauthorized},
+
+		{name: "anonymous reader reads", method: http.MethodGet, target: "/publication-types",
+			wantStatus: http.StatusOK},
+		{name: "anonymous reader changes", method: http.MethodPost, target: "/publishers",
+			wantStatus: http.StatusForbidden},
+		{name: "anonymous editor changes", auth: AuthConfig{AnonymousRole: entity.RoleEditor}, method: http.MethodPost, target: "/publishers",
+			wantStatus: http.StatusBadRequest},
+		{name: "anonymous editor manages API keys", auth: AuthConfig{AnonymousRole: entity.RoleEditor}, method: http.MethodGet, target: "/api-keys",
+			wantStatus: http.StatusForbidden},
+		{name: "tokens are ignored without authentication", method: http.MethodGet, target: "/api-keys", token: "Bearer " + admin,
+			wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(Config{Auth: tt.auth}, zap.NewNop().Sugar(), repo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := serve(s, tt.method, tt.target, tt.token)
+			if res.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d, body: %s", res.Code, tt.wantStatus, res.Body.String())
+			}
+			if wantChallenge := tt.wantStatus == http.StatusUnauthorized; (res.Header().Get("WWW-Authenticate") != "") != wantChallenge {
+				t.Errorf("WWW-Authenticate = %q, want challenge %v", res.Header().Get("WWW-Authenticate"), wantChallenge)
+			}
+		})
+	}
+}
+
+func TestNewRejectsUnknownAnonymousRole(t *testing.T) {
+	if _, err := New(Config{Auth: AuthConfig{AnonymousRole: "owner"}}, zap.NewNop().Sugar(), &fakeAPIKeysRepository{}); err == nil {
+		t.Error("unknown anonymous role is accepted")
+	}
+}
+
+func TestRevokedAPIKeyIsRejected(t *testing.T) {
+	repo := &fakeAPIKeysRepository{}
+	admin := "Bearer " + repo.addKey(t, entity.RoleAdmin)
+	s, err := New(Config{Auth: AuthConfig{Enabled: true}}, zap.NewNop().Sugar(), repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/api-keys", strings.NewReader(`{"name":"importer","role":"editor"}`))
+	r.Header.Set("Authorization", admin)
+	r.Header.Set("Content-Type", "application/json")
+	res := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(res, r)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, body: %s", res.Code, res.Body.String())
+	}
+	created := struct {
+		UUID uuid.UUID `json:"uuid"`
+		Key  string    `json:"key"`
+	}{}
+	if err := json.Unmarshal(res.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	key := "Bearer " + created.Key
+
+	if res := serve(s, http.MethodGet, "/publication-types", key); res.Code != http.StatusOK {
+		t.Fatalf("status of created key = %d, body: %s", res.Code, res.Body.String())
+	}
+	if res := serve(s, http.MethodDelete, "/api-keys/"+created.UUID.String(), admin); res.Code != http.StatusNoContent {
+		t.Fatalf("revoke status = %d, body: %s", res.Code, res.Body.String())
+	}
+	if res := serve(s, http.MethodGet, "/publication-types", key); res.Code != http.StatusUnauthorized {
+		t.Errorf("status of revoked key = %d, want %d", res.Code, http.StatusUnauthorized)
+	}
+	if res := serve(s, http.MethodDelete, "/api-keys/"+created.UUID.String(), admin); res.Code != http.StatusNotFound {
+		t.Errorf("repeated revoke status = %d, want %d", res.Code, http.StatusNotFound)
+	}
+	// Other keys are still valid
+	if res := serve(s, http.MethodGet, "/api-keys", admin); res.Code != http.StatusOK {
+		t.Errorf("status of admin key = %d, body: %s", res.Code, res.Body.String())
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+// This file contains cache of listings responses. Responses are cached by change counter of their collection in database,
+// so clients read their writes, even if they are made through other instance of API.
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/go-chi/stampede/singleflight"
+)
+
+// Cache groups label cache metrics. Responses are not invalidated on writes: keys include change counter of collection,
+// so responses of previous state are never served and are evicted as least recently used or expired.
+const (
+	cachePublishers   string = "publishers"
+	cachePublications string = "publications"
+)
+
+// responseCache is LRU cache of successful GET responses with TTL.
+// Concurrent requests of the same response are coalesced, so only one of them reaches repository.
+// nil responseCache disables caching.
+type responseCache struct {
+	mu   sync.Mutex
+	size int
+	ttl  time.Duration
+	// entries are ordered from most to least recently used
+	entries *list.List
+	keys    map[string]*list.Element
+	flights singleflight.Group
+}
+
+// cachedResponse is recorded response of handler
+type cachedResponse struct {
+	key     string
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// newResponseCache creates cache of size responses, or returns nil if size or ttl is not positive
+func newResponseCache(size int, ttl time.Duration) *responseCache {
+	if size <= 0 || ttl <= 0 {
+		return nil
+	}
+	return &responseCache{
+		size:    size,
+		ttl:     ttl,
+		entries: list.New(),
+		keys:    map[string]*list.Element{},
+	}
+}
+
+// get returns not expired response and marks it as recently used
+func (c *responseCache) get(key string) (*cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.keys[key]
+	if !ok {
+		return nil, false
+	}
+	response := element.Value.(*cachedResponse)
+	if time.Now().After(response.expires) {
+		c.remove(element, "expired")
+		return nil, false
+	}
+	c.entries.MoveToFront(element)
+	return response, true
+}
+
+// add stores response, evicting least recently used responses over the size
+func (c *responseCache) add(response *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.keys[response.key]; ok {
+		c.entries.Remove(element)
+	}
+	c.keys[response.key] = c.entries.PushFront(response)
+	for c.entries.Len() > c.size {
+		c.remove(c.entries.Back(), "size")
+	}
+}
+
+// remove deletes element, must be called under lock
+func (c *responseCache) remove(element *list.Element, reason string) {
+	c.entries.Remove(element)
+	delete(c.keys, element.Value.(*cachedResponse).key)
+	cacheEvictions.WithLabelValues(reason).Inc()
+}
+
+// handler is a middleware, which caches successful responses in group.
+// Responses are cached by request URL and collection state, set by conditionalCollection middleware before it,
+// so handlers must not return user specific responses. Requests without collection state are not cached.
+func (c *responseCache) handler(group string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if c == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			state, ok := r.Context().Value("collectionState").(entity.CollectionState)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// State is read before the response, so response is at least as new as the state
+			key := fmt.Sprint(state.Change, " ", r.Method, " ", r.URL.RequestURI())
+			if response, ok := c.get(key); ok {
+				cacheHits.WithLabelValues(group).Inc()
+				response.write(w)
+				return
+			}
+			cacheMisses.WithLabelValues(group).Inc()
+			value, _, err := c.flights.Do(r.Context(), key, func(_ context.Context) (interface{}, error) {
+				recorder := &responseRecorder{header: http.Header{}, status: http.StatusOK}
+				next.ServeHTTP(recorder, r)
+				response := &cachedResponse{
+					key:     key,
+					status:  recorder.status,
+					header:  recorder.header,
+					body:    recorder.body.Bytes(),
+					expires: time.Now().Add(c.ttl),
+				}
+				if response.status == http.StatusOK {
+					c.add(response)
+				}
+				return response, nil
+			})
+			// Request is cancelled while waiting for other request
+			if err != nil {
+				return
+			}
+			value.(*cachedResponse).write(w)
+		})
+	}
+}
+
+// write sends recorded response to client
+func (response *cachedResponse) write(w http.ResponseWriter) {
+	for k, v := range response.header {
+		w.Header()[k] = append([]string(nil), v...)
+	}
+	w.WriteHeader(response.status)
+	w.Write(response.body)
+}
+
+// responseRecorder records response of handler to be cached
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	return rec.body.Write(b)
+}
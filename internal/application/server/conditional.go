@@ -0,0 +1,99 @@
+package server
+
+// This file contains conditional requests helpers, based on ETag and Last-Modified validators
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+)
+
+// versionETag returns strong ETag of entity version
+func versionETag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// collectionETag returns strong ETag of entities listing, based on change counter of their table.
+// Unlike Last-Modified, it changes on every change, even within the same second.
+func collectionETag(state entity.CollectionState) string {
+	return fmt.Sprintf(`"c%d"`, state.Change)
+}
+
+// ifMatch returns true if request has no If-Match header or one of its entity tags strongly matches etag
+func ifMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		// weak tags never match in strong comparison
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifNoneMatch returns true if one of If-None-Match header entity tags weakly matches etag
+func ifNoneMatch(header string, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// setValidators sets ETag and Last-Modified headers of response, zero lastModified is skipped
+func setValidators(w http.ResponseWriter, etag string, lastModified time.Time) {
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// notModified sets validators of response and replies with 304 Not Modified if client already has them.
+// If-None-Match takes precedence over If-Modified-Since. Returns true if response is sent.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	setValidators(w, etag, lastModified)
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		if !ifNoneMatch(header, etag) {
+			return false
+		}
+	} else {
+		since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		// Last-Modified has seconds precision
+		if err != nil || lastModified.IsZero() || lastModified.Truncate(time.Second).After(since) {
+			return false
+		}
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// conditionalCollection is a middleware, which answers listings requests with 304 Not Modified
+// if entities collection is not changed since client got it. Collection state is passed in context to response cache.
+func (s *Server) conditionalCollection(getState func(context.Context) (entity.CollectionState, error)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			state, err := getState(r.Context())
+			if err != nil {
+				s.logger.Error(fmt.Sprint("Failure querying for collection state: ", err))
+				ErrInternal(errors.New("Failure querying database")).Render(w, r)
+				return
+			}
+			if notModified(w, r, collectionETag(state), state.LastModified) {
+				return
+			}
+			ctx := context.WithValue(r.Context(), "collectionState", state)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
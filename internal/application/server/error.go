@@ -1,13 +1,54 @@
 package server
 
-// This file contains common API errors responses
+// This file contains common API errors responses in RFC 7807 Problem Details format
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 
-	"github.com/go-chi/render"
+	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/Tarick/naca-publications/internal/spec"
+	"github.com/Tarick/naca-publications/pkg/problem"
+
+	"github.com/go-chi/chi/middleware"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/gofrs/uuid"
 )
 
+// ContentTypeProblemJSON is media type of error responses
+const ContentTypeProblemJSON = problem.ContentType
+
+// problemTypePrefix forms problem type URI from error code
+const problemTypePrefix = problem.TypePrefix
+
+// Error codes are stable machine-readable identifiers of errors, clients should rely on them instead of texts.
+// They're defined in public problem package, shared with clients.
+const (
+	CodeInvalidRequest           = problem.CodeInvalidRequest
+	CodeValidationFailed         = problem.CodeValidationFailed
+	CodeRequestTooLarge          = problem.CodeRequestTooLarge
+	CodeNotFound                 = problem.CodeNotFound
+	CodePublisherNotFound        = problem.CodePublisherNotFound
+	CodePublicationNotFound      = problem.CodePublicationNotFound
+	CodeAPIKeyNotFound           = problem.CodeAPIKeyNotFound
+	CodePublisherAlreadyExists   = problem.CodePublisherAlreadyExists
+	CodePublicationAlreadyExists = problem.CodePublicationAlreadyExists
+	CodePublisherDeleted         = problem.CodePublisherDeleted
+	CodePreconditionFailed       = problem.CodePreconditionFailed
+	CodeUnauthorized             = problem.CodeUnauthorized
+	CodeForbidden                = problem.CodeForbidden
+	CodeRateLimited              = problem.CodeRateLimited
+	CodeRenderFailed             = problem.CodeRenderFailed
+	CodeInternal                 = problem.CodeInternal
+	CodeUnavailable              = problem.CodeUnavailable
+	CodeTimeout                  = problem.CodeTimeout
+)
+
+// CodeValidationInvalid is code of invalid param, which validation error has no code of its own
+const CodeValidationInvalid = problem.CodeValidationInvalid
+
 // ErrResponse renderer type for handling all sorts of errors.
 // swagger:response ErrResponse
 type ErrResponse struct {
@@ -16,57 +57,144 @@ type ErrResponse struct {
 	Body ErrResponseBody
 }
 
-// ErrResponseBody is readable output to application/human about error
-type ErrResponseBody struct {
-	// user-level status message
-	StatusText string `json:"status"`
-	// application-level error message, for debugging
-	ErrorText string `json:"error,omitempty"`
+// ErrResponseBody is RFC 7807 problem details object, readable to application/human
+type ErrResponseBody = problem.Details
+
+// InvalidParam is failed validation of request field
+type InvalidParam = problem.InvalidParam
+
+// newErrResponse creates problem response
+func newErrResponse(status int, code string, title string, detail string) *ErrResponse {
+	return &ErrResponse{
+		HTTPStatusCode: status,
+		Body: ErrResponseBody{
+			Type:   problemTypePrefix + code,
+			Title:  title,
+			Status: status,
+			Detail: detail,
+			Code:   code,
+		},
+	}
 }
 
-// Render forms output for ErrResponse
+// Render forms output for ErrResponse with request path and ID.
+// Internal errors of requests with expired deadline or cancelled are reported as 504 and 503.
 func (e *ErrResponse) Render(w http.ResponseWriter, r *http.Request) {
-	render.Status(r, e.HTTPStatusCode)
-	render.JSON(w, r, e.Body)
+	if e.HTTPStatusCode == http.StatusInternalServerError {
+		switch r.Context().Err() {
+		case context.DeadlineExceeded:
+			e = ErrGatewayTimeout
+		case context.Canceled:
+			e = ErrServiceUnavailable
+		}
+	}
+	// Responses may be shared, so body is copied
+	body := e.Body
+	body.Instance = r.URL.Path
+	body.RequestID = middleware.GetReqID(r.Context())
+	w.Header().Set("Content-Type", ContentTypeProblemJSON)
+	w.WriteHeader(e.HTTPStatusCode)
+	json.NewEncoder(w).Encode(body)
 }
 
-// ErrInvalidRequest returns failure due to incorrect request parameters or methods
+// ErrInvalidRequest returns failure due to incorrect request parameters or methods.
+// Validation errors are returned as validation failure with invalid fields.
 func ErrInvalidRequest(err error) *ErrResponse {
-	return &ErrResponse{
-		HTTPStatusCode: 400,
-		Body: ErrResponseBody{
-			StatusText: "Invalid request.",
-			ErrorText:  err.Error(),
-		},
+	var validationErrors validation.Errors
+	if errors.As(err, &validationErrors) {
+		return ErrValidationFailed(validationErrors)
 	}
+	return newErrResponse(400, CodeInvalidRequest, "Invalid request.", err.Error())
+}
+
+// ErrValidationFailed returns failure of request fields validation
+func ErrValidationFailed(errs validation.Errors) *ErrResponse {
+	e := newErrResponse(400, CodeValidationFailed, "Validation failed.", errs.Error())
+	e.Body.InvalidParams = spec.InvalidParams("", errs)
+	return e
 }
 
 // ErrRender returns error for rendering
 func ErrRender(err error) *ErrResponse {
-	return &ErrResponse{
-		HTTPStatusCode: 422,
-		Body: ErrResponseBody{
-			StatusText: "Error rendering response.",
-			ErrorText:  err.Error(),
-		},
-	}
+	return newErrResponse(422, CodeRenderFailed, "Error rendering response.", err.Error())
 }
 
 // ErrInternal returns internal server error
 func ErrInternal(err error) *ErrResponse {
-	return &ErrResponse{
-		HTTPStatusCode: 500,
-		Body: ErrResponseBody{
-			StatusText: "Internal Server Error.",
-			ErrorText:  err.Error(),
-		},
-	}
+	return newErrResponse(500, CodeInternal, "Internal Server Error.", err.Error())
 }
 
 // ErrNotFound is 404
-var ErrNotFound = &ErrResponse{
-	HTTPStatusCode: 404,
-	Body: ErrResponseBody{
-		StatusText: "Resource not found.",
-	},
+var ErrNotFound = newErrResponse(404, CodeNotFound, "Resource not found.", "")
+
+// ErrPublisherNotFound is 404 of publisher
+var ErrPublisherNotFound = newErrResponse(404, CodePublisherNotFound, "Publisher not found.", "")
+
+// ErrPublicationNotFound is 404 of publication
+var ErrPublicationNotFound = newErrResponse(404, CodePublicationNotFound, "Publication not found.", "")
+
+// ErrAPIKeyNotFound is 404 of API key
+var ErrAPIKeyNotFound = newErrResponse(404, CodeAPIKeyNotFound, "API key not found.", "")
+
+// ErrPublisherAlreadyExists is 409, returned when other publisher with the same name or url exists.
+// UUID of the existing publisher is taken from entity.ConflictError.
+func ErrPublisherAlreadyExists(err error) *ErrResponse {
+	e := newErrResponse(409, CodePublisherAlreadyExists, "Publisher already exists.", "publisher with the same name or url exists")
+	e.Body.ExistingUUID = conflictUUID(err)
+	return e
+}
+
+// ErrPublicationAlreadyExists is 409, returned when other publication of the publisher with the same name exists.
+// UUID of the existing publication is taken from entity.ConflictError.
+func ErrPublicationAlreadyExists(err error) *ErrResponse {
+	e := newErrResponse(409, CodePublicationAlreadyExists, "Publication already exists.", "publication with the same name exists for the publisher")
+	e.Body.ExistingUUID = conflictUUID(err)
+	return e
+}
+
+func conflictUUID(err error) *uuid.UUID {
+	var conflict *entity.ConflictError
+	if errors.As(err, &conflict) && conflict.UUID != uuid.Nil {
+		return &conflict.UUID
+	}
+	return nil
+}
+
+// ErrPublisherInvalid returns failure due to publisher of publication, which doesn't exist or is deleted
+func ErrPublisherInvalid(code string, err error) *ErrResponse {
+	return newErrResponse(400, code, "Invalid publisher of publication.", err.Error())
 }
+
+// ErrPreconditionFailed is 412, returned when resource was changed since client has read it
+var ErrPreconditionFailed = newErrResponse(412, CodePreconditionFailed, "Precondition failed.",
+	"resource was modified, get its current version and retry")
+
+// ErrUnauthorized returns failure to authenticate client
+func ErrUnauthorized(err error) *ErrResponse {
+	return newErrResponse(401, CodeUnauthorized, "Unauthorized.", err.Error())
+}
+
+// ErrForbidden returns failure due to insufficient permissions of client
+func ErrForbidden(err error) *ErrResponse {
+	return newErrResponse(403, CodeForbidden, "Forbidden.", err.Error())
+}
+
+// ErrRequestBody returns failure to read request body: 413 if body is too large, 400 otherwise
+func ErrRequestBody(err error) *ErrResponse {
+	if errors.Is(err, errBodyTooLarge) {
+		return ErrRequestTooLarge
+	}
+	return ErrInvalidRequest(err)
+}
+
+// ErrRequestTooLarge is 413, returned when request body exceeds maximum size
+var ErrRequestTooLarge = newErrResponse(413, CodeRequestTooLarge, "Request entity too large.", errBodyTooLarge.Error())
+
+// ErrTooManyRequests is 429, returned when client exceeds rate limit. Retry-After header tells when to retry.
+var ErrTooManyRequests = newErrResponse(429, CodeRateLimited, "Too many requests.", "rate limit exceeded, retry later")
+
+// ErrGatewayTimeout is 504, returned when request isn't handled before its deadline
+var ErrGatewayTimeout = newErrResponse(504, CodeTimeout, "Gateway timeout.", "request handling deadline exceeded")
+
+// ErrServiceUnavailable is 503, returned when request handling is cancelled
+var ErrServiceUnavailable = newErrResponse(503, CodeUnavailable, "Service unavailable.", "request handling was cancelled")
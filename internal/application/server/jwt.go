@@ -0,0 +1,265 @@
+package server
+
+// This file contains verification of JWT bearer tokens against JSON Web Key Set.
+// Only asymmetric RSA and ECDSA signatures are supported, since API never issues tokens itself.
+// Each key verifies only tokens of its algorithm, so token header can't choose algorithm or hash of the key.
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// jwtAlgorithms maps supported JWT signature algorithms to their hash functions
+var jwtAlgorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+// jwk is JSON Web Key of RSA or EC public key
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA key
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC key
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwks is a set of public keys to verify tokens by key ID
+type jwks struct {
+	keys map[string]*jwtKey
+}
+
+// jwtKey is public key with the only signature algorithm it verifies
+type jwtKey struct {
+	alg string
+	key crypto.PublicKey
+}
+
+// jwtClaims are claims of verified token
+type jwtClaims map[string]interface{}
+
+// loadJWKS reads JSON Web Key Set file, keys not used for signatures are skipped.
+// Keys must have unique IDs. Algorithm of key without 'alg' is RS256 for RSA keys and is defined by curve for EC keys.
+func loadJWKS(path string) (*jwks, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(body, &set); err != nil {
+		return nil, fmt.Errorf("failure parsing JWKS: %w", err)
+	}
+	keySet := &jwks{keys: map[string]*jwtKey{}}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if k.Kid == "" {
+			return nil, errors.New("JWKS key has no kid")
+		}
+		if _, ok := keySet.keys[k.Kid]; ok {
+			return nil, fmt.Errorf("duplicate JWKS key %s", k.Kid)
+		}
+		key, err := k.signatureKey()
+		if err != nil {
+			return nil, fmt.Errorf("failure parsing JWKS key %s: %w", k.Kid, err)
+		}
+		keySet.keys[k.Kid] = key
+	}
+	if len(keySet.keys) == 0 {
+		return nil, errors.New("JWKS has no signature keys")
+	}
+	return keySet, nil
+}
+
+// ecCurves are supported curves of EC keys with their signature algorithms
+var ecCurves = map[string]struct {
+	curve elliptic.Curve
+	alg   string
+}{
+	"P-256": {elliptic.P256(), "ES256"},
+	"P-384": {elliptic.P384(), "ES384"},
+	"P-521": {elliptic.P521(), "ES512"},
+}
+
+// signatureKey returns public key with its algorithm, which must match key type and curve
+func (k *jwk) signatureKey() (*jwtKey, error) {
+	switch k.Kty {
+	case "RSA":
+		alg := k.Alg
+		if alg == "" {
+			alg = "RS256"
+		}
+		if _, ok := jwtAlgorithms[alg]; !ok || !strings.HasPrefix(alg, "RS") {
+			return nil, fmt.Errorf("unsupported algorithm %s of RSA key", alg)
+		}
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &jwtKey{alg: alg, key: &rsa.PublicKey{N: n, E: int(e.Int64())}}, nil
+	case "EC":
+		ec, ok := ecCurves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		if k.Alg != "" && k.Alg != ec.alg {
+			return nil, fmt.Errorf("algorithm %s doesn't match curve %s", k.Alg, k.Crv)
+		}
+		curve := ec.curve
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on curve")
+		}
+		return &jwtKey{alg: ec.alg, key: &ecdsa.PublicKey{Curve: curve, X: x, Y: y}}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// verify checks token signature, expiration and, if they're not empty, issuer and audience. Returns token claims.
+func (set *jwks) verify(token string, issuer string, audience string, now time.Time) (jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Kid == "" {
+		return nil, errors.New("token has no key ID")
+	}
+	key, ok := set.keys[header.Kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %s", header.Kid)
+	}
+	if header.Alg != key.alg {
+		return nil, fmt.Errorf("algorithm %s doesn't match key %s", header.Alg, header.Kid)
+	}
+	hash := jwtAlgorithms[key.alg]
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	if err := verifySignature(key.key, hash, h.Sum(nil), signature); err != nil {
+		return nil, err
+	}
+	claims := jwtClaims{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("token has no expiration")
+	}
+	if now.After(time.Unix(int64(exp), 0)) {
+		return nil, errors.New("token is expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("token is not valid yet")
+	}
+	if issuer != "" && claims["iss"] != issuer {
+		return nil, errors.New("token issuer mismatch")
+	}
+	if audience != "" && !claims.hasAudience(audience) {
+		return nil, errors.New("token audience mismatch")
+	}
+	return claims, nil
+}
+
+// verifySignature checks signature of digest, made with hash of key algorithm
+func verifySignature(key crypto.PublicKey, hash crypto.Hash, digest []byte, signature []byte) error {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, hash, digest, signature); err != nil {
+			return errors.New("invalid token signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid token signature")
+		}
+		r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("invalid token signature")
+		}
+		return nil
+	}
+	return errors.New("unsupported key")
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.New("malformed token")
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return errors.New("malformed token")
+	}
+	return nil
+}
+
+// hasAudience checks 'aud' claim, which is either string or array of strings
+func (claims jwtClaims) hasAudience(audience string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
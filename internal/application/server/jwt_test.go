@@ -0,0 +1,290 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testKeys are generated once, RSA key generation is slow
+var testKeys = struct {
+	rsa, otherRSA *rsa.PrivateKey
+	ec, ec384     *ecdsa.PrivateKey
+}{
+	rsa:      mustGenerateRSAKey(),
+	otherRSA: mustGenerateRSAKey(),
+	ec:       mustGenerateECKey(elliptic.P256()),
+	ec384:    mustGenerateECKey(elliptic.P384()),
+}
+
+func mustGenerateRSAKey() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func mustGenerateECKey(curve elliptic.Curve) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func rsaJWK(kid string, alg string, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{"kty": "RSA", "kid": kid, "alg": alg, "n": encodeBigInt(key.N), "e": encodeBigInt(big.NewInt(int64(key.E)))}
+}
+
+func ecJWK(kid string, alg string, crv string, key *ecdsa.PrivateKey) map[string]string {
+	return map[string]string{"kty": "EC", "kid": kid, "alg": alg, "crv": crv, "x": encodeBigInt(key.X), "y": encodeBigInt(key.Y)}
+}
+
+// writeJWKS writes JSON Web Key Set of keys into temporary file and returns its path
+func writeJWKS(t *testing.T, keys ...map[string]string) string {
+	t.Helper()
+	body, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := ioutil.WriteFile(path, body, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testJWKS has RSA key with default RS256 and with RS512 algorithms, P-256 key with default ES256 algorithm
+// and P-384 key with ES384 algorithm
+func testJWKS(t *testing.T) string {
+	return writeJWKS(t,
+		rsaJWK("rsa", "", testKeys.rsa),
+		rsaJWK("rsa512", "RS512", testKeys.rsa),
+		ecJWK("ec", "", "P-256", testKeys.ec),
+		ecJWK("ec384", "ES384", "P-384", testKeys.ec384),
+	)
+}
+
+func encodeJWTPart(t *testing.T, v interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// signJWT signs token of header and claims parts with hash of alg, regardless of key type
+func signJWT(t *testing.T, key crypto.PrivateKey, alg string, header string, claims string) string {
+	t.Helper()
+	hash, ok := jwtAlgorithms[alg]
+	if !ok {
+		hash = crypto.SHA256
+	}
+	h := hash.New()
+	h.Write([]byte(header + "." + claims))
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k, hash, h.Sum(nil)); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, h.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		signature = make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
+	}
+	return header + "." + claims + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// newJWT returns token of claims signed by key with alg and kid in header
+func newJWT(t *testing.T, key crypto.PrivateKey, alg string, kid string, claims jwtClaims) string {
+	t.Helper()
+	return signJWT(t, key, alg, encodeJWTPart(t, map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}), encodeJWTPart(t, claims))
+}
+
+func TestJWKSVerify(t *testing.T) {
+	now := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	const issuer, audience = "https://auth.example.com/", "publications-api"
+	claims := func(changes jwtClaims) jwtClaims {
+		c := jwtClaims{"sub": "client", "iss": issuer, "aud": audience, "exp": now.Add(time.Hour).Unix(), "role": "reader"}
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+		return c
+	}
+	valid := newJWT(t, testKeys.rsa, "RS256", "rsa", claims(nil))
+	parts := strings.Split(valid, ".")
+	validEC := newJWT(t, testKeys.ec, "ES256", "ec", claims(nil))
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{name: "RS256", token: valid},
+		{name: "RS512", token: newJWT(t, testKeys.rsa, "RS512", "rsa512", claims(nil))},
+		{name: "ES256", token: validEC},
+		{name: "ES384", token: newJWT(t, testKeys.ec384, "ES384", "ec384", claims(nil))},
+		{name: "audience in array", token: newJWT(t, testKeys.ec, "ES256", "ec", claims(jwtClaims{"aud": []string{"other", audience}}))},
+		{name: "not before in the past", token: newJWT(t, testKeys.ec, "ES256", "ec", claims(jwtClaims{"nbf": now.Add(-time.Minute).Unix()}))},
+
+		{name: "signature of other key", token: newJWT(t, testKeys.otherRSA, "RS256", "rsa", claims(nil)),
+			wantErr: "invalid token signature"},
+		{name: "changed claims", token: parts[0] + "." + encodeJWTPart(t, claims(jwtClaims{"role": "admin"})) + "." + parts[2],
+			wantErr: "invalid token signature"},
+		{name: "EC signature of other key", token: newJWT(t, mustGenerateECKey(elliptic.P256()), "ES256", "ec", claims(nil)),
+			wantErr: "invalid token signature"},
+		{name: "truncated EC signature", token: validEC[:len(validEC)-4],
+			wantErr: "invalid token signature"},
+
+		{name: "ES384 with P-256 key", token: newJWT(t, testKeys.ec, "ES384", "ec", claims(nil)),
+			wantErr: "algorithm ES384 doesn't match key ec"},
+		{name: "ES256 with P-384 key", token: newJWT(t, testKeys.ec384, "ES256", "ec384", claims(nil)),
+			wantErr: "algorithm ES256 doesn't match key ec384"},
+		{name: "RS512 with RS256 key", token: newJWT(t, testKeys.rsa, "RS512", "rsa", claims(nil)),
+			wantErr: "algorithm RS512 doesn't match key rsa"},
+		{name: "RS256 with EC key", token: newJWT(t, testKeys.ec, "RS256", "ec", claims(nil)),
+			wantErr: "algorithm RS256 doesn't match key ec"},
+		{name: "ES256 with RSA key", token: newJWT(t, testKeys.rsa, "ES256", "rsa", claims(nil)),
+			wantErr: "algorithm ES256 doesn't match key rsa"},
+		{name: "HS256 with RSA key", token: newJWT(t, testKeys.rsa, "HS256", "rsa", claims(nil)),
+			wantErr: "algorithm HS256 doesn't match key rsa"},
+		{name: "none algorithm", token: encodeJWTPart(t, map[string]string{"alg": "none", "kid": "rsa"}) + "." + parts[1] + ".",
+			wantErr: "algorithm none doesn't match key rsa"},
+
+		{name: "unknown kid", token: newJWT(t, testKeys.rsa, "RS256", "other", claims(nil)),
+			wantErr: "unknown key other"},
+		{name: "empty kid", token: newJWT(t, testKeys.rsa, "RS256", "", claims(nil)),
+			wantErr: "token has no key ID"},
+
+		{name: "missing expiration", token: newJWT(t, testKeys.rsa, "RS256", "rsa", claims(jwtClaims{"exp": nil})),
+			wantErr: "token has no expiration"},
+		{name: "expiration is not a number", token: newJWT(t, testKeys.rsa, "RS256", "rsa", claims(jwtClaims{"exp": "tomorrow"})),
+			wantErr: "token has no expiration"},
+		{name: "expired", token: newJWT(t, testKeys.rsa, "RS256", "rsa", claims(jwtClaims{"exp": now.Add(-time.Second).Unix()})),
+			wantErr: "token is expired"},
+		{name: "not before in the future", token: newJWT(t, testKeys.rsa, "RS256", "rsa", claims(jwtClaims{"nbf": now.Add(time.Minute).Unix()})),
+			wantErr: "token is not valid yet"},
+		{name: "issuer mismatch", token: newJWT(t, testKeys.rsa, "RS256", "rsa", claims(jwtClaims{"iss": "https://other.example.com/"})),
+			wantErr: "token issuer mismatch"},
+		{name: "missing issuer", token: newJWT(t, testKeys.rsa, "RS256", "rsa", claims(jwtClaims{"iss": nil})),
+			wantErr: "token issuer mismatch"},
+		{name: "audience mismatch", token: newJWT(t, testKeys.rsa, "RS256", "rsa", claims(jwtClaims{"aud": "other"})),
+			wantErr: "token audience mismatch"},
+		{name: "audience not in array", token: newJWT(t, testKeys.rsa, "RS256", "rsa", claims(jwtClaims{"aud": []string{"other"}})),
+			wantErr: "token audience mismatch"},
+
+		{name: "two segments", token: parts[0] + "." + parts[1],
+			wantErr: "malformed token"},
+		{name: "four segments", token: valid + "." + parts[2],
+			wantErr: "malformed token"},
+		{name: "empty", token: "",
+			wantErr: "malformed token"},
+		{name: "header is not base64url", token: "{}." + parts[1] + "." + parts[2],
+			wantErr: "malformed token"},
+		{name: "header is not JSON", token: base64.RawURLEncoding.EncodeToString([]byte("RS256")) + "." + parts[1] + "." + parts[2],
+			wantErr: "malformed token"},
+		{name: "signature is not base64url", token: parts[0] + "." + parts[1] + ".+/=",
+			wantErr: "malformed token signature"},
+		{name: "signed claims are not JSON", token: signJWT(t, testKeys.rsa, "RS256", parts[0], base64.RawURLEncoding.EncodeToString([]byte("claims"))),
+			wantErr: "malformed token"},
+	}
+	set, err := loadJWKS(testJWKS(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := set.verify(tt.token, issuer, audience, now)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("verify error = %v", err)
+				}
+				if got["sub"] != "client" {
+					t.Errorf("claims = %v, want subject client", got)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("verify error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWKSVerifyWithoutIssuerAndAudience(t *testing.T) {
+	set, err := loadJWKS(testJWKS(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	token := newJWT(t, testKeys.ec, "ES256", "ec", jwtClaims{"sub": "client", "exp": now.Add(time.Minute).Unix()})
+	if _, err := set.verify(token, "", "", now); err != nil {
+		t.Errorf("verify error = %v", err)
+	}
+}
+
+func TestLoadJWKS(t *testing.T) {
+	notOnCurve := ecJWK("ec", "", "P-256", testKeys.ec)
+	notOnCurve["y"] = encodeBigInt(new(big.Int).Add(testKeys.ec.Y, big.NewInt(1)))
+	encryption := rsaJWK("enc", "", testKeys.otherRSA)
+	encryption["use"] = "enc"
+	smallExponent := rsaJWK("rsa", "", testKeys.rsa)
+	smallExponent["e"] = encodeBigInt(big.NewInt(1))
+
+	tests := []struct {
+		name    string
+		keys    []map[string]string
+		wantErr bool
+	}{
+		{name: "keys with and without algorithms", keys: []map[string]string{
+			rsaJWK("rsa", "", testKeys.rsa), rsaJWK("rsa384", "RS384", testKeys.rsa), ecJWK("ec", "ES256", "P-256", testKeys.ec)}},
+		{name: "encryption keys are skipped", keys: []map[string]string{rsaJWK("rsa", "", testKeys.rsa), encryption}},
+		{name: "only encryption keys", keys: []map[string]string{encryption}, wantErr: true},
+		{name: "no keys", wantErr: true},
+		{name: "key without kid", keys: []map[string]string{rsaJWK("", "", testKeys.rsa)}, wantErr: true},
+		{name: "duplicate kid", keys: []map[string]string{rsaJWK("key", "", testKeys.rsa), ecJWK("key", "", "P-256", testKeys.ec)}, wantErr: true},
+		{name: "EC algorithm of RSA key", keys: []map[string]string{rsaJWK("rsa", "ES256", testKeys.rsa)}, wantErr: true},
+		{name: "HMAC algorithm of RSA key", keys: []map[string]string{rsaJWK("rsa", "HS256", testKeys.rsa)}, wantErr: true},
+		{name: "RSA algorithm of EC key", keys: []map[string]string{ecJWK("ec", "RS256", "P-256", testKeys.ec)}, wantErr: true},
+		{name: "algorithm of other curve", keys: []map[string]string{ecJWK("ec", "ES384", "P-256", testKeys.ec)}, wantErr: true},
+		{name: "unsupported curve", keys: []map[string]string{ecJWK("ec", "", "P-224", testKeys.ec)}, wantErr: true},
+		{name: "point not on curve", keys: []map[string]string{notOnCurve}, wantErr: true},
+		{name: "invalid RSA exponent", keys: []map[string]string{smallExponent}, wantErr: true},
+		{name: "unsupported key type", keys: []map[string]string{{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadJWKS(writeJWKS(t, tt.keys...))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("loadJWKS error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
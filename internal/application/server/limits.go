@@ -0,0 +1,311 @@
+package server
+
+// This file contains per-client rate limiting with token buckets, identification of client IP address behind trusted proxies
+// and limiting of request body size
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate limited route groups
+const (
+	// rateLimitRead limits GET requests of API
+	rateLimitRead string = "read"
+	// rateLimitWrite limits requests changing publishers and publications
+	rateLimitWrite string = "write"
+	// rateLimitSearch limits search requests, which are more expensive for database than reads
+	rateLimitSearch string = "search"
+	// rateLimitAdmin limits API keys management requests
+	rateLimitAdmin string = "admin"
+	// rateLimitAuth limits all API requests by IP address before authentication
+	rateLimitAuth string = "auth"
+)
+
+// defaultAuthRateLimit is used when auth group has no configured limit
+var defaultAuthRateLimit = RateLimitConfig{Rate: 20, Burst: 50}
+
+// defaultMaxBodySize is used when Config.MaxBodySize is not set, 1 MiB
+const defaultMaxBodySize int64 = 1 << 20
+
+// idleBucketsSweepInterval is how often buckets refilled to full are removed
+const idleBucketsSweepInterval = time.Minute
+
+// RateLimitConfig defines token bucket of each client: sustained rate of requests per second and burst of requests
+type RateLimitConfig struct {
+	Rate  float64 `mapstructure:"rate"`
+	Burst int     `mapstructure:"burst"`
+}
+
+// rateLimiter keeps token bucket for each client of route group.
+// nil rateLimiter doesn't limit requests.
+type rateLimiter struct {
+	mu        sync.Mutex
+	group     string
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimitResult is decision about request and state of client bucket for response headers
+type rateLimitResult struct {
+	allowed   bool
+	remaining int
+	// reset is time until bucket is full again
+	reset time.Duration
+	// retryAfter is time until next request is allowed, if it is not allowed now
+	retryAfter time.Duration
+}
+
+// newRateLimiters creates limiters of route groups, groups without configuration are not limited,
+// except for auth group, which has default limit
+func newRateLimiters(config map[string]RateLimitConfig) (map[string]*rateLimiter, error) {
+	limiters := map[string]*rateLimiter{}
+	if _, ok := config[rateLimitAuth]; !ok {
+		limiters[rateLimitAuth] = newRateLimiter(rateLimitAuth, defaultAuthRateLimit)
+	}
+	for group, limit := range config {
+		switch group {
+		case rateLimitRead, rateLimitWrite, rateLimitSearch, rateLimitAdmin, rateLimitAuth:
+		default:
+			return nil, fmt.Errorf("unknown rate limit group %s, must be one of: %s, %s, %s, %s, %s",
+				group, rateLimitRead, rateLimitWrite, rateLimitSearch, rateLimitAdmin, rateLimitAuth)
+		}
+		if limit.Rate <= 0 || limit.Burst <= 0 {
+			return nil, fmt.Errorf("rate limit of %s group must have positive rate and burst", group)
+		}
+		limiters[group] = newRateLimiter(group, limit)
+	}
+	return limiters, nil
+}
+
+func newRateLimiter(group string, limit RateLimitConfig) *rateLimiter {
+	return &rateLimiter{
+		group:     group,
+		rate:      limit.Rate,
+		burst:     float64(limit.Burst),
+		buckets:   map[string]*tokenBucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+// allow takes token from client bucket, if it has any
+func (l *rateLimiter) allow(client string, now time.Time) rateLimitResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > idleBucketsSweepInterval {
+		l.sweep(now)
+	}
+	bucket, ok := l.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[client] = bucket
+	}
+	bucket.refill(now, l.rate, l.burst)
+	result := rateLimitResult{}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.allowed = true
+	} else {
+		result.retryAfter = time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	}
+	result.remaining = int(bucket.tokens)
+	result.reset = time.Duration((l.burst - bucket.tokens) / l.rate * float64(time.Second))
+	return result
+}
+
+// sweep removes buckets which are full, they're the same as new ones
+func (l *rateLimiter) sweep(now time.Time) {
+	for client, bucket := range l.buckets {
+		if bucket.refill(now, l.rate, l.burst); bucket.tokens >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastSweep = now
+}
+
+func (b *tokenBucket) refill(now time.Time, rate float64, burst float64) {
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed*rate)
+		b.updated = now
+	}
+}
+
+// handler is a middleware, which rejects requests of clients exceeding rate limit with 429.
+// Clients are identified by API key or token subject, anonymous and not yet authenticated clients by IP address.
+func (l *rateLimiter) handler(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result := l.allow(rateLimitClient(r), time.Now())
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(int(l.burst)))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.reset)))
+		if !result.allowed {
+			rateLimited.WithLabelValues(l.group).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.retryAfter)))
+			ErrTooManyRequests.Render(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimit returns middleware limiting requests of route group, requests are not limited if group has no limit
+func (s *Server) rateLimit(group string) func(http.Handler) http.Handler {
+	return s.rateLimiters[group].handler
+}
+
+// rateLimitByMethod is a middleware, which limits reads and changes with limits of read and write groups
+func (s *Server) rateLimitByMethod(next http.Handler) http.Handler {
+	read, write := s.rateLimit(rateLimitRead)(next), s.rateLimit(rateLimitWrite)(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			read.ServeHTTP(w, r)
+		default:
+			write.ServeHTTP(w, r)
+		}
+	})
+}
+
+func rateLimitClient(r *http.Request) string {
+	if id, ok := r.Context().Value("identity").(*identity); ok && id.ClientID != "" {
+		return id.ClientID
+	}
+	if ip, ok := r.Context().Value("clientIP").(string); ok {
+		return "ip:" + ip
+	}
+	return "ip:" + remoteHost(r)
+}
+
+// parseTrustedProxies parses IP addresses and CIDR networks of trusted proxies
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address %s", proxy)
+			}
+			bits := 8 * len(ip)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy network %s: %w", proxy, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// clientIP is a middleware, which finds IP address of client and passes it in context.
+// If request comes from trusted proxy, X-Forwarded-For addresses are checked from the closest one,
+// and the first address, which is not a trusted proxy, is the client.
+func (s *Server) clientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := remoteHost(r)
+		if s.isTrustedProxy(ip) {
+			forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+			for i := len(forwarded) - 1; i >= 0; i-- {
+				address := strings.TrimSpace(forwarded[i])
+				if net.ParseIP(address) == nil {
+					break
+				}
+				ip = address
+				if !s.isTrustedProxy(address) {
+					break
+				}
+			}
+		}
+		ctx := context.WithValue(r.Context(), "clientIP", ip)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (s *Server) isTrustedProxy(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range s.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteHost returns IP address of request connection
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// errBodyTooLarge is returned by reading request body larger than maximum size
+var errBodyTooLarge = errors.New("request body is too large")
+
+// maxBodySize is a middleware, which limits size of request bodies.
+// Requests with larger Content-Length are rejected with 413 immediately, others fail on reading their body.
+func maxBodySize(size int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > size {
+				ErrRequestTooLarge.Render(w, r)
+				return
+			}
+			r.Body = &limitedBody{ReadCloser: r.Body, remaining: size}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// limitedBody fails with errBodyTooLarge, when more than remaining bytes are read
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, errBodyTooLarge
+	}
+	// Read one byte more than allowed to find out if body exceeds it
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n + int(b.remaining), errBodyTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics are registered in default registry, exposed on /metrics
+var (
+	cacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "publications",
+		Subsystem: "api_cache",
+		Name:      "hits_total",
+		Help:      "Number of responses served from cache.",
+	}, []string{"group"})
+	cacheMisses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "publications",
+		Subsystem: "api_cache",
+		Name:      "misses_total",
+		Help:      "Number of responses not found in cache.",
+	}, []string{"group"})
+	cacheEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "publications",
+		Subsystem: "api_cache",
+		Name:      "evictions_total",
+		Help:      "Number of responses removed from cache, by reason: size or expired.",
+	}, []string{"reason"})
+	rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "publications",
+		Subsystem: "api",
+		Name:      "rate_limited_requests_total",
+		Help:      "Number of requests rejected due to rate limit, by route group.",
+	}, []string{"group"})
+)
@@ -0,0 +1,110 @@
+package server
+
+// This file contains keyset (cursor) pagination and common filtering helpers for list endpoints
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/gofrs/uuid"
+)
+
+const (
+	defaultPageLimit int = 100
+	maxPageLimit     int = 1000
+)
+
+// pageCursor is opaque to API clients, they get it base64 encoded in Link header
+type pageCursor struct {
+	After uuid.UUID `json:"after"`
+	// Sort parameter of the listing and sort field Value of the last entity, empty when sorted by UUID
+	Sort  string `json:"sort,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+func encodeCursor(c pageCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	c := pageCursor{}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor parameter %s", s)
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("invalid cursor parameter %s", s)
+	}
+	return c, nil
+}
+
+// pageFromRequest reads 'limit' and 'cursor' query parameters. Cursor must be issued for the same sort parameter.
+func pageFromRequest(r *http.Request, sort string) (entity.Page, error) {
+	page := entity.Page{Limit: defaultPageLimit}
+	query := r.URL.Query()
+	if limitParam := query.Get("limit"); limitParam != "" {
+		limit, err := strconv.Atoi(limitParam)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return page, fmt.Errorf("invalid limit parameter %s, must be between 1 and %d", limitParam, maxPageLimit)
+		}
+		page.Limit = limit
+	}
+	if cursorParam := query.Get("cursor"); cursorParam != "" {
+		cursor, err := decodeCursor(cursorParam)
+		if err != nil {
+			return page, err
+		}
+		if cursor.Sort != sort {
+			return page, fmt.Errorf("cursor parameter doesn't match sort parameter %s", sort)
+		}
+		page.After = cursor.After
+		// Sort parameter is a field name with optional "-" prefix of descending order
+		if strings.TrimPrefix(cursor.Sort, "-") != "" {
+			page.AfterValue = cursor.Value
+		}
+	}
+	return page, nil
+}
+
+// setNextPageLink sets Link header with rel="next", keeping the rest of request query parameters
+func setNextPageLink(w http.ResponseWriter, r *http.Request, limit int, cursor pageCursor) {
+	query := r.URL.Query()
+	query.Set("limit", strconv.Itoa(limit))
+	query.Set("cursor", encodeCursor(cursor))
+	next := *r.URL
+	next.RawQuery = query.Encode()
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.RequestURI()))
+}
+
+// updatedSinceFromRequest reads RFC3339 'updated_since' query parameter, returns zero time if it is absent
+func updatedSinceFromRequest(r *http.Request) (time.Time, error) {
+	updatedSinceParam := r.URL.Query().Get("updated_since")
+	if updatedSinceParam == "" {
+		return time.Time{}, nil
+	}
+	updatedSince, err := time.Parse(time.RFC3339, updatedSinceParam)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid updated_since parameter %s, must be RFC3339 time", updatedSinceParam)
+	}
+	return updatedSince, nil
+}
+
+// includeDeletedFromRequest reads boolean 'include_deleted' query parameter, false if it is absent
+func includeDeletedFromRequest(r *http.Request) (bool, error) {
+	includeDeletedParam := r.URL.Query().Get("include_deleted")
+	if includeDeletedParam == "" {
+		return false, nil
+	}
+	includeDeleted, err := strconv.ParseBool(includeDeletedParam)
+	if err != nil {
+		return false, fmt.Errorf("invalid include_deleted parameter %s, must be true or false", includeDeletedParam)
+	}
+	return includeDeleted, nil
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/gofrs/uuid"
+	"go.uber.org/zap"
+)
+
+// fakePagesRepository returns the same publications for every page and records queries of pages
+type fakePagesRepository struct {
+	PublicationsRepository
+	publications []*entity.Publication
+	queries      []entity.PublicationsQuery
+}
+
+func (repo *fakePagesRepository) GetPublicationsPage(_ context.Context, query entity.PublicationsQuery) ([]*entity.Publication, error) {
+	repo.queries = append(repo.queries, query)
+	if len(repo.publications) > query.Limit {
+		return repo.publications[:query.Limit], nil
+	}
+	return repo.publications, nil
+}
+
+func (repo *fakePagesRepository) GetPublicationsState(context.Context) (entity.CollectionState, error) {
+	return entity.CollectionState{}, nil
+}
+
+func TestPublicationsPaginationRoundTrip(t *testing.T) {
+	created := time.Date(2021, 3, 4, 5, 6, 7, 890123000, time.UTC)
+	first := &entity.Publication{UUID: uuid.Must(uuid.NewV4()), Name: "First", CreatedAt: created, ModifiedAt: created.Add(time.Hour)}
+	second := &entity.Publication{UUID: uuid.Must(uuid.NewV4()), Name: "Second", CreatedAt: created, ModifiedAt: created}
+
+	tests := []struct {
+		sort           string
+		wantAfterValue interface{}
+		wantDescending bool
+	}{
+		{sort: "", wantAfterValue: nil},
+		{sort: "name", wantAfterValue: first.Name},
+		{sort: "-name", wantAfterValue: first.Name, wantDescending: true},
+		{sort: "created_at", wantAfterValue: first.CreatedAt},
+		{sort: "-created_at", wantAfterValue: first.CreatedAt, wantDescending: true},
+		{sort: "modified_at", wantAfterValue: first.ModifiedAt},
+		{sort: "-modified_at", wantAfterValue: first.ModifiedAt, wantDescending: true},
+	}
+	for _, tt := range tests {
+		t.Run("sort="+tt.sort, func(t *testing.T) {
+			repo := &fakePagesRepository{publications: []*entity.Publication{first, second}}
+			s, err := New(Config{}, zap.NewNop().Sugar(), repo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := httptest.NewRecorder()
+			s.httpServer.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/publications?limit=1&sort="+tt.sort, nil))
+			if res.Code != http.StatusOK {
+				t.Fatalf("first page status = %d, body: %s", res.Code, res.Body.String())
+			}
+			link := res.Header().Get("Link")
+			if link == "" {
+				t.Fatal("first page has no Link to the next page")
+			}
+			next := link[1 : len(link)-len(`>; rel="next"`)]
+
+			res = httptest.NewRecorder()
+			s.httpServer.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, next, nil))
+			if res.Code != http.StatusOK {
+				t.Fatalf("next page %s status = %d, body: %s", next, res.Code, res.Body.String())
+			}
+			query := repo.queries[1]
+			if query.After != first.UUID {
+				t.Errorf("next page After = %v, want %v", query.After, first.UUID)
+			}
+			if query.Descending != tt.wantDescending {
+				t.Errorf("next page Descending = %v, want %v", query.Descending, tt.wantDescending)
+			}
+			if afterTime, ok := query.AfterValue.(time.Time); ok {
+				if wantTime, _ := tt.wantAfterValue.(time.Time); !afterTime.Equal(wantTime) {
+					t.Errorf("next page AfterValue = %v, want %v", afterTime, wantTime)
+				}
+			} else if query.AfterValue != tt.wantAfterValue {
+				t.Errorf("next page AfterValue = %#v, want %#v", query.AfterValue, tt.wantAfterValue)
+			}
+		})
+	}
+}
+
+func TestPublicationsQueryFromRequestRejectsInvalidSort(t *testing.T) {
+	for _, sort := range []string{"-", "--name", "uuid", "description"} {
+		r := httptest.NewRequest(http.MethodGet, "/publications?sort="+sort, nil)
+		if _, err := publicationsQueryFromRequest(r); err == nil {
+			t.Errorf("sort=%s is accepted", sort)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+// This file contains registry of sync of publication types with their backing services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/Tarick/naca-publications/internal/spec"
+
+	"github.com/go-chi/render"
+)
+
+// PublicationHook returns outbox events to sync publication change to backing service of publication type
+type PublicationHook func(*entity.Publication, spec.PublicationConfig) ([]*entity.OutboxEvent, error)
+
+// PublicationTypeSync defines sync of publication type, registered in spec, with its backing service
+type PublicationTypeSync struct {
+	Name string
+	// Hooks are called on publication changes, nil hooks are skipped.
+	// Hooks of types without NewDelivery are skipped too, since their events couldn't be delivered.
+	OnCreate PublicationHook
+	OnUpdate PublicationHook
+	OnDelete PublicationHook
+	// NewDelivery creates delivery of outbox events of the type to its backing service at URL.
+	// Events of types with delivery, but without configured URL, are kept in outbox until it is configured.
+	NewDelivery func(serviceURL string) (PublicationDelivery, error)
+}
+
+// PublicationDelivery delivers outbox event of publication type to its backing service
+type PublicationDelivery func(context.Context, *entity.OutboxEvent) error
+
+// publicationTypeSyncs is registry of sync of publication types, types without sync aren't synced with backing services
+var publicationTypeSyncs = struct {
+	sync.RWMutex
+	syncs map[string]*PublicationTypeSync
+}{syncs: map[string]*PublicationTypeSync{}}
+
+// RegisterPublicationTypeSync adds sync of publication type to registry. Publication type must be registered in spec.
+func RegisterPublicationTypeSync(t *PublicationTypeSync) {
+	if _, ok := spec.GetPublicationType(t.Name); !ok {
+		panic(fmt.Sprintf("publication type %s of sync is not registered", t.Name))
+	}
+	publicationTypeSyncs.Lock()
+	defer publicationTypeSyncs.Unlock()
+	if _, ok := publicationTypeSyncs.syncs[t.Name]; ok {
+		panic(fmt.Sprintf("sync of publication type %s is already registered", t.Name))
+	}
+	publicationTypeSyncs.syncs[t.Name] = t
+}
+
+func getPublicationTypeSync(name string) (*PublicationTypeSync, bool) {
+	publicationTypeSyncs.RLock()
+	defer publicationTypeSyncs.RUnlock()
+	t, ok := publicationTypeSyncs.syncs[name]
+	return t, ok
+}
+
+// NewPublicationDeliveries creates deliveries of publication types by their names with URLs of their backing services.
+// Types without URL are skipped, URL of type without delivery is an error.
+func NewPublicationDeliveries(serviceURLs map[string]string) (map[string]PublicationDelivery, error) {
+	deliveries := map[string]PublicationDelivery{}
+	for name, serviceURL := range serviceURLs {
+		if _, ok := spec.GetPublicationType(name); !ok {
+			return nil, fmt.Errorf("unknown publication type %s of backing service %s", name, serviceURL)
+		}
+		if serviceURL == "" {
+			continue
+		}
+		t, ok := getPublicationTypeSync(name)
+		if !ok || t.NewDelivery == nil {
+			return nil, fmt.Errorf("publication type %s has no delivery to backing service %s", name, serviceURL)
+		}
+		delivery, err := t.NewDelivery(serviceURL)
+		if err != nil {
+			return nil, fmt.Errorf("failure creating delivery of publication type %s: %w", name, err)
+		}
+		deliveries[name] = delivery
+	}
+	return deliveries, nil
+}
+
+// publicationChange is the change of publication to be synced with backing service of publication type
+type publicationChange int
+
+const (
+	publicationCreate publicationChange = iota
+	publicationUpdate
+	publicationDelete
+)
+
+// publicationsOutboxEvents returns events of the change of publications, which are changed together with their publisher
+func publicationsOutboxEvents(change publicationChange) entity.PublicationsOutboxEvents {
+	return func(publications []*entity.Publication) ([]*entity.OutboxEvent, error) {
+		events := []*entity.OutboxEvent{}
+		for _, publication := range publications {
+			publicationEvents, err := publicationOutboxEvents(change, publication)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, publicationEvents...)
+		}
+		return events, nil
+	}
+}
+
+// publicationOutboxEvents calls publication type hook of the change with stored publication config.
+// Events are stored together with the change and delivered by dispatcher.
+func publicationOutboxEvents(change publicationChange, publication *entity.Publication) ([]*entity.OutboxEvent, error) {
+	publicationType, ok := spec.GetPublicationType(publication.Type)
+	if !ok {
+		return nil, fmt.Errorf("unknow publication type: %s", publication.Type)
+	}
+	t, ok := getPublicationTypeSync(publication.Type)
+	if !ok || t.NewDelivery == nil {
+		return nil, nil
+	}
+	hook := map[publicationChange]PublicationHook{
+		publicationCreate: t.OnCreate,
+		publicationUpdate: t.OnUpdate,
+		publicationDelete: t.OnDelete,
+	}[change]
+	if hook == nil {
+		return nil, nil
+	}
+	config, err := publicationType.Decode(publication.Config)
+	if err != nil {
+		return nil, err
+	}
+	return hook(publication, config)
+}
+
+// PublicationTypeResponseBody describes registered publication type
+// swagger:model
+type PublicationTypeResponseBody struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// JSON Schema of publication config
+	ConfigSchema json.RawMessage `json:"config_schema,omitempty"`
+}
+
+// getPublicationTypes returns registered publication types
+func (s *Server) getPublicationTypes(w http.ResponseWriter, r *http.Request) {
+	names := spec.PublicationTypeNames()
+	response := make([]*PublicationTypeResponseBody, 0, len(names))
+	for _, name := range names {
+		t, _ := spec.GetPublicationType(name)
+		response = append(response, &PublicationTypeResponseBody{Name: t.Name, Description: t.Description, ConfigSchema: t.ConfigSchema})
+	}
+	render.JSON(w, r, response)
+}
@@ -0,0 +1,246 @@
+package server
+
+// This file contains sync of built-in publication types with backing services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Tarick/naca-publications/internal/backingservice"
+	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/Tarick/naca-publications/internal/spec"
+
+	rssAPIClient "github.com/Tarick/naca-rss-feeds/pkg/apiclient"
+	"github.com/gofrs/uuid"
+)
+
+func init() {
+	RegisterPublicationTypeSync(&PublicationTypeSync{
+		Name:        spec.PublicationTypeRSS,
+		OnCreate:    rssFeedHook(entity.OutboxChangeCreated),
+		OnUpdate:    rssFeedHook(entity.OutboxChangeUpdated),
+		OnDelete:    deleteHook(),
+		NewDelivery: newRSSFeedDelivery,
+	})
+	RegisterPublicationTypeSync(&PublicationTypeSync{
+		Name:        spec.PublicationTypeScrapped,
+		OnCreate:    scrapperHook(entity.OutboxChangeCreated),
+		OnUpdate:    scrapperHook(entity.OutboxChangeUpdated),
+		OnDelete:    deleteHook(),
+		NewDelivery: newScrapperDelivery,
+	})
+	RegisterPublicationTypeSync(&PublicationTypeSync{
+		Name:        spec.PublicationTypeAPI,
+		OnCreate:    apiFeedHook(entity.OutboxChangeCreated),
+		OnUpdate:    apiFeedHook(entity.OutboxChangeUpdated),
+		OnDelete:    deleteHook(),
+		NewDelivery: newAPIFeedDelivery,
+	})
+}
+
+// singleEvent is a helper for hooks, which create one event of publication change, routed to delivery of publication type
+func singleEvent(change string, publication *entity.Publication, payload interface{}) ([]*entity.OutboxEvent, error) {
+	event, err := entity.NewOutboxEvent(entity.PublicationOutboxEventType(publication.Type, change), publication.UUID, payload)
+	if err != nil {
+		return nil, err
+	}
+	return []*entity.OutboxEvent{event}, nil
+}
+
+// deleteHook creates event with empty payload, only publication UUID is needed to delete it from backing service
+func deleteHook() PublicationHook {
+	return func(publication *entity.Publication, _ spec.PublicationConfig) ([]*entity.OutboxEvent, error) {
+		return singleEvent(entity.OutboxChangeDeleted, publication, struct{}{})
+	}
+}
+
+func rssFeedHook(change string) PublicationHook {
+	return func(publication *entity.Publication, c spec.PublicationConfig) ([]*entity.OutboxEvent, error) {
+		config := c.(*spec.RSSPublicationConfig)
+		return singleEvent(change, publication, entity.RSSFeedPayload{URL: config.URL, LanguageCode: publication.LanguageCode})
+	}
+}
+
+func scrapperHook(change string) PublicationHook {
+	return func(publication *entity.Publication, c spec.PublicationConfig) ([]*entity.OutboxEvent, error) {
+		config := c.(*spec.ScrappedPublicationConfig)
+		return singleEvent(change, publication, entity.ScrapperPayload{
+			StartURL:      config.StartURL,
+			ItemSelector:  config.ItemSelector,
+			TitleSelector: config.TitleSelector,
+			LinkSelector:  config.LinkSelector,
+			Schedule:      config.Schedule,
+			LanguageCode:  publication.LanguageCode,
+		})
+	}
+}
+
+func apiFeedHook(change string) PublicationHook {
+	return func(publication *entity.Publication, c spec.PublicationConfig) ([]*entity.OutboxEvent, error) {
+		config := c.(*spec.APIPublicationConfig)
+		languageCode := config.LanguageCode
+		if languageCode == "" {
+			languageCode = publication.LanguageCode
+		}
+		return singleEvent(change, publication, entity.APIFeedPayload{URL: config.URL, APIKeyRef: config.APIKeyRef, LanguageCode: languageCode})
+	}
+}
+
+// RSSFeedsAPIClient is used to deliver changes of rss publications to RSS Feeds service
+type RSSFeedsAPIClient interface {
+	CreateRSSFeed(context.Context, uuid.UUID, string, string) error
+	UpdateRSSFeed(context.Context, uuid.UUID, string, string) error
+	DeleteRSSFeed(context.Context, uuid.UUID) error
+}
+
+// ScrapperAPIClient is used to deliver changes of scrapped publications to web scrapping service.
+// Creation of existing scrapper fails with entity.ErrAlreadyExists, change of missing one with entity.ErrNotFound.
+type ScrapperAPIClient interface {
+	CreateScrapper(context.Context, uuid.UUID, entity.ScrapperPayload) error
+	UpdateScrapper(context.Context, uuid.UUID, entity.ScrapperPayload) error
+	DeleteScrapper(context.Context, uuid.UUID) error
+}
+
+// APIFeedsAPIClient is used to deliver changes of api publications to API feeds service.
+// Creation of existing API feed fails with entity.ErrAlreadyExists, change of missing one with entity.ErrNotFound.
+type APIFeedsAPIClient interface {
+	CreateAPIFeed(context.Context, uuid.UUID, entity.APIFeedPayload) error
+	UpdateAPIFeed(context.Context, uuid.UUID, entity.APIFeedPayload) error
+	DeleteAPIFeed(context.Context, uuid.UUID) error
+}
+
+// RSSFeedExists returns true if feed of publication exists in RSS Feeds service
+type RSSFeedExists func(context.Context, uuid.UUID) (bool, error)
+
+// newRSSFeedDelivery creates delivery to RSS Feeds service at serviceURL
+func newRSSFeedDelivery(serviceURL string) (PublicationDelivery, error) {
+	client, err := rssAPIClient.New(serviceURL)
+	if err != nil {
+		return nil, err
+	}
+	// RSS Feeds service client doesn't tell missing feeds from other errors, they are looked up in all feeds
+	feedExists := func(ctx context.Context, publicationUUID uuid.UUID) (bool, error) {
+		feeds, err := client.GetAllRSSFeeds(ctx)
+		if err != nil {
+			return false, err
+		}
+		for _, feed := range feeds {
+			if feed.PublicationUUID == publicationUUID {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return RSSFeedDelivery(client, feedExists), nil
+}
+
+// RSSFeedDelivery delivers outbox events of rss publications with RSS Feeds service client.
+// Events are delivered at least once, so failed creation of existing feed and deletion of missing one are repeated
+// deliveries of successful calls. Existing feed is updated to the event state then, missing feed is not deleted.
+func RSSFeedDelivery(client RSSFeedsAPIClient, feedExists RSSFeedExists) PublicationDelivery {
+	return func(ctx context.Context, event *entity.OutboxEvent) error {
+		_, change := event.PublicationChange()
+		if change == entity.OutboxChangeDeleted {
+			err := client.DeleteRSSFeed(ctx, event.AggregateUUID)
+			if err != nil {
+				if exists, existsErr := feedExists(ctx, event.AggregateUUID); existsErr == nil && !exists {
+					return nil
+				}
+			}
+			return err
+		}
+		payload := entity.RSSFeedPayload{}
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return err
+		}
+		switch change {
+		case entity.OutboxChangeCreated:
+			err := client.CreateRSSFeed(ctx, event.AggregateUUID, payload.URL, payload.LanguageCode)
+			if err != nil {
+				if exists, existsErr := feedExists(ctx, event.AggregateUUID); existsErr == nil && exists {
+					return client.UpdateRSSFeed(ctx, event.AggregateUUID, payload.URL, payload.LanguageCode)
+				}
+			}
+			return err
+		case entity.OutboxChangeUpdated:
+			return client.UpdateRSSFeed(ctx, event.AggregateUUID, payload.URL, payload.LanguageCode)
+		}
+		return fmt.Errorf("unknown change of outbox event type %s", event.Type)
+	}
+}
+
+// newScrapperDelivery creates delivery to web scrapping service scrappers at serviceURL
+func newScrapperDelivery(serviceURL string) (PublicationDelivery, error) {
+	client, err := backingservice.NewScrapperClient(serviceURL)
+	if err != nil {
+		return nil, err
+	}
+	return ScrapperDelivery(client), nil
+}
+
+// ScrapperDelivery delivers outbox events of scrapped publications with web scrapping service client.
+// As with RSS feeds, existing scrapper is updated on repeated creation and missing one is not deleted.
+func ScrapperDelivery(client ScrapperAPIClient) PublicationDelivery {
+	return func(ctx context.Context, event *entity.OutboxEvent) error {
+		_, change := event.PublicationChange()
+		if change == entity.OutboxChangeDeleted {
+			if err := client.DeleteScrapper(ctx, event.AggregateUUID); !errors.Is(err, entity.ErrNotFound) {
+				return err
+			}
+			return nil
+		}
+		payload := entity.ScrapperPayload{}
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return err
+		}
+		switch change {
+		case entity.OutboxChangeCreated:
+			if err := client.CreateScrapper(ctx, event.AggregateUUID, payload); !errors.Is(err, entity.ErrAlreadyExists) {
+				return err
+			}
+			return client.UpdateScrapper(ctx, event.AggregateUUID, payload)
+		case entity.OutboxChangeUpdated:
+			return client.UpdateScrapper(ctx, event.AggregateUUID, payload)
+		}
+		return fmt.Errorf("unknown change of outbox event type %s", event.Type)
+	}
+}
+
+// newAPIFeedDelivery creates delivery to API feeds service feeds at serviceURL
+func newAPIFeedDelivery(serviceURL string) (PublicationDelivery, error) {
+	client, err := backingservice.NewAPIFeedsClient(serviceURL)
+	if err != nil {
+		return nil, err
+	}
+	return APIFeedDelivery(client), nil
+}
+
+// APIFeedDelivery delivers outbox events of api publications with API feeds service client.
+// As with RSS feeds, existing API feed is updated on repeated creation and missing one is not deleted.
+func APIFeedDelivery(client APIFeedsAPIClient) PublicationDelivery {
+	return func(ctx context.Context, event *entity.OutboxEvent) error {
+		_, change := event.PublicationChange()
+		if change == entity.OutboxChangeDeleted {
+			if err := client.DeleteAPIFeed(ctx, event.AggregateUUID); !errors.Is(err, entity.ErrNotFound) {
+				return err
+			}
+			return nil
+		}
+		payload := entity.APIFeedPayload{}
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return err
+		}
+		switch change {
+		case entity.OutboxChangeCreated:
+			if err := client.CreateAPIFeed(ctx, event.AggregateUUID, payload); !errors.Is(err, entity.ErrAlreadyExists) {
+				return err
+			}
+			return client.UpdateAPIFeed(ctx, event.AggregateUUID, payload)
+		case entity.OutboxChangeUpdated:
+			return client.UpdateAPIFeed(ctx, event.AggregateUUID, payload)
+		}
+		return fmt.Errorf("unknown change of outbox event type %s", event.Type)
+	}
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/Tarick/naca-publications/internal/spec"
+	"github.com/gofrs/uuid"
+)
+
+func TestRSSFeedDeliveryOfRepeatedEvents(t *testing.T) {
+	feedUUID := uuid.Must(uuid.NewV4())
+	payload := entity.RSSFeedPayload{URL: "https://example.com/feed.xml", LanguageCode: "en"}
+	errFailed := errors.New("failed")
+
+	tests := []struct {
+		name      string
+		change    string
+		errs      map[string]error
+		exists    bool
+		existsErr error
+		wantErr   error
+		wantCalls []string
+	}{
+		{name: "create", change: entity.OutboxChangeCreated,
+			wantCalls: []string{"CreateRSSFeed"}},
+		{name: "create of existing feed updates it", change: entity.OutboxChangeCreated, errs: map[string]error{"CreateRSSFeed": errFailed}, exists: true,
+			wantCalls: []string{"CreateRSSFeed", "UpdateRSSFeed"}},
+		{name: "create of existing feed fails to update it", change: entity.OutboxChangeCreated,
+			errs: map[string]error{"CreateRSSFeed": errFailed, "UpdateRSSFeed": errors.New("update failed")}, exists: true,
+			wantErr: errors.New("update failed"), wantCalls: []string{"CreateRSSFeed", "UpdateRSSFeed"}},
+		{name: "create failure", change: entity.OutboxChangeCreated, errs: map[string]error{"CreateRSSFeed": errFailed},
+			wantErr: errFailed, wantCalls: []string{"CreateRSSFeed"}},
+		{name: "create failure of unknown feed", change: entity.OutboxChangeCreated, errs: map[string]error{"CreateRSSFeed": errFailed}, existsErr: errors.New("unavailable"),
+			wantErr: errFailed, wantCalls: []string{"CreateRSSFeed"}},
+		{name: "update", change: entity.OutboxChangeUpdated,
+			wantCalls: []string{"UpdateRSSFeed"}},
+		{name: "update failure", change: entity.OutboxChangeUpdated, errs: map[string]error{"UpdateRSSFeed": errFailed},
+			wantErr: errFailed, wantCalls: []string{"UpdateRSSFeed"}},
+		{name: "delete", change: entity.OutboxChangeDeleted,
+			wantCalls: []string{"DeleteRSSFeed"}},
+		{name: "delete of missing feed", change: entity.OutboxChangeDeleted, errs: map[string]error{"DeleteRSSFeed": errFailed},
+			wantCalls: []string{"DeleteRSSFeed"}},
+		{name: "delete failure", change: entity.OutboxChangeDeleted, errs: map[string]error{"DeleteRSSFeed": errFailed}, exists: true,
+			wantErr: errFailed, wantCalls: []string{"DeleteRSSFeed"}},
+		{name: "delete failure of unknown feed", change: entity.OutboxChangeDeleted, errs: map[string]error{"DeleteRSSFeed": errFailed}, existsErr: errors.New("unavailable"),
+			wantErr: errFailed, wantCalls: []string{"DeleteRSSFeed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeRSSFeedsAPIClient{errs: tt.errs, exists: tt.exists, existsErr: tt.existsErr}
+			event, err := entity.NewOutboxEvent(entity.PublicationOutboxEventType(spec.PublicationTypeRSS, tt.change), feedUUID, payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = RSSFeedDelivery(client, client.RSSFeedExists)(context.Background(), event)
+			if (err == nil) != (tt.wantErr == nil) || (err != nil && err.Error() != tt.wantErr.Error()) {
+				t.Errorf("delivery error = %v, want %v", err, tt.wantErr)
+			}
+			if len(client.calls) != len(tt.wantCalls) {
+				t.Fatalf("RSS Feeds client calls = %+v, want %v", client.calls, tt.wantCalls)
+			}
+			for i, call := range client.calls {
+				if call.method != tt.wantCalls[i] || call.uuid != feedUUID {
+					t.Errorf("RSS Feeds client call = %+v, want %s of %v", call, tt.wantCalls[i], feedUUID)
+				}
+				if call.method != "DeleteRSSFeed" && (call.url != payload.URL || call.languageCode != payload.LanguageCode) {
+					t.Errorf("RSS Feeds client call = %+v, want payload %+v", call, payload)
+				}
+			}
+		})
+	}
+}
+
+// fakeFeedsClient implements ScrapperAPIClient and APIFeedsAPIClient, recording names of called methods.
+// Methods return errors of their names.
+type fakeFeedsClient struct {
+	calls []string
+	errs  map[string]error
+}
+
+func (c *fakeFeedsClient) call(method string) error {
+	c.calls = append(c.calls, method)
+	return c.errs[method]
+}
+
+func (c *fakeFeedsClient) CreateScrapper(context.Context, uuid.UUID, entity.ScrapperPayload) error {
+	return c.call("create")
+}
+
+func (c *fakeFeedsClient) UpdateScrapper(context.Context, uuid.UUID, entity.ScrapperPayload) error {
+	return c.call("update")
+}
+
+func (c *fakeFeedsClient) DeleteScrapper(context.Context, uuid.UUID) error {
+	return c.call("delete")
+}
+
+func (c *fakeFeedsClient) CreateAPIFeed(context.Context, uuid.UUID, entity.APIFeedPayload) error {
+	return c.call("create")
+}
+
+func (c *fakeFeedsClient) UpdateAPIFeed(context.Context, uuid.UUID, entity.APIFeedPayload) error {
+	return c.call("update")
+}
+
+func (c *fakeFeedsClient) DeleteAPIFeed(context.Context, uuid.UUID) error {
+	return c.call("delete")
+}
+
+func TestScrapperAndAPIFeedDeliveryOfRepeatedEvents(t *testing.T) {
+	errFailed := errors.New("failed")
+	tests := []struct {
+		name      string
+		change    string
+		errs      map[string]error
+		wantErr   error
+		wantCalls []string
+	}{
+		{name: "create", change: entity.OutboxChangeCreated, wantCalls: []string{"create"}},
+		{name: "create of existing updates it", change: entity.OutboxChangeCreated, errs: map[string]error{"create": entity.ErrAlreadyExists},
+			wantCalls: []string{"create", "update"}},
+		{name: "create failure", change: entity.OutboxChangeCreated, errs: map[string]error{"create": errFailed},
+			wantErr: errFailed, wantCalls: []string{"create"}},
+		{name: "update", change: entity.OutboxChangeUpdated, wantCalls: []string{"update"}},
+		{name: "update of missing fails", change: entity.OutboxChangeUpdated, errs: map[string]error{"update": entity.ErrNotFound},
+			wantErr: entity.ErrNotFound, wantCalls: []string{"update"}},
+		{name: "delete", change: entity.OutboxChangeDeleted, wantCalls: []string{"delete"}},
+		{name: "delete of missing", change: entity.OutboxChangeDeleted, errs: map[string]error{"delete": entity.ErrNotFound},
+			wantCalls: []string{"delete"}},
+		{name: "delete failure", change: entity.OutboxChangeDeleted, errs: map[string]error{"delete": errFailed},
+			wantErr: errFailed, wantCalls: []string{"delete"}},
+	}
+	for _, publicationType := range []string{spec.PublicationTypeScrapped, spec.PublicationTypeAPI} {
+		for _, tt := range tests {
+			t.Run(publicationType+" "+tt.name, func(t *testing.T) {
+				client := &fakeFeedsClient{errs: tt.errs}
+				delivery := ScrapperDelivery(client)
+				if publicationType == spec.PublicationTypeAPI {
+					delivery = APIFeedDelivery(client)
+				}
+				event, err := entity.NewOutboxEvent(entity.PublicationOutboxEventType(publicationType, tt.change), uuid.Must(uuid.NewV4()), struct{}{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := delivery(context.Background(), event); !errors.Is(err, tt.wantErr) {
+					t.Errorf("delivery error = %v, want %v", err, tt.wantErr)
+				}
+				if strings.Join(client.calls, ",") != strings.Join(tt.wantCalls, ",") {
+					t.Errorf("client calls = %v, want %v", client.calls, tt.wantCalls)
+				}
+			})
+		}
+	}
+}
+
+func TestNewPublicationDeliveries(t *testing.T) {
+	// Registries are global, type is registered once for repeated test runs
+	if _, ok := spec.GetPublicationType("test-undeliverable"); !ok {
+		spec.RegisterPublicationType(&spec.PublicationType{
+			Name:   "test-undeliverable",
+			Decode: spec.NewJSONConfigDecoder(func() spec.PublicationConfig { return &spec.RSSPublicationConfig{} }),
+		})
+		RegisterPublicationTypeSync(&PublicationTypeSync{
+			Name:     "test-undeliverable",
+			OnCreate: rssFeedHook(entity.OutboxChangeCreated),
+		})
+	}
+	deliveries, err := NewPublicationDeliveries(map[string]string{
+		spec.PublicationTypeRSS:      "http://rss-feeds-api/feeds",
+		spec.PublicationTypeScrapped: "http://scrapper-api/scrappers",
+		spec.PublicationTypeAPI:      "",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 2 || deliveries[spec.PublicationTypeRSS] == nil || deliveries[spec.PublicationTypeScrapped] == nil {
+		t.Errorf("deliveries %v, want rss and scrapped", deliveries)
+	}
+	for name, serviceURLs := range map[string]map[string]string{
+		"unknown type":          {"unknown": "http://unknown-api/"},
+		"type without delivery": {"test-undeliverable": "http://test-api/"},
+		"invalid URL":           {spec.PublicationTypeAPI: "api-feeds-api/feeds"},
+	} {
+		if _, err := NewPublicationDeliveries(serviceURLs); err == nil {
+			t.Errorf("%s: deliveries are created", name)
+		}
+	}
+	// Changes of publications of type without delivery don't create outbox events, they couldn't be delivered
+	events, err := publicationOutboxEvents(publicationCreate, &entity.Publication{Type: "test-undeliverable", Config: []byte(`{"url":"https://example.com/feed"}`)})
+	if err != nil || len(events) != 0 {
+		t.Errorf("outbox events %v with error %v, want none", events, err)
+	}
+}
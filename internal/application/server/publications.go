@@ -6,39 +6,84 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"time"
 
 	"errors"
 
 	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/Tarick/naca-publications/internal/spec"
 
-	"github.com/asaskevich/govalidator"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
-	"github.com/go-chi/stampede"
 	validation "github.com/go-ozzo/ozzo-validation/v4"
-	"github.com/go-ozzo/ozzo-validation/v4/is"
 	"github.com/gofrs/uuid"
 )
 
-const PublicationTypeRSS string = "rss"
-
 func (s *Server) publicationsRouter() http.Handler {
 	r := chi.NewRouter()
-	// Set 1 second caiching and requests coalescing to avoid requests stampede. Beware of any user specific responses.
-	cached := stampede.Handler(512, 1*time.Second)
+	// Listing is cached until its collection is changed. Conditional requests are answered before reaching the cache.
 
 	// swagger:operation GET /publications getPublications
-	// Returns all publications registered in db
+	// Returns publications registered in db, paginated with cursor. Next page URL is in Link header.
 	// ---
+	// parameters:
+	//  - name: limit
+	//    in: query
+	//    description: maximum number of publications to return, 100 by default
+	//    required: false
+	//    type: integer
+	//  - name: cursor
+	//    in: query
+	//    description: opaque cursor to the next page, taken from Link header
+	//    required: false
+	//    type: string
+	//  - name: publisher_uuid
+	//    in: query
+	//    description: return only publications of this publisher
+	//    required: false
+	//    type: string
+	//  - name: publication_type
+	//    in: query
+	//    description: return only publications of this type
+	//    required: false
+	//    type: string
+	//  - name: language_code
+	//    in: query
+	//    description: return only publications with this two-letter language code
+	//    required: false
+	//    type: string
+	//  - name: name
+	//    in: query
+	//    description: return only publications with name containing this substring, case insensitive
+	//    required: false
+	//    type: string
+	//  - name: sort
+	//    in: query
+	//    description: sort by name, created_at or modified_at, prefixed with '-' for descending order
+	//    required: false
+	//    type: string
+	//  - name: updated_since
+	//    in: query
+	//    description: return only publications modified after this RFC3339 time
+	//    required: false
+	//    type: string
+	//    format: date-time
+	//  - name: include_deleted
+	//    in: query
+	//    description: return deleted publications too, they have deleted_at set
+	//    required: false
+	//    type: boolean
 	// responses:
+	//   '304':
+	//     description: Not modified since ETag in If-None-Match or If-Modified-Since time
 	//   '200':
-	//     description: list all publications
+	//     description: list publications page
 	//     schema:
 	//       type: array
 	//       items:
 	//         $ref: "#/definitions/PublicationResponseBody"
-	r.With(cached).Get("/", s.getPublications)
+	r.With(s.conditionalCollection(s.repository.GetPublicationsState), s.cache.handler(cachePublications)).Get("/", s.getPublications)
 
 	// swagger:operation  POST /publications createPublication
 	// Creates publication using supplied params from body
@@ -48,10 +93,81 @@ func (s *Server) publicationsRouter() http.Handler {
 	// responses:
 	//    '201':
 	//      $ref: "#/responses/PublicationResponse"
+	//    '409':
+	//      description: publication of the publisher with the same name exists, its UUID is in existing_uuid
+	//      schema:
+	//        $ref: "#/definitions/ErrResponseBody"
 	//    default:
 	//      $ref: "#/responses/ErrResponse"
 	r.Post("/", s.createPublication)
 
+	// swagger:operation POST /publications/{publication_uuid}/restore restorePublication
+	// Restores deleted publication, its publisher must not be deleted
+	// ---
+	// parameters:
+	//  - name: publication_uuid
+	//    in: path
+	//    description: Publication uuid to restore
+	//    required: true
+	//    type: string
+	//  - name: If-Match
+	//    in: header
+	//    description: ETag of publication version, request fails with 412 if it was modified
+	//    required: false
+	//    type: string
+	// responses:
+	//    '200':
+	//      $ref: "#/responses/PublicationResponse"
+	//    '409':
+	//      description: publication of the publisher with the same name exists, its UUID is in existing_uuid
+	//      schema:
+	//        $ref: "#/definitions/ErrResponseBody"
+	//    default:
+	//      $ref: "#/responses/ErrResponse"
+	r.Post("/{publication_uuid}/restore", s.restorePublication)
+
+	// swagger:operation GET /publications/{publication_uuid}/history getPublicationHistory
+	// Returns audit log of publication changes, including deleted publication, paginated with cursor. Next page URL is in Link header.
+	// ---
+	// parameters:
+	//  - name: publication_uuid
+	//    in: path
+	//    description: Publication uuid
+	//    required: true
+	//    type: string
+	//  - name: since
+	//    in: query
+	//    description: return only entries created at or after this RFC3339 time
+	//    required: false
+	//    type: string
+	//    format: date-time
+	//  - name: until
+	//    in: query
+	//    description: return only entries created before this RFC3339 time
+	//    required: false
+	//    type: string
+	//    format: date-time
+	//  - name: limit
+	//    in: query
+	//    description: maximum number of entries to return, 100 by default
+	//    required: false
+	//    type: integer
+	//  - name: cursor
+	//    in: query
+	//    description: opaque cursor to the next page, taken from Link header
+	//    required: false
+	//    type: string
+	// responses:
+	//   '200':
+	//     description: audit log entries page, oldest first
+	//     schema:
+	//       type: array
+	//       items:
+	//         $ref: "#/definitions/AuditEntry"
+	//   default:
+	//     $ref: "#/responses/ErrResponse"
+	r.Get("/{publication_uuid}/history", s.getPublicationHistory)
+
 	r.Route("/{publication_uuid}", func(r chi.Router) {
 		r.Use(s.publicationCtx) // handle publication_uuid
 
@@ -65,6 +181,8 @@ func (s *Server) publicationsRouter() http.Handler {
 		//    required: true
 		//    type: string
 		// responses:
+		//    '304':
+		//      description: Not modified since ETag in If-None-Match or If-Modified-Since time
 		//    '200':
 		//      $ref: "#/responses/PublicationResponse"
 		//    default:
@@ -81,9 +199,18 @@ func (s *Server) publicationsRouter() http.Handler {
 		//    required: true
 		//    type: string
 		//  - $ref: "#/definitions/Publication"
+		//  - name: If-Match
+		//    in: header
+		//    description: ETag of publication version, request fails with 412 if it was modified
+		//    required: false
+		//    type: string
 		// responses:
 		//    '200':
 		//      $ref: "#/responses/PublicationResponse"
+		//    '409':
+		//      description: publication of the publisher with the same name exists, its UUID is in existing_uuid
+		//      schema:
+		//        $ref: "#/definitions/ErrResponseBody"
 		//    default:
 		//      $ref: "#/responses/ErrResponse"
 		r.Put("/", s.updatePublication)
@@ -97,6 +224,11 @@ func (s *Server) publicationsRouter() http.Handler {
 		//    description: Publication uuid to delete
 		//    required: true
 		//    type: string
+		//  - name: If-Match
+		//    in: header
+		//    description: ETag of publication version, request fails with 412 if it was modified
+		//    required: false
+		//    type: string
 		// responses:
 		//  '204':
 		//    description: Send success
@@ -123,7 +255,7 @@ type PublicationResponseBody struct {
 // Render converts PublicationResponseBody to json and sends it to client
 func (pr *PublicationResponse) Render(w http.ResponseWriter, r *http.Request) {
 	// Pre-processing before a response is marshalled and sent across the wire
-	// Any instructions here
+	setValidators(w, versionETag(pr.Body.Version), pr.Body.ModifiedAt)
 	render.JSON(w, r, pr.Body)
 }
 
@@ -134,76 +266,7 @@ func newPublicationResponse(publication *entity.Publication) *PublicationRespons
 // PublicationRequest defines Publication create/update request with required Body and any additional headers
 type PublicationRequest struct {
 	// in: body
-	Body PublicationRequestBody
-}
-
-// PublicationRequestBody contains information on publication creation
-type PublicationRequestBody struct {
-	// swagger:allOf
-	Name          string    `json:"name"`
-	Description   string    `json:"description"`
-	LanguageCode  string    `json:"language_code"`
-	PublisherUUID uuid.UUID `json:"publisher_uuid"`
-	Type          string    `json:"publication_type"`
-	// Config content is different for different publication types.
-	// when parsing, we decide on Type
-	Config PublicationConfig `json:"config"`
-}
-
-// PublicationConfig is used to pass around different config structs
-type PublicationConfig interface{}
-
-// RSSPublicationConfig defines config for RSS Feeds
-type RSSPublicationConfig struct {
-	URL string `json:"url"`
-}
-
-// Only RSS is present now, but here's tested configs as well
-// type APIPublicationConfig struct {
-// 	URL    string `json:"url"`
-// 	APIKey string `json:"api_key"`
-// 	LanguageCode string `json:"language_code"`
-// }
-
-func (c *RSSPublicationConfig) Validate() error {
-	return validation.ValidateStruct(c,
-		validation.Field(&c.URL, validation.Required, validation.Length(5, 100), is.URL),
-	)
-}
-
-var isLanguageCode = validation.NewStringRuleWithError(
-	govalidator.IsISO693Alpha2,
-	validation.NewError("validation_is_language_code_2_letter", "must be a valid two-letter ISO693Alpha2 language code"))
-
-// Validate body
-func (b *PublicationRequestBody) Validate() error {
-	return validation.ValidateStruct(b,
-		validation.Field(&b.Name, validation.Required, validation.Length(2, 300)),
-		validation.Field(&b.Description, validation.Required, validation.Length(5, 300)),
-		validation.Field(&b.PublisherUUID, validation.Required, is.UUID, validation.By(checkUUIDNotNil)),
-		validation.Field(&b.LanguageCode, validation.Required, validation.Length(2, 2), isLanguageCode),
-		validation.Field(&b.Type, validation.Required, validation.By(checkPublicationType)),
-		validation.Field(&b.Config),
-	)
-}
-
-// validation helper to check UUID
-func checkUUIDNotNil(value interface{}) error {
-	u, _ := value.(uuid.UUID)
-	if u == uuid.Nil {
-		return errors.New("uuid is nil")
-	}
-	return nil
-}
-
-// validation helper to check publication type
-func checkPublicationType(value interface{}) error {
-	s, _ := value.(string)
-	// check can be extended with other types
-	if s != PublicationTypeRSS {
-		return fmt.Errorf("unknow publication type: %s", s)
-	}
-	return nil
+	Body spec.PublicationRequestBody
 }
 
 // Used as middleware to load an feed object from the URL parameters passed through as the request.
@@ -231,7 +294,7 @@ func (s *Server) publicationCtx(next http.Handler) http.Handler {
 		}
 		// 404
 		if publication == nil {
-			ErrNotFound.Render(w, r)
+			ErrPublicationNotFound.Render(w, r)
 			return
 		}
 		ctx := context.WithValue(r.Context(), "publication", publication)
@@ -243,39 +306,70 @@ func (s *Server) publicationCtx(next http.Handler) http.Handler {
 // TODO: with full details from subservices (RSS API)
 func (s *Server) getPublication(w http.ResponseWriter, r *http.Request) {
 	publication := r.Context().Value("publication").(*entity.Publication)
+	if notModified(w, r, versionETag(publication.Version), publication.ModifiedAt) {
+		return
+	}
 	newPublicationResponse(publication).Render(w, r)
 }
 
-// TODO: implement update of sub services
 func (s *Server) updatePublication(w http.ResponseWriter, r *http.Request) {
 	publication := r.Context().Value("publication").(*entity.Publication)
-	// FIXME
-	// publicationUpdated, publicationConfig, err := requestToPublication(r)
+	if !ifMatch(r, versionETag(publication.Version)) {
+		ErrPreconditionFailed.Render(w, r)
+		return
+	}
 	publicationUpdated, _, err := requestToPublication(r)
 	if err != nil {
 		s.logger.Error(fmt.Sprintf("Failure processing request: %s", err))
-		ErrInvalidRequest(err).Render(w, r)
+		ErrRequestBody(err).Render(w, r)
+		return
+	}
+	// Config is specific to publication type, so type cannot be changed
+	if publicationUpdated.Type != publication.Type {
+		ErrInvalidRequest(fmt.Errorf("'publication_type' cannot be changed from %s", publication.Type)).Render(w, r)
 		return
 	}
+	before := *publication
 	publication.Name, publication.Description, publication.LanguageCode = publicationUpdated.Name, publicationUpdated.Description, publicationUpdated.LanguageCode
-	if err := s.repository.UpdatePublication(r.Context(), publication); err != nil {
+	publication.Config = publicationUpdated.Config
+	events, err := publicationOutboxEvents(publicationUpdate, publication)
+	if err != nil {
+		ErrInternal(err).Render(w, r)
+		return
+	}
+	audit, err := newAuditEntry(r, entity.AuditEntityPublication, publication.UUID, entity.AuditActionUpdate, &before, publication)
+	if err != nil {
+		ErrInternal(err).Render(w, r)
+		return
+	}
+	err = s.repository.UpdatePublication(r.Context(), publication, audit, events...)
+	if errors.Is(err, entity.ErrVersionMismatch) {
+		ErrPreconditionFailed.Render(w, r)
+		return
+	}
+	if errors.Is(err, entity.ErrAlreadyExists) {
+		ErrPublicationAlreadyExists(err).Render(w, r)
+		return
+	}
+	if err != nil {
 		s.logger.Error(fmt.Sprintf("Failure updating publication %v: %s", publication, err))
 		ErrInternal(fmt.Errorf("Failure updating publication")).Render(w, r)
 		return
 	}
 	newPublicationResponse(publication).Render(w, r)
 }
-func requestToPublication(r *http.Request) (*entity.Publication, PublicationConfig, error) {
+
+func requestToPublication(r *http.Request) (*entity.Publication, spec.PublicationConfig, error) {
 	requestBody, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		return nil, nil, err
 	}
 	var (
 		publicationConfigBody json.RawMessage
-		publicationConfig     PublicationConfig
+		publicationConfig     spec.PublicationConfig
 		publication           *entity.Publication
 	)
-	publicationRequestBody := &PublicationRequestBody{Config: &publicationConfigBody}
+	publicationRequestBody := &spec.PublicationRequestBody{Config: &publicationConfigBody}
 	if err := json.Unmarshal(requestBody, publicationRequestBody); err != nil {
 		return nil, nil, err
 	}
@@ -287,58 +381,77 @@ func requestToPublication(r *http.Request) (*entity.Publication, PublicationConf
 		publicationRequestBody.Type); err != nil {
 		return nil, nil, err
 	}
-	switch publicationRequestBody.Type {
-	case PublicationTypeRSS:
-		config := RSSPublicationConfig{}
-		if err := json.Unmarshal(publicationConfigBody, &config); err != nil {
-			return nil, nil, err
-		}
-		publicationConfig = config
-	default:
-		return nil, nil, fmt.Errorf("incorrect 'publication_type' specified in request: %v", publicationRequestBody.Type)
-	}
+	// Publication type is validated before its config is decoded
 	if err := publicationRequestBody.Validate(); err != nil {
 		return nil, nil, err
 	}
+	if publicationConfig, err = spec.DecodePublicationConfig(publicationRequestBody.Type, publicationConfigBody); err != nil {
+		return nil, nil, err
+	}
+	// Store only known and validated config fields
+	if publication.Config, err = json.Marshal(publicationConfig); err != nil {
+		return nil, nil, err
+	}
 	return publication, publicationConfig, nil
 }
 
 func (s *Server) createPublication(w http.ResponseWriter, r *http.Request) {
-	publication, publicationConfig, err := requestToPublication(r)
+	publication, _, err := requestToPublication(r)
 	if err != nil {
 		s.logger.Error(fmt.Sprintf("Failure processing request: %s", err))
-		ErrInvalidRequest(err).Render(w, r)
+		ErrRequestBody(err).Render(w, r)
+		return
+	}
+	events, err := publicationOutboxEvents(publicationCreate, publication)
+	if err != nil {
+		ErrInternal(err).Render(w, r)
+		return
+	}
+	audit, err := newAuditEntry(r, entity.AuditEntityPublication, publication.UUID, entity.AuditActionCreate, nil, publication)
+	if err != nil {
+		ErrInternal(err).Render(w, r)
+		return
+	}
+	err = s.repository.CreatePublication(r.Context(), publication, audit, events...)
+	if errors.Is(err, entity.ErrAlreadyExists) {
+		ErrPublicationAlreadyExists(err).Render(w, r)
+		return
+	}
+	if errors.Is(err, entity.ErrNotFound) {
+		ErrPublisherInvalid(CodePublisherNotFound, fmt.Errorf("publisher %v doesn't exist", publication.PublisherUUID)).Render(w, r)
 		return
 	}
-	if err := s.repository.CreatePublication(r.Context(), publication); err != nil {
+	if err != nil {
 		s.logger.Error(fmt.Sprintf("Failure creating publication %v in database: %s", publication, err))
 		ErrInternal(fmt.Errorf("Failure creating publication")).Render(w, r)
 		return
 	}
-	switch v := publicationConfig.(type) {
-	case RSSPublicationConfig:
-		//FIXME: Fix context
-		if err := s.rssFeedsAPIClient.CreateRSSFeed(r.Context(), publication.UUID, v.URL, publication.LanguageCode); err != nil {
-			s.logger.Error("Failure creating RSS Feeds Publication in RSS service: ", err)
-			errs := fmt.Errorf("failure creating publication: %w", err)
-			// revert publication creation. No need for full saga patern yet.
-			if err = s.repository.DeletePublication(r.Context(), publication.UUID); err != nil {
-				s.logger.Error("Failure deleting failed RSS Publication from repository: ", err)
-				errs = fmt.Errorf("%w, failure deleting created publication from repository: %w", errs, err)
-			}
-			s.logger.Debug("Deleted publication with UUID ", publication.UUID, "from repository")
-			ErrInternal(errs).Render(w, r)
-			return
-		}
-	}
 	render.Status(r, http.StatusCreated)
 	newPublicationResponse(publication).Render(w, r)
 }
 
-// TODO: implement removal from sub services (RSS)
 func (s *Server) deletePublication(w http.ResponseWriter, r *http.Request) {
 	publication := r.Context().Value("publication").(*entity.Publication)
-	if err := s.repository.DeletePublication(r.Context(), publication.UUID); err != nil {
+	if !ifMatch(r, versionETag(publication.Version)) {
+		ErrPreconditionFailed.Render(w, r)
+		return
+	}
+	events, err := publicationOutboxEvents(publicationDelete, publication)
+	if err != nil {
+		ErrInternal(err).Render(w, r)
+		return
+	}
+	audit, err := newAuditEntry(r, entity.AuditEntityPublication, publication.UUID, entity.AuditActionDelete, publication, nil)
+	if err != nil {
+		ErrInternal(err).Render(w, r)
+		return
+	}
+	err = s.repository.DeletePublication(r.Context(), publication, audit, events...)
+	if errors.Is(err, entity.ErrVersionMismatch) {
+		ErrPreconditionFailed.Render(w, r)
+		return
+	}
+	if err != nil {
 		s.logger.Error(fmt.Sprintf("Failure deleting publication %v: %s", publication, err))
 		ErrInternal(fmt.Errorf("Failure deleting publication %v", publication)).Render(w, r)
 		return
@@ -346,19 +459,160 @@ func (s *Server) deletePublication(w http.ResponseWriter, r *http.Request) {
 	render.NoContent(w, r)
 }
 
+// publicationsQueryFromRequest reads filtering, sorting and pagination query parameters
+func publicationsQueryFromRequest(r *http.Request) (entity.PublicationsQuery, error) {
+	params := r.URL.Query()
+	query := entity.PublicationsQuery{
+		Type:         params.Get("publication_type"),
+		LanguageCode: params.Get("language_code"),
+		Name:         params.Get("name"),
+	}
+	if publisherUUIDParam := params.Get("publisher_uuid"); publisherUUIDParam != "" {
+		publisherUUID, err := uuid.FromString(publisherUUIDParam)
+		if err != nil {
+			return query, fmt.Errorf("invalid publisher_uuid parameter %s", publisherUUIDParam)
+		}
+		query.PublisherUUID = publisherUUID
+	}
+	updatedSince, err := updatedSinceFromRequest(r)
+	if err != nil {
+		return query, err
+	}
+	query.UpdatedSince = updatedSince
+	if query.IncludeDeleted, err = includeDeletedFromRequest(r); err != nil {
+		return query, err
+	}
+	if query.Type != "" {
+		if err := spec.CheckPublicationType(query.Type); err != nil {
+			return query, err
+		}
+	}
+	if err := validation.Validate(query.LanguageCode, validation.Length(2, 2), spec.IsLanguageCode); err != nil {
+		return query, fmt.Errorf("invalid language_code parameter %s: %w", query.LanguageCode, err)
+	}
+	sortParam := params.Get("sort")
+	query.Sort = strings.TrimPrefix(sortParam, "-")
+	query.Descending = strings.HasPrefix(sortParam, "-")
+	switch query.Sort {
+	case entity.PublicationsSortByName, entity.PublicationsSortByCreatedAt, entity.PublicationsSortByModifiedAt:
+	case "":
+		// Sorting by UUID has no field to put after "-"
+		if query.Descending {
+			return query, fmt.Errorf("invalid sort parameter %s", sortParam)
+		}
+	default:
+		return query, fmt.Errorf("invalid sort parameter %s", sortParam)
+	}
+	page, err := pageFromRequest(r, sortParam)
+	if err != nil {
+		return query, err
+	}
+	// Timestamps are passed in cursor as text
+	if value, ok := page.AfterValue.(string); ok && query.Sort != "" && query.Sort != entity.PublicationsSortByName {
+		if page.AfterValue, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			return query, fmt.Errorf("invalid cursor parameter")
+		}
+	}
+	query.Page = page
+	return query, nil
+}
+
+// publicationCursor returns cursor pointing to the publication for the sort parameter
+func publicationCursor(p *entity.Publication, sortParam string) pageCursor {
+	cursor := pageCursor{After: p.UUID, Sort: sortParam}
+	switch strings.TrimPrefix(sortParam, "-") {
+	case entity.PublicationsSortByName:
+		cursor.Value = p.Name
+	case entity.PublicationsSortByCreatedAt:
+		cursor.Value = p.CreatedAt.Format(time.RFC3339Nano)
+	case entity.PublicationsSortByModifiedAt:
+		cursor.Value = p.ModifiedAt.Format(time.RFC3339Nano)
+	}
+	return cursor
+}
+
 // Returns publication entries
-// TODO: filtering
 // TODO: get data with full details from sub services (RSS API, scraping config?)
 func (s *Server) getPublications(w http.ResponseWriter, r *http.Request) {
-	publications, err := s.repository.GetPublications(r.Context())
+	query, err := publicationsQueryFromRequest(r)
+	if err != nil {
+		ErrInvalidRequest(err).Render(w, r)
+		return
+	}
+	limit := query.Limit
+	// Request one more row to find out if there is a next page
+	query.Limit++
+	publications, err := s.repository.GetPublicationsPage(r.Context(), query)
 	if err != nil {
 		s.logger.Error(fmt.Sprint("Failure querying for publications: ", err))
 		ErrInternal(fmt.Errorf("Failure querying database for publications")).Render(w, r)
 		return
 	}
+	if len(publications) > limit {
+		publications = publications[:limit]
+		setNextPageLink(w, r, limit, publicationCursor(publications[limit-1], r.URL.Query().Get("sort")))
+	}
 	response := make([]*PublicationResponseBody, len(publications), len(publications))
 	for i := 0; i < len(publications); i++ {
 		response[i] = &newPublicationResponse(publications[i]).Body
 	}
 	render.JSON(w, r, response)
 }
+
+// restorePublication restores deleted publication of not deleted publisher
+func (s *Server) restorePublication(w http.ResponseWriter, r *http.Request) {
+	publicationUUIDParam := chi.URLParam(r, "publication_uuid")
+	publicationUUID, err := uuid.FromString(publicationUUIDParam)
+	if err != nil {
+		ErrInvalidRequest(fmt.Errorf("invalid uuid parameter %s", publicationUUIDParam)).Render(w, r)
+		return
+	}
+	publication, err := s.repository.GetDeletedPublication(r.Context(), publicationUUID)
+	if err != nil {
+		ErrInternal(fmt.Errorf("Failure getting Publication data")).Render(w, r)
+		return
+	}
+	// Publication doesn't exist or is not deleted
+	if publication == nil {
+		ErrPublicationNotFound.Render(w, r)
+		return
+	}
+	if !ifMatch(r, versionETag(publication.Version)) {
+		ErrPreconditionFailed.Render(w, r)
+		return
+	}
+	publisher, err := s.repository.GetPublisher(r.Context(), publication.PublisherUUID)
+	if err != nil {
+		ErrInternal(fmt.Errorf("Failure getting Publisher data")).Render(w, r)
+		return
+	}
+	if publisher == nil {
+		ErrPublisherInvalid(CodePublisherDeleted, fmt.Errorf("publisher %v is deleted, restore it first", publication.PublisherUUID)).Render(w, r)
+		return
+	}
+	events, err := publicationOutboxEvents(publicationCreate, publication)
+	if err != nil {
+		ErrInternal(err).Render(w, r)
+		return
+	}
+	audit, err := newAuditEntry(r, entity.AuditEntityPublication, publication.UUID, entity.AuditActionRestore, nil, nil)
+	if err != nil {
+		ErrInternal(err).Render(w, r)
+		return
+	}
+	err = s.repository.RestorePublication(r.Context(), publication, audit, events...)
+	if errors.Is(err, entity.ErrVersionMismatch) {
+		ErrPreconditionFailed.Render(w, r)
+		return
+	}
+	if errors.Is(err, entity.ErrAlreadyExists) {
+		ErrPublicationAlreadyExists(err).Render(w, r)
+		return
+	}
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("Failure restoring publication %v: %s", publication, err))
+		ErrInternal(fmt.Errorf("Failure restoring publication %v", publication)).Render(w, r)
+		return
+	}
+	newPublicationResponse(publication).Render(w, r)
+}
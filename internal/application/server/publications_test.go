@@ -0,0 +1,206 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/Tarick/naca-publications/internal/spec"
+	"github.com/gofrs/uuid"
+	"go.uber.org/zap"
+)
+
+// fakeRepository keeps one publication, last changed publication and outbox events of successful changes,
+// other methods are not implemented
+type fakeRepository struct {
+	PublicationsRepository
+	publication *entity.Publication
+	// err is returned by changing methods instead of storing the change
+	err     error
+	changed *entity.Publication
+	events  []*entity.OutboxEvent
+}
+
+func (repo *fakeRepository) GetPublication(_ context.Context, publicationUUID uuid.UUID) (*entity.Publication, error) {
+	if repo.publication == nil || repo.publication.UUID != publicationUUID {
+		return nil, nil
+	}
+	p := *repo.publication
+	return &p, nil
+}
+
+func (repo *fakeRepository) change(p *entity.Publication, events []*entity.OutboxEvent) error {
+	if repo.err != nil {
+		return repo.err
+	}
+	repo.changed = p
+	repo.events = append(repo.events, events...)
+	return nil
+}
+
+func (repo *fakeRepository) CreatePublication(_ context.Context, p *entity.Publication, _ *entity.AuditEntry, events ...*entity.OutboxEvent) error {
+	return repo.change(p, events)
+}
+
+func (repo *fakeRepository) UpdatePublication(_ context.Context, p *entity.Publication, _ *entity.AuditEntry, events ...*entity.OutboxEvent) error {
+	return repo.change(p, events)
+}
+
+func (repo *fakeRepository) DeletePublication(_ context.Context, p *entity.Publication, _ *entity.AuditEntry, events ...*entity.OutboxEvent) error {
+	return repo.change(p, events)
+}
+
+// rssFeedCall is a call of RSS Feeds client method with its arguments, URL and language code are empty for deletion
+type rssFeedCall struct {
+	method       string
+	uuid         uuid.UUID
+	url          string
+	languageCode string
+}
+
+// fakeRSSFeedsAPIClient records calls of its methods, which return errors of the methods
+type fakeRSSFeedsAPIClient struct {
+	calls []rssFeedCall
+	// errs are returned by methods with the name
+	errs map[string]error
+	// exists is result of RSSFeedExists, existsErr is its error
+	exists    bool
+	existsErr error
+}
+
+func (c *fakeRSSFeedsAPIClient) call(call rssFeedCall) error {
+	c.calls = append(c.calls, call)
+	return c.errs[call.method]
+}
+
+func (c *fakeRSSFeedsAPIClient) CreateRSSFeed(_ context.Context, feedUUID uuid.UUID, url string, languageCode string) error {
+	return c.call(rssFeedCall{"CreateRSSFeed", feedUUID, url, languageCode})
+}
+
+func (c *fakeRSSFeedsAPIClient) UpdateRSSFeed(_ context.Context, feedUUID uuid.UUID, url string, languageCode string) error {
+	return c.call(rssFeedCall{"UpdateRSSFeed", feedUUID, url, languageCode})
+}
+
+func (c *fakeRSSFeedsAPIClient) DeleteRSSFeed(_ context.Context, feedUUID uuid.UUID) error {
+	return c.call(rssFeedCall{method: "DeleteRSSFeed", uuid: feedUUID})
+}
+
+func (c *fakeRSSFeedsAPIClient) RSSFeedExists(context.Context, uuid.UUID) (bool, error) {
+	return c.exists, c.existsErr
+}
+
+func TestPublicationHandlersRSSFeedsSync(t *testing.T) {
+	publication := &entity.Publication{
+		UUID:          uuid.Must(uuid.NewV4()),
+		Name:          "Feed",
+		Description:   "Feed of publisher",
+		LanguageCode:  "en",
+		PublisherUUID: uuid.Must(uuid.NewV4()),
+		Type:          spec.PublicationTypeRSS,
+		Config:        []byte(`{"url":"https://example.com/feed"}`),
+		Version:       3,
+	}
+	// Request changes URL and language code of the stored publication
+	body := func(publicationType string, config string) string {
+		return `{"name":"Feed","description":"Feed of publisher","language_code":"de","publisher_uuid":"` +
+			publication.PublisherUUID.String() + `","publication_type":"` + publicationType + `","config":` + config + `}`
+	}
+	validBody := body(spec.PublicationTypeRSS, `{"url":"https://example.com/feed.xml"}`)
+	path := "/publications/" + publication.UUID.String()
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		ifMatch string
+		repoErr error
+		// missing removes publication from repository
+		missing    bool
+		wantStatus int
+		// wantCalls of RSS Feeds client are made with UUID of changed publication
+		wantCalls []rssFeedCall
+	}{
+		{name: "create", method: http.MethodPost, path: "/publications", body: validBody,
+			wantStatus: http.StatusCreated, wantCalls: []rssFeedCall{{method: "CreateRSSFeed", url: "https://example.com/feed.xml", languageCode: "de"}}},
+		{name: "create invalid config", method: http.MethodPost, path: "/publications", body: body(spec.PublicationTypeRSS, `{"url":"not url"}`),
+			wantStatus: http.StatusBadRequest},
+		{name: "create existing", method: http.MethodPost, path: "/publications", body: validBody, repoErr: entity.ErrAlreadyExists,
+			wantStatus: http.StatusConflict},
+		{name: "create of missing publisher", method: http.MethodPost, path: "/publications", body: validBody, repoErr: entity.ErrNotFound,
+			wantStatus: http.StatusBadRequest},
+		{name: "create failure", method: http.MethodPost, path: "/publications", body: validBody, repoErr: errors.New("db is down"),
+			wantStatus: http.StatusInternalServerError},
+
+		{name: "update", method: http.MethodPut, path: path, body: validBody, ifMatch: `"3"`,
+			wantStatus: http.StatusOK, wantCalls: []rssFeedCall{{method: "UpdateRSSFeed", url: "https://example.com/feed.xml", languageCode: "de"}}},
+		{name: "update stale version", method: http.MethodPut, path: path, body: validBody, ifMatch: `"2"`,
+			wantStatus: http.StatusPreconditionFailed},
+		{name: "update concurrent change", method: http.MethodPut, path: path, body: validBody, repoErr: entity.ErrVersionMismatch,
+			wantStatus: http.StatusPreconditionFailed},
+		{name: "update type", method: http.MethodPut, path: path, body: body(spec.PublicationTypeAPI, `{"url":"https://example.com/api","api_key_ref":"key"}`),
+			wantStatus: http.StatusBadRequest},
+		{name: "update missing", method: http.MethodPut, path: path, body: validBody, missing: true,
+			wantStatus: http.StatusNotFound},
+		{name: "update failure", method: http.MethodPut, path: path, body: validBody, repoErr: errors.New("db is down"),
+			wantStatus: http.StatusInternalServerError},
+
+		{name: "delete", method: http.MethodDelete, path: path, ifMatch: `"3"`,
+			wantStatus: http.StatusNoContent, wantCalls: []rssFeedCall{{method: "DeleteRSSFeed"}}},
+		{name: "delete stale version", method: http.MethodDelete, path: path, ifMatch: `"2"`,
+			wantStatus: http.StatusPreconditionFailed},
+		{name: "delete missing", method: http.MethodDelete, path: path, missing: true,
+			wantStatus: http.StatusNotFound},
+		{name: "delete failure", method: http.MethodDelete, path: path, repoErr: errors.New("db is down"),
+			wantStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeRepository{publication: publication, err: tt.repoErr}
+			if tt.missing {
+				repo.publication = nil
+			}
+			s, err := New(Config{Auth: AuthConfig{AnonymousRole: entity.RoleEditor}}, zap.NewNop().Sugar(), repo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			res := httptest.NewRecorder()
+			s.httpServer.Handler.ServeHTTP(res, req)
+			if res.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body: %s", res.Code, tt.wantStatus, res.Body.String())
+			}
+
+			// Outbox events stored with the change are delivered to RSS Feeds service as dispatcher does
+			client := &fakeRSSFeedsAPIClient{}
+			deliver := RSSFeedDelivery(client, client.RSSFeedExists)
+			for _, event := range repo.events {
+				if err := deliver(context.Background(), event); err != nil {
+					t.Fatalf("delivery of %s event: %s", event.Type, err)
+				}
+			}
+			if len(client.calls) != len(tt.wantCalls) {
+				t.Fatalf("RSS Feeds client calls = %+v, want %+v", client.calls, tt.wantCalls)
+			}
+			for i, want := range tt.wantCalls {
+				want.uuid = repo.changed.UUID
+				if tt.method != http.MethodPost && want.uuid != publication.UUID {
+					t.Errorf("changed publication %v, want %v", want.uuid, publication.UUID)
+				}
+				if client.calls[i] != want {
+					t.Errorf("RSS Feeds client call = %+v, want %+v", client.calls[i], want)
+				}
+			}
+		})
+	}
+}
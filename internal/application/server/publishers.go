@@ -5,32 +5,54 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"time"
 
 	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/Tarick/naca-publications/internal/spec"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
-	"github.com/go-chi/stampede"
 	"github.com/gofrs/uuid"
 )
 
 func (s *Server) publishersRouter() http.Handler {
 	r := chi.NewRouter()
-	// Set 1 second caching and requests coalescing to avoid requests stampede. Beware of any user specific responses.
-	cached := stampede.Handler(512, 1*time.Second)
+	// Listing is cached until its collection is changed. Conditional requests are answered before reaching the cache.
 
 	// swagger:operation GET /publishers getPublishers
-	// Returns all publishers registered in db
+	// Returns publishers registered in db, paginated with cursor. Next page URL is in Link header.
 	// ---
+	// parameters:
+	//  - name: limit
+	//    in: query
+	//    description: maximum number of publishers to return, 100 by default
+	//    required: false
+	//    type: integer
+	//  - name: cursor
+	//    in: query
+	//    description: opaque cursor to the next page, taken from Link header
+	//    required: false
+	//    type: string
+	//  - name: updated_since
+	//    in: query
+	//    description: return only publishers modified after this RFC3339 time
+	//    required: false
+	//    type: string
+	//    format: date-time
+	//  - name: include_deleted
+	//    in: query
+	//    description: return deleted publishers too, they have deleted_at set
+	//    required: false
+	//    type: boolean
 	// responses:
+	//   '304':
+	//     description: Not modified since ETag in If-None-Match or If-Modified-Since time
 	//   '200':
-	//     description: list all publishers
+	//     description: list publishers page
 	//     schema:
 	//       type: array
 	//       items:
 	//         $ref: "#/definitions/PublisherResponseBody"
-	r.With(cached).Get("/", s.getPublishers)
+	r.With(s.conditionalCollection(s.repository.GetPublishersState), s.cache.handler(cachePublishers)).Get("/", s.getPublishers)
 
 	// swagger:operation  POST /publishers createPublisher
 	// Creates publisher using supplied params from body
@@ -40,10 +62,81 @@ func (s *Server) publishersRouter() http.Handler {
 	// responses:
 	//    '201':
 	//      $ref: "#/responses/PublisherResponse"
+	//    '409':
+	//      description: publisher with the same name or url exists, its UUID is in existing_uuid
+	//      schema:
+	//        $ref: "#/definitions/ErrResponseBody"
 	//    default:
 	//      $ref: "#/responses/ErrResponse"
 	r.Post("/", s.createPublisher)
 
+	// swagger:operation POST /publishers/{publisher_uuid}/restore restorePublisher
+	// Restores deleted publisher together with publications deleted with it
+	// ---
+	// parameters:
+	//  - name: publisher_uuid
+	//    in: path
+	//    description: Publisher uuid to restore
+	//    required: true
+	//    type: string
+	//  - name: If-Match
+	//    in: header
+	//    description: ETag of publisher version, request fails with 412 if it was modified
+	//    required: false
+	//    type: string
+	// responses:
+	//    '200':
+	//      $ref: "#/responses/PublisherResponse"
+	//    '409':
+	//      description: publisher with the same name or url exists, its UUID is in existing_uuid
+	//      schema:
+	//        $ref: "#/definitions/ErrResponseBody"
+	//    default:
+	//      $ref: "#/responses/ErrResponse"
+	r.Post("/{publisher_uuid}/restore", s.restorePublisher)
+
+	// swagger:operation GET /publishers/{publisher_uuid}/history getPublisherHistory
+	// Returns audit log of publisher changes, including deleted publisher, paginated with cursor. Next page URL is in Link header.
+	// ---
+	// parameters:
+	//  - name: publisher_uuid
+	//    in: path
+	//    description: Publisher uuid
+	//    required: true
+	//    type: string
+	//  - name: since
+	//    in: query
+	//    description: return only entries created at or after this RFC3339 time
+	//    required: false
+	//    type: string
+	//    format: date-time
+	//  - name: until
+	//    in: query
+	//    description: return only entries created before this RFC3339 time
+	//    required: false
+	//    type: string
+	//    format: date-time
+	//  - name: limit
+	//    in: query
+	//    description: maximum number of entries to return, 100 by default
+	//    required: false
+	//    type: integer
+	//  - name: cursor
+	//    in: query
+	//    description: opaque cursor to the next page, taken from Link header
+	//    required: false
+	//    type: string
+	// responses:
+	//   '200':
+	//     description: audit log entries page, oldest first
+	//     schema:
+	//       type: array
+	//       items:
+	//         $ref: "#/definitions/AuditEntry"
+	//   default:
+	//     $ref: "#/responses/ErrResponse"
+	r.Get("/{publisher_uuid}/history", s.getPublisherHistory)
+
 	r.Route("/{publisher_uuid}", func(r chi.Router) {
 		r.Use(s.publisherCtx) // handle publisher_uuid
 
@@ -57,6 +150,8 @@ func (s *Server) publishersRouter() http.Handler {
 		//    required: true
 		//    type: string
 		// responses:
+		//    '304':
+		//      description: Not modified since ETag in If-None-Match or If-Modified-Since time
 		//    '200':
 		//      $ref: "#/responses/PublisherResponse"
 		//    default:
@@ -72,11 +167,13 @@ func (s *Server) publishersRouter() http.Handler {
 		//    required: true
 		//    type: string
 		// responses:
+		//    '304':
+		//      description: Not modified since ETag in If-None-Match or If-Modified-Since time
 		//    '200':
 		//      $ref: "#/responses/PublisherResponse"
 		//    default:
 		//      $ref: "#/responses/ErrResponse"
-		r.Get("/publications", s.getPublisherPublications)
+		r.With(s.conditionalCollection(s.repository.GetPublicationsState)).Get("/publications", s.getPublisherPublications)
 
 		// swagger:operation PUT /publishers/{publisher_uuid} updatePublisher
 		// Modifies Publisher using supplied params from body
@@ -88,9 +185,18 @@ func (s *Server) publishersRouter() http.Handler {
 		//    required: true
 		//    type: string
 		//  - $ref: "#/definitions/Publisher"
+		//  - name: If-Match
+		//    in: header
+		//    description: ETag of publisher version, request fails with 412 if it was modified
+		//    required: false
+		//    type: string
 		// responses:
 		//    '200':
 		//      $ref: "#/responses/PublisherResponse"
+		//    '409':
+		//      description: publisher with the same name or url exists, its UUID is in existing_uuid
+		//      schema:
+		//        $ref: "#/definitions/ErrResponseBody"
 		//    default:
 		//      $ref: "#/responses/ErrResponse"
 		r.Put("/", s.updatePublisher)
@@ -104,6 +210,11 @@ func (s *Server) publishersRouter() http.Handler {
 		//    description: Publisher uuid to delete
 		//    required: true
 		//    type: string
+		//  - name: If-Match
+		//    in: header
+		//    description: ETag of publisher version, request fails with 412 if it was modified
+		//    required: false
+		//    type: string
 		// responses:
 		//  '204':
 		//    description: Send success
@@ -130,7 +241,7 @@ type PublisherResponseBody struct {
 // Render converts PublisherResponseBody to json and sends it to client
 func (pr *PublisherResponse) Render(w http.ResponseWriter, r *http.Request) {
 	// Pre-processing before a response is marshalled and sent across the wire
-	// Any instructions here
+	setValidators(w, versionETag(pr.Body.Version), pr.Body.ModifiedAt)
 	render.JSON(w, r, pr.Body)
 }
 
@@ -141,28 +252,7 @@ func newPublisherResponse(publisher *entity.Publisher) *PublisherResponse {
 // PublisherRequest defines Publisher request with Body and any additional headers
 type PublisherRequest struct {
 	// in: body
-	Body PublisherRequestBody
-}
-
-// PublisherRequestBody contains information on publisher creation
-type PublisherRequestBody struct {
-	// swagger:allOf
-	Name string `json:"name"`
-	URL  string `json:"url"`
-}
-
-// Bind implements Bind interface for chi Bind to map request body to request body struct, with simple validator
-func (p *PublisherRequestBody) Bind(r *http.Request) error {
-	if p == nil {
-		return errors.New("request body is empty")
-	}
-	if p.Name == "" {
-		return errors.New("missing required 'name' field")
-	}
-	if p.URL == "" {
-		return errors.New("missing required 'url' field")
-	}
-	return nil
+	Body spec.PublisherRequestBody
 }
 
 // Used as middleware to load object from the URL parameters passed through as the request.
@@ -189,7 +279,7 @@ func (s *Server) publisherCtx(next http.Handler) http.Handler {
 			return
 		}
 		if publisher == nil {
-			ErrNotFound.Render(w, r)
+			ErrPublisherNotFound.Render(w, r)
 			return
 		}
 		ctx := context.WithValue(r.Context(), "publisher", publisher)
@@ -200,18 +290,40 @@ func (s *Server) publisherCtx(next http.Handler) http.Handler {
 // // Response with single feed
 func (s *Server) getPublisher(w http.ResponseWriter, r *http.Request) {
 	publisher := r.Context().Value("publisher").(*entity.Publisher)
+	if notModified(w, r, versionETag(publisher.Version), publisher.ModifiedAt) {
+		return
+	}
 	newPublisherResponse(publisher).Render(w, r)
 }
 func (s *Server) updatePublisher(w http.ResponseWriter, r *http.Request) {
 	publisher := r.Context().Value("publisher").(*entity.Publisher)
-	data := &PublisherRequestBody{}
+	if !ifMatch(r, versionETag(publisher.Version)) {
+		ErrPreconditionFailed.Render(w, r)
+		return
+	}
+	data := &spec.PublisherRequestBody{}
 	if err := render.Bind(r, data); err != nil {
-		ErrInvalidRequest(err).Render(w, r)
+		ErrRequestBody(err).Render(w, r)
 		return
 	}
+	before := *publisher
 	publisher.Name = data.Name
 	publisher.URL = data.URL
-	if err := s.repository.UpdatePublisher(r.Context(), publisher); err != nil {
+	audit, err := newAuditEntry(r, entity.AuditEntityPublisher, publisher.UUID, entity.AuditActionUpdate, &before, publisher)
+	if err != nil {
+		ErrInternal(err).Render(w, r)
+		return
+	}
+	err = s.repository.UpdatePublisher(r.Context(), publisher, audit)
+	if errors.Is(err, entity.ErrVersionMismatch) {
+		ErrPreconditionFailed.Render(w, r)
+		return
+	}
+	if errors.Is(err, entity.ErrAlreadyExists) {
+		ErrPublisherAlreadyExists(err).Render(w, r)
+		return
+	}
+	if err != nil {
 		// log.Error(fmt.Sprintf("Failure updating publisher %v: %s", publisher, err))
 		ErrInternal(fmt.Errorf("Failure updating publisher")).Render(w, r)
 		return
@@ -220,18 +332,27 @@ func (s *Server) updatePublisher(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) createPublisher(w http.ResponseWriter, r *http.Request) {
-	data := &PublisherRequestBody{}
+	data := &spec.PublisherRequestBody{}
 	if err := render.Bind(r, data); err != nil {
-		ErrInvalidRequest(err).Render(w, r)
+		ErrRequestBody(err).Render(w, r)
 		return
 	}
-	// FIXME: validate if publisher already exists
 	publisher, err := entity.NewPublisher(data.Name, data.URL)
 	if err != nil {
 		ErrInternal(err).Render(w, r)
 		return
 	}
-	if err := s.repository.CreatePublisher(r.Context(), publisher); err != nil {
+	audit, err := newAuditEntry(r, entity.AuditEntityPublisher, publisher.UUID, entity.AuditActionCreate, nil, publisher)
+	if err != nil {
+		ErrInternal(err).Render(w, r)
+		return
+	}
+	err = s.repository.CreatePublisher(r.Context(), publisher, audit)
+	if errors.Is(err, entity.ErrAlreadyExists) {
+		ErrPublisherAlreadyExists(err).Render(w, r)
+		return
+	}
+	if err != nil {
 		// log.Error(fmt.Sprintf("Failure creating publisher %v in database: %s", publisher, err))
 		ErrInternal(fmt.Errorf("Failure creating publisher")).Render(w, r)
 		return
@@ -242,7 +363,22 @@ func (s *Server) createPublisher(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) deletePublisher(w http.ResponseWriter, r *http.Request) {
 	publisher := r.Context().Value("publisher").(*entity.Publisher)
-	if err := s.repository.DeletePublisher(r.Context(), publisher.UUID); err != nil {
+	if !ifMatch(r, versionETag(publisher.Version)) {
+		ErrPreconditionFailed.Render(w, r)
+		return
+	}
+	audit, err := newAuditEntry(r, entity.AuditEntityPublisher, publisher.UUID, entity.AuditActionDelete, publisher, nil)
+	if err != nil {
+		ErrInternal(err).Render(w, r)
+		return
+	}
+	// Publisher publications are deleted with it, so they must be deleted from their backing services as well
+	err = s.repository.DeletePublisher(r.Context(), publisher, audit, publicationsOutboxEvents(publicationDelete))
+	if errors.Is(err, entity.ErrVersionMismatch) {
+		ErrPreconditionFailed.Render(w, r)
+		return
+	}
+	if err != nil {
 		// log.Error(fmt.Sprintf("Failure deleting publisher %v: %s", publisher, err))
 		ErrInternal(fmt.Errorf("Failure deleting publisher %v", publisher)).Render(w, r)
 		return
@@ -252,12 +388,33 @@ func (s *Server) deletePublisher(w http.ResponseWriter, r *http.Request) {
 
 // TODO: filtering
 func (s *Server) getPublishers(w http.ResponseWriter, r *http.Request) {
-	publishers, err := s.repository.GetPublishers(r.Context())
+	page, err := pageFromRequest(r, "")
+	if err != nil {
+		ErrInvalidRequest(err).Render(w, r)
+		return
+	}
+	updatedSince, err := updatedSinceFromRequest(r)
+	if err != nil {
+		ErrInvalidRequest(err).Render(w, r)
+		return
+	}
+	includeDeleted, err := includeDeletedFromRequest(r)
+	if err != nil {
+		ErrInvalidRequest(err).Render(w, r)
+		return
+	}
+	// Request one more row to find out if there is a next page
+	query := entity.PublishersQuery{Page: entity.Page{Limit: page.Limit + 1, After: page.After}, UpdatedSince: updatedSince, IncludeDeleted: includeDeleted}
+	publishers, err := s.repository.GetPublishersPage(r.Context(), query)
 	if err != nil {
 		// log.Error(fmt.Sprint("Failure querying for publishers: ", err))
 		ErrInternal(errors.New("Failure querying database for publishers")).Render(w, r)
 		return
 	}
+	if len(publishers) > page.Limit {
+		publishers = publishers[:page.Limit]
+		setNextPageLink(w, r, page.Limit, pageCursor{After: publishers[page.Limit-1].UUID})
+	}
 	response := make([]*PublisherResponseBody, len(publishers), len(publishers))
 	for i := 0; i < len(publishers); i++ {
 		response[i] = &newPublisherResponse(publishers[i]).Body
@@ -279,3 +436,54 @@ func (s *Server) getPublisherPublications(w http.ResponseWriter, r *http.Request
 	}
 	render.JSON(w, r, response)
 }
+
+// restorePublisher restores deleted publisher together with publications deleted with it
+func (s *Server) restorePublisher(w http.ResponseWriter, r *http.Request) {
+	publisherUUIDParam := chi.URLParam(r, "publisher_uuid")
+	publisherUUID, err := uuid.FromString(publisherUUIDParam)
+	if err != nil {
+		ErrInvalidRequest(fmt.Errorf("invalid uuid parameter %s", publisherUUIDParam)).Render(w, r)
+		return
+	}
+	publisher, err := s.repository.GetDeletedPublisher(r.Context(), publisherUUID)
+	if err != nil {
+		ErrInternal(fmt.Errorf("Failure getting Publisher data")).Render(w, r)
+		return
+	}
+	// Publisher doesn't exist or is not deleted
+	if publisher == nil {
+		ErrPublisherNotFound.Render(w, r)
+		return
+	}
+	if !ifMatch(r, versionETag(publisher.Version)) {
+		ErrPreconditionFailed.Render(w, r)
+		return
+	}
+	audit, err := newAuditEntry(r, entity.AuditEntityPublisher, publisher.UUID, entity.AuditActionRestore, nil, nil)
+	if err != nil {
+		ErrInternal(err).Render(w, r)
+		return
+	}
+	// Restored publications must be created in their backing services again
+	err = s.repository.RestorePublisher(r.Context(), publisher, audit, publicationsOutboxEvents(publicationCreate))
+	if errors.Is(err, entity.ErrVersionMismatch) {
+		ErrPreconditionFailed.Render(w, r)
+		return
+	}
+	// Restored publication can conflict with other publication of the publisher with the same name
+	var conflict *entity.ConflictError
+	if errors.As(err, &conflict) && conflict.EntityType == entity.AuditEntityPublication {
+		ErrPublicationAlreadyExists(err).Render(w, r)
+		return
+	}
+	if errors.Is(err, entity.ErrAlreadyExists) {
+		ErrPublisherAlreadyExists(err).Render(w, r)
+		return
+	}
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("Failure restoring publisher %v: %s", publisher, err))
+		ErrInternal(fmt.Errorf("Failure restoring publisher %v", publisher)).Render(w, r)
+		return
+	}
+	newPublisherResponse(publisher).Render(w, r)
+}
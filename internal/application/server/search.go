@@ -0,0 +1,65 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/Tarick/naca-publications/internal/spec"
+
+	"github.com/go-chi/render"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+const (
+	defaultSearchLimit int = 20
+	maxSearchLimit     int = 100
+)
+
+// searchQueryFromRequest reads search text, filters and limit query parameters
+func searchQueryFromRequest(r *http.Request) (entity.SearchQuery, error) {
+	params := r.URL.Query()
+	query := entity.SearchQuery{
+		Text:         params.Get("q"),
+		Type:         params.Get("type"),
+		LanguageCode: params.Get("language_code"),
+		Limit:        defaultSearchLimit,
+	}
+	if err := validation.Validate(query.Text, validation.Required, validation.RuneLength(2, 200)); err != nil {
+		return query, fmt.Errorf("invalid q parameter: %w", err)
+	}
+	switch query.Type {
+	case "", entity.SearchResultPublisher, entity.SearchResultPublication:
+	default:
+		return query, fmt.Errorf("invalid type parameter %s, must be %s or %s", query.Type, entity.SearchResultPublisher, entity.SearchResultPublication)
+	}
+	if err := validation.Validate(query.LanguageCode, validation.Length(2, 2), spec.IsLanguageCode); err != nil {
+		return query, fmt.Errorf("invalid language_code parameter %s: %w", query.LanguageCode, err)
+	}
+	if limitParam := params.Get("limit"); limitParam != "" {
+		limit, err := strconv.Atoi(limitParam)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			return query, fmt.Errorf("invalid limit parameter %s, must be between 1 and %d", limitParam, maxSearchLimit)
+		}
+		query.Limit = limit
+	}
+	return query, nil
+}
+
+// search returns publishers and publications matching query, most relevant first
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	query, err := searchQueryFromRequest(r)
+	if err != nil {
+		ErrInvalidRequest(err).Render(w, r)
+		return
+	}
+	results, err := s.repository.Search(r.Context(), query)
+	if err != nil {
+		s.logger.Error(fmt.Sprint("Failure searching: ", err))
+		ErrInternal(errors.New("Failure searching database")).Render(w, r)
+		return
+	}
+	render.JSON(w, r, results)
+}
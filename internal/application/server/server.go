@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -23,51 +24,111 @@ import (
 
 // Server defines HTTP application
 type Server struct {
-	httpServer        *http.Server
-	logger            Logger
-	repository        PublicationsRepository
-	rssFeedsAPIClient RSSFeedsAPIClient
+	httpServer *http.Server
+	logger     Logger
+	repository PublicationsRepository
+	cache      *responseCache
+	auth       AuthConfig
+	jwks       *jwks
+	// anonymous is identity of clients, when authentication is disabled
+	anonymous *identity
+	// rateLimiters of route groups
+	rateLimiters map[string]*rateLimiter
+	// trustedProxies are networks of reverse proxies, which client IP address is taken from X-Forwarded-For header of
+	trustedProxies []*net.IPNet
 }
 
 // PublicationsRepository represents repository for both publishers and publications
 type PublicationsRepository interface {
-	CreatePublication(context.Context, *entity.Publication) error
-	UpdatePublication(context.Context, *entity.Publication) error
-	DeletePublication(context.Context, uuid.UUID) error
+	CreatePublication(context.Context, *entity.Publication, *entity.AuditEntry, ...*entity.OutboxEvent) error
+	UpdatePublication(context.Context, *entity.Publication, *entity.AuditEntry, ...*entity.OutboxEvent) error
+	DeletePublication(context.Context, *entity.Publication, *entity.AuditEntry, ...*entity.OutboxEvent) error
+	RestorePublication(context.Context, *entity.Publication, *entity.AuditEntry, ...*entity.OutboxEvent) error
 	GetPublication(context.Context, uuid.UUID) (*entity.Publication, error)
+	GetDeletedPublication(context.Context, uuid.UUID) (*entity.Publication, error)
 	GetPublications(context.Context) ([]*entity.Publication, error)
+	GetPublicationsPage(context.Context, entity.PublicationsQuery) ([]*entity.Publication, error)
 	GetPublicationsByPublisher(context.Context, uuid.UUID) ([]*entity.Publication, error)
-	CreatePublisher(context.Context, *entity.Publisher) error
-	UpdatePublisher(context.Context, *entity.Publisher) error
-	DeletePublisher(context.Context, uuid.UUID) error
+	GetPublicationsState(context.Context) (entity.CollectionState, error)
+	CreatePublisher(context.Context, *entity.Publisher, *entity.AuditEntry) error
+	UpdatePublisher(context.Context, *entity.Publisher, *entity.AuditEntry) error
+	DeletePublisher(context.Context, *entity.Publisher, *entity.AuditEntry, entity.PublicationsOutboxEvents) error
+	RestorePublisher(context.Context, *entity.Publisher, *entity.AuditEntry, entity.PublicationsOutboxEvents) error
 	GetPublisher(context.Context, uuid.UUID) (*entity.Publisher, error)
+	GetDeletedPublisher(context.Context, uuid.UUID) (*entity.Publisher, error)
 	GetPublishers(context.Context) ([]*entity.Publisher, error)
+	GetPublishersPage(context.Context, entity.PublishersQuery) ([]*entity.Publisher, error)
+	GetPublishersState(context.Context) (entity.CollectionState, error)
+	Search(context.Context, entity.SearchQuery) ([]*entity.SearchResult, error)
+	GetAuditEntries(context.Context, entity.AuditQuery) ([]*entity.AuditEntry, error)
+	CreateAPIKey(context.Context, *entity.APIKey) error
+	GetAPIKeyByHash(context.Context, []byte) (*entity.APIKey, error)
+	GetAPIKeys(context.Context) ([]*entity.APIKey, error)
+	RevokeAPIKey(context.Context, uuid.UUID) error
 	Healthcheck(context.Context) error
 }
 
-// RSSFeedsAPIClient is used to call RSS Feeds service
-type RSSFeedsAPIClient interface {
-	CreateRSSFeed(context.Context, uuid.UUID, string, string) error
-	UpdateRSSFeed(context.Context, uuid.UUID, string, string) error
-	DeleteRSSFeed(context.Context, uuid.UUID) error
-}
-
 // Config defines webserver configuration
 type Config struct {
-	Address        string `mapstructure:"address"`
-	RequestTimeout int    `mapstructure:"request_timeout"`
+	Address string `mapstructure:"address"`
+	// RequestTimeout is deadline of handling reading requests in seconds, 0 disables it
+	RequestTimeout int `mapstructure:"request_timeout"`
+	// WriteRequestTimeout is deadline of handling changing requests in seconds, RequestTimeout by default
+	WriteRequestTimeout int `mapstructure:"write_request_timeout"`
+	// ReadTimeout, WriteTimeout and IdleTimeout are timeouts of http.Server connections in seconds.
+	// WriteTimeout is longest request timeout with margin by default, to let timed out request to respond.
+	ReadTimeout  int `mapstructure:"read_timeout"`
+	WriteTimeout int `mapstructure:"write_timeout"`
+	IdleTimeout  int `mapstructure:"idle_timeout"`
+	// CacheSize is maximum number of cached listings responses, 0 disables cache
+	CacheSize int `mapstructure:"cache_size"`
+	// CacheTTL is time in seconds for listings responses to be cached, 0 disables cache
+	CacheTTL int        `mapstructure:"cache_ttl"`
+	Auth     AuthConfig `mapstructure:"auth"`
+	// MaxBodySize is maximum size of request body in bytes, 1 MiB by default
+	MaxBodySize int64 `mapstructure:"max_body_size"`
+	// RateLimits of each client by route group: read, write, search, admin and auth. Groups without limit are not limited,
+	// except for auth.
+	RateLimits map[string]RateLimitConfig `mapstructure:"rate_limits"`
+	// TrustedProxies are IP addresses or CIDR networks of reverse proxies, which set X-Forwarded-For header.
+	// Header is ignored in requests from other addresses.
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
 }
 
 // New creates new server configuration and configurates middleware
-func New(serverConfig Config, logger Logger, repository PublicationsRepository, rssFeedsAPIClient RSSFeedsAPIClient) *Server {
+func New(serverConfig Config, logger Logger, repository PublicationsRepository) (*Server, error) {
 	r := chi.NewRouter()
+	readTimeout, writeTimeout, idleTimeout := httpServerTimeouts(serverConfig)
 	s := &Server{
-		httpServer:        &http.Server{Addr: serverConfig.Address, Handler: r},
-		logger:            logger,
-		repository:        repository,
-		rssFeedsAPIClient: rssFeedsAPIClient,
+		httpServer: &http.Server{
+			Addr:         serverConfig.Address,
+			Handler:      r,
+			ReadTimeout:  readTimeout,
+			WriteTimeout: writeTimeout,
+			IdleTimeout:  idleTimeout,
+		},
+		logger:     logger,
+		repository: repository,
+		cache:      newResponseCache(serverConfig.CacheSize, time.Duration(serverConfig.CacheTTL)*time.Second),
+		auth:       serverConfig.Auth,
+	}
+	var err error
+	if s.rateLimiters, err = newRateLimiters(serverConfig.RateLimits); err != nil {
+		return nil, err
+	}
+	if s.trustedProxies, err = parseTrustedProxies(serverConfig.TrustedProxies); err != nil {
+		return nil, err
+	}
+	if s.anonymous, err = newAnonymous(serverConfig.Auth); err != nil {
+		return nil, err
+	}
+	if serverConfig.Auth.Enabled && serverConfig.Auth.JWKSFile != "" {
+		if s.jwks, err = loadJWKS(serverConfig.Auth.JWKSFile); err != nil {
+			return nil, fmt.Errorf("failure loading JWKS file %s: %w", serverConfig.Auth.JWKSFile, err)
+		}
 	}
 	r.Use(middleware.RequestID)
+	r.Use(s.clientIP)
 	r.Use(middlewareLogger(logger))
 	// Basic CORS to allow API calls from browsers (Swagger-UI)
 	// for more ideas, see: https://developer.github.com/v3/#cross-origin-resource-sharing
@@ -77,15 +138,24 @@ func New(serverConfig Config, logger Logger, repository PublicationsRepository,
 		AllowedOrigins: []string{"*"},
 		// AllowOriginFunc:  func(r *http.Request, origin string) bool { return true },
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "If-Match", "If-None-Match", "If-Modified-Since"},
+		ExposedHeaders:   []string{"Link", "ETag", "Last-Modified", "WWW-Authenticate", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
 		AllowCredentials: false,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
 	}))
 	r.Use(middleware.AllowContentType("application/json"))
+	bodySize := serverConfig.MaxBodySize
+	if bodySize <= 0 {
+		bodySize = defaultMaxBodySize
+	}
+	r.Use(maxBodySize(bodySize))
 	r.Use(middleware.Recoverer)
 	r.Use(render.SetContentType(render.ContentTypeJSON))
-	// r.Use(middleware.Timeout(time.Duration(serverConfig.RequestTimeout) * time.Second))
+	writeRequestTimeout := serverConfig.WriteRequestTimeout
+	if writeRequestTimeout <= 0 {
+		writeRequestTimeout = serverConfig.RequestTimeout
+	}
+	r.Use(timeout(time.Duration(serverConfig.RequestTimeout)*time.Second, time.Duration(writeRequestTimeout)*time.Second))
 	// Healthcheck
 	// Could be moved back to middleware in case auth middleware meddling
 	r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
@@ -108,9 +178,103 @@ func New(serverConfig Config, logger Logger, repository PublicationsRepository,
 	workDir, _ := os.Getwd()
 	filesDir := http.Dir(filepath.Join(workDir, "swaggerui"))
 	FileServer(r, "/doc", filesDir)
-	r.Mount("/publishers", s.publishersRouter())
-	r.Mount("/publications", s.publicationsRouter())
-	return s
+	// API requires authentication, if it is enabled, and is rate limited by client.
+	// Requests are limited by IP address before authentication too, so that invalid credentials are not checked without limit.
+	r.Group(func(r chi.Router) {
+		r.Use(s.rateLimit(rateLimitAuth))
+		r.Use(s.authenticate)
+		r.Use(authorize)
+		// swagger:operation GET /publication-types getPublicationTypes
+		// Returns registered publication types with JSON Schemas of their configs
+		// ---
+		// responses:
+		//   '200':
+		//     description: list publication types
+		//     schema:
+		//       type: array
+		//       items:
+		//         $ref: "#/definitions/PublicationTypeResponseBody"
+		r.With(s.rateLimit(rateLimitRead)).Get("/publication-types", s.getPublicationTypes)
+		// swagger:operation GET /search search
+		// Searches publishers and publications by words in names and descriptions and by names similarity, most relevant first
+		// ---
+		// parameters:
+		//  - name: q
+		//    in: query
+		//    description: search text, 2 to 200 characters
+		//    required: true
+		//    type: string
+		//  - name: type
+		//    in: query
+		//    description: return only results of this type, publisher or publication
+		//    required: false
+		//    type: string
+		//  - name: language_code
+		//    in: query
+		//    description: return only publications with this two-letter language code
+		//    required: false
+		//    type: string
+		//  - name: limit
+		//    in: query
+		//    description: maximum number of results to return, 20 by default
+		//    required: false
+		//    type: integer
+		// responses:
+		//   '200':
+		//     description: search results
+		//     schema:
+		//       type: array
+		//       items:
+		//         $ref: "#/definitions/SearchResult"
+		//   default:
+		//     $ref: "#/responses/ErrResponse"
+		r.With(s.rateLimit(rateLimitSearch)).Get("/search", s.search)
+		// swagger:operation GET /audit getAudit
+		// Returns audit log of publishers and publications changes, paginated with cursor. Next page URL is in Link header.
+		// ---
+		// parameters:
+		//  - name: entity_type
+		//    in: query
+		//    description: return only entries of publisher or publication entities
+		//    required: false
+		//    type: string
+		//  - name: since
+		//    in: query
+		//    description: return only entries created at or after this RFC3339 time
+		//    required: false
+		//    type: string
+		//    format: date-time
+		//  - name: until
+		//    in: query
+		//    description: return only entries created before this RFC3339 time
+		//    required: false
+		//    type: string
+		//    format: date-time
+		//  - name: limit
+		//    in: query
+		//    description: maximum number of entries to return, 100 by default
+		//    required: false
+		//    type: integer
+		//  - name: cursor
+		//    in: query
+		//    description: opaque cursor to the next page, taken from Link header
+		//    required: false
+		//    type: string
+		// responses:
+		//   '200':
+		//     description: audit log entries page, oldest first
+		//     schema:
+		//       type: array
+		//       items:
+		//         $ref: "#/definitions/AuditEntry"
+		//   default:
+		//     $ref: "#/responses/ErrResponse"
+		r.With(s.rateLimit(rateLimitRead)).Get("/audit", s.getAudit)
+		r.With(s.rateLimitByMethod).Mount("/publishers", s.publishersRouter())
+		r.With(s.rateLimitByMethod).Mount("/publications", s.publicationsRouter())
+		r.With(requireRole(entity.RoleAdmin), s.rateLimit(rateLimitAdmin)).Mount("/api-keys", s.apiKeysRouter())
+	})
+	return s, nil
 }
 
 // StartAndServe starts http server with signal control
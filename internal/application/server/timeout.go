@@ -0,0 +1,69 @@
+package server
+
+// This file contains deadlines of requests handling. Repository queries use request context, so they're cancelled on deadline.
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+// Defaults of http.Server timeouts, used when they're not configured
+const (
+	defaultReadTimeout = 10 * time.Second
+	defaultIdleTimeout = 2 * time.Minute
+	// writeTimeoutMargin is added to longest request timeout, so timeout response is written before connection is closed
+	writeTimeoutMargin = 5 * time.Second
+)
+
+// timeout is a middleware, which sets deadline of reading and changing requests handling.
+// If handler hasn't responded before deadline, 504 is returned.
+func timeout(read time.Duration, write time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d := write
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				d = read
+			}
+			if d <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			r = r.WithContext(ctx)
+			next.ServeHTTP(ww, r)
+			if ww.Status() == 0 && ctx.Err() == context.DeadlineExceeded {
+				ErrGatewayTimeout.Render(ww, r)
+			}
+		})
+	}
+}
+
+// httpServerTimeouts returns read, write and idle timeouts of http.Server from configuration or defaults
+func httpServerTimeouts(config Config) (time.Duration, time.Duration, time.Duration) {
+	read, write, idle := time.Duration(config.ReadTimeout)*time.Second,
+		time.Duration(config.WriteTimeout)*time.Second,
+		time.Duration(config.IdleTimeout)*time.Second
+	if read <= 0 {
+		read = defaultReadTimeout
+	}
+	if write <= 0 {
+		write = time.Duration(config.RequestTimeout) * time.Second
+		if changes := time.Duration(config.WriteRequestTimeout) * time.Second; changes > write {
+			write = changes
+		}
+		// No request deadline - no write timeout as well
+		if write > 0 {
+			write += writeTimeoutMargin
+		}
+	}
+	if idle <= 0 {
+		idle = defaultIdleTimeout
+	}
+	return read, write, idle
+}
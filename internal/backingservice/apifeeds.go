@@ -0,0 +1,43 @@
+package backingservice
+
+import (
+	"context"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/gofrs/uuid"
+)
+
+// APIFeedsClient calls API feeds service, which fetches api publications from third-party APIs
+type APIFeedsClient struct {
+	client *client
+}
+
+// apiFeedRequestBody is API feed of publication
+type apiFeedRequestBody struct {
+	PublicationUUID uuid.UUID `json:"publication_uuid"`
+	entity.APIFeedPayload
+}
+
+// NewAPIFeedsClient creates client of API feeds service feeds collection at serviceURL
+func NewAPIFeedsClient(serviceURL string) (*APIFeedsClient, error) {
+	c, err := newClient(serviceURL)
+	if err != nil {
+		return nil, err
+	}
+	return &APIFeedsClient{client: c}, nil
+}
+
+// CreateAPIFeed creates API feed of publication, returns error wrapping entity.ErrAlreadyExists if it exists
+func (c *APIFeedsClient) CreateAPIFeed(ctx context.Context, publicationUUID uuid.UUID, payload entity.APIFeedPayload) error {
+	return c.client.create(ctx, apiFeedRequestBody{PublicationUUID: publicationUUID, APIFeedPayload: payload})
+}
+
+// UpdateAPIFeed updates API feed of publication, returns error wrapping entity.ErrNotFound if it doesn't exist
+func (c *APIFeedsClient) UpdateAPIFeed(ctx context.Context, publicationUUID uuid.UUID, payload entity.APIFeedPayload) error {
+	return c.client.update(ctx, publicationUUID, apiFeedRequestBody{PublicationUUID: publicationUUID, APIFeedPayload: payload})
+}
+
+// DeleteAPIFeed deletes API feed of publication, returns error wrapping entity.ErrNotFound if it doesn't exist
+func (c *APIFeedsClient) DeleteAPIFeed(ctx context.Context, publicationUUID uuid.UUID) error {
+	return c.client.delete(ctx, publicationUUID)
+}
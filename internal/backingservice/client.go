@@ -0,0 +1,101 @@
+package backingservice
+
+// This package contains clients of backing services of scrapped and api publication types.
+// Services keep one resource per publication in collection at service URL:
+// POST <url> creates it (201 Created, 409 Conflict if it exists), PUT <url>/<publication uuid> updates it (200 OK)
+// and DELETE <url>/<publication uuid> deletes it (204 No Content). Missing resources are 404 Not Found.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/gofrs/uuid"
+)
+
+// maxErrorBodySize limits error response body included in error
+const maxErrorBodySize = 512
+
+// client calls REST API of backing service collection
+type client struct {
+	collectionURL string
+	httpClient    *http.Client
+}
+
+func newClient(serviceURL string) (*client, error) {
+	u, err := url.Parse(serviceURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid backing service URL %s, must be http or https", serviceURL)
+	}
+	return &client{
+		collectionURL: strings.TrimSuffix(serviceURL, "/"),
+		httpClient: &http.Client{
+			Timeout: time.Minute,
+		}}, nil
+}
+
+// create posts resource of publication, returns error wrapping entity.ErrAlreadyExists if it exists
+func (c *client) create(ctx context.Context, body interface{}) error {
+	return c.do(ctx, http.MethodPost, c.collectionURL, body, http.StatusCreated)
+}
+
+// update puts resource of publication, returns error wrapping entity.ErrNotFound if it doesn't exist
+func (c *client) update(ctx context.Context, publicationUUID uuid.UUID, body interface{}) error {
+	return c.do(ctx, http.MethodPut, c.resourceURL(publicationUUID), body, http.StatusOK)
+}
+
+// delete deletes resource of publication, returns error wrapping entity.ErrNotFound if it doesn't exist
+func (c *client) delete(ctx context.Context, publicationUUID uuid.UUID) error {
+	return c.do(ctx, http.MethodDelete, c.resourceURL(publicationUUID), nil, http.StatusNoContent)
+}
+
+func (c *client) resourceURL(publicationUUID uuid.UUID) string {
+	return c.collectionURL + "/" + publicationUUID.String()
+}
+
+func (c *client) do(ctx context.Context, method string, url string, body interface{}, successStatus int) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, url, reqBody)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == successStatus {
+		return nil
+	}
+	errBody, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxErrorBodySize))
+	err = fmt.Errorf("%s %s failed with status %d: %s", method, url, res.StatusCode, bytes.TrimSpace(errBody))
+	switch res.StatusCode {
+	case http.StatusConflict:
+		return fmt.Errorf("%w: %s", entity.ErrAlreadyExists, err)
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: %s", entity.ErrNotFound, err)
+	}
+	return err
+}
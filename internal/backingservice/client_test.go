@@ -0,0 +1,116 @@
+package backingservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/gofrs/uuid"
+)
+
+func TestScrapperClient(t *testing.T) {
+	publicationUUID := uuid.Must(uuid.NewV4())
+	payload := entity.ScrapperPayload{StartURL: "https://example.com/news", ItemSelector: "li", TitleSelector: "a", LinkSelector: "a", Schedule: "0 * * * *", LanguageCode: "en"}
+	tests := []struct {
+		name       string
+		call       func(context.Context, *ScrapperClient) error
+		status     int
+		wantMethod string
+		wantPath   string
+		wantBody   bool
+		wantErr    error
+	}{
+		{name: "create", call: func(ctx context.Context, c *ScrapperClient) error {
+			return c.CreateScrapper(ctx, publicationUUID, payload)
+		},
+			status: http.StatusCreated, wantMethod: http.MethodPost, wantPath: "/scrappers", wantBody: true},
+		{name: "create existing", call: func(ctx context.Context, c *ScrapperClient) error {
+			return c.CreateScrapper(ctx, publicationUUID, payload)
+		},
+			status: http.StatusConflict, wantMethod: http.MethodPost, wantPath: "/scrappers", wantBody: true, wantErr: entity.ErrAlreadyExists},
+		{name: "update", call: func(ctx context.Context, c *ScrapperClient) error {
+			return c.UpdateScrapper(ctx, publicationUUID, payload)
+		},
+			status: http.StatusOK, wantMethod: http.MethodPut, wantPath: "/scrappers/" + publicationUUID.String(), wantBody: true},
+		{name: "update missing", call: func(ctx context.Context, c *ScrapperClient) error {
+			return c.UpdateScrapper(ctx, publicationUUID, payload)
+		},
+			status: http.StatusNotFound, wantMethod: http.MethodPut, wantPath: "/scrappers/" + publicationUUID.String(), wantBody: true, wantErr: entity.ErrNotFound},
+		{name: "delete", call: func(ctx context.Context, c *ScrapperClient) error { return c.DeleteScrapper(ctx, publicationUUID) },
+			status: http.StatusNoContent, wantMethod: http.MethodDelete, wantPath: "/scrappers/" + publicationUUID.String()},
+		{name: "delete missing", call: func(ctx context.Context, c *ScrapperClient) error { return c.DeleteScrapper(ctx, publicationUUID) },
+			status: http.StatusNotFound, wantMethod: http.MethodDelete, wantPath: "/scrappers/" + publicationUUID.String(), wantErr: entity.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != tt.wantMethod || r.URL.Path != tt.wantPath {
+					t.Errorf("request %s %s, want %s %s", r.Method, r.URL.Path, tt.wantMethod, tt.wantPath)
+				}
+				body, _ := ioutil.ReadAll(r.Body)
+				if tt.wantBody {
+					got := scrapperRequestBody{}
+					if err := json.Unmarshal(body, &got); err != nil {
+						t.Errorf("request body %s: %s", body, err)
+					}
+					if want := (scrapperRequestBody{PublicationUUID: publicationUUID, ScrapperPayload: payload}); got != want {
+						t.Errorf("request body %+v, want %+v", got, want)
+					}
+				} else if len(body) != 0 {
+					t.Errorf("request body %s, want none", body)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer service.Close()
+			client, err := NewScrapperClient(service.URL + "/scrappers/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = tt.call(context.Background(), client)
+			if (err == nil) != (tt.wantErr == nil) || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAPIFeedsClient(t *testing.T) {
+	publicationUUID := uuid.Must(uuid.NewV4())
+	payload := entity.APIFeedPayload{URL: "https://api.example.com/news", APIKeyRef: "vault/example", LanguageCode: "en"}
+	var got apiFeedRequestBody
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/feeds" {
+			t.Errorf("request %s %s, want POST /feeds", r.Method, r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("database is down"))
+	}))
+	defer service.Close()
+	client, err := NewAPIFeedsClient(service.URL + "/feeds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.CreateAPIFeed(context.Background(), publicationUUID, payload)
+	if err == nil || errors.Is(err, entity.ErrAlreadyExists) || errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("error = %v, want failure of status 500", err)
+	}
+	if want := (apiFeedRequestBody{PublicationUUID: publicationUUID, APIFeedPayload: payload}); got != want {
+		t.Errorf("request body %+v, want %+v", got, want)
+	}
+}
+
+func TestNewClientRejectsInvalidURL(t *testing.T) {
+	for _, serviceURL := range []string{"", "scrapper-api/scrappers", "ftp://scrapper-api/scrappers", "http://scrapper api/%"} {
+		if _, err := NewScrapperClient(serviceURL); err == nil {
+			t.Errorf("URL %q is accepted", serviceURL)
+		}
+	}
+}
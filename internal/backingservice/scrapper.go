@@ -0,0 +1,43 @@
+package backingservice
+
+import (
+	"context"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/gofrs/uuid"
+)
+
+// ScrapperClient calls web scrapping service, which scraps pages of scrapped publications by schedule
+type ScrapperClient struct {
+	client *client
+}
+
+// scrapperRequestBody is scrapper of publication
+type scrapperRequestBody struct {
+	PublicationUUID uuid.UUID `json:"publication_uuid"`
+	entity.ScrapperPayload
+}
+
+// NewScrapperClient creates client of web scrapping service scrappers collection at serviceURL
+func NewScrapperClient(serviceURL string) (*ScrapperClient, error) {
+	c, err := newClient(serviceURL)
+	if err != nil {
+		return nil, err
+	}
+	return &ScrapperClient{client: c}, nil
+}
+
+// CreateScrapper creates scrapper of publication, returns error wrapping entity.ErrAlreadyExists if it exists
+func (c *ScrapperClient) CreateScrapper(ctx context.Context, publicationUUID uuid.UUID, payload entity.ScrapperPayload) error {
+	return c.client.create(ctx, scrapperRequestBody{PublicationUUID: publicationUUID, ScrapperPayload: payload})
+}
+
+// UpdateScrapper updates scrapper of publication, returns error wrapping entity.ErrNotFound if it doesn't exist
+func (c *ScrapperClient) UpdateScrapper(ctx context.Context, publicationUUID uuid.UUID, payload entity.ScrapperPayload) error {
+	return c.client.update(ctx, publicationUUID, scrapperRequestBody{PublicationUUID: publicationUUID, ScrapperPayload: payload})
+}
+
+// DeleteScrapper deletes scrapper of publication, returns error wrapping entity.ErrNotFound if it doesn't exist
+func (c *ScrapperClient) DeleteScrapper(ctx context.Context, publicationUUID uuid.UUID) error {
+	return c.client.delete(ctx, publicationUUID)
+}
@@ -0,0 +1,72 @@
+package entity
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Roles of API clients, each role has permissions of the previous ones
+const (
+	// RoleReader can read publishers and publications
+	RoleReader string = "reader"
+	// RoleEditor can change publishers and publications
+	RoleEditor string = "editor"
+	// RoleAdmin can manage API keys
+	RoleAdmin string = "admin"
+)
+
+var roleLevels = map[string]int{RoleReader: 1, RoleEditor: 2, RoleAdmin: 3}
+
+// IsRole returns true if role is known
+func IsRole(role string) bool {
+	_, ok := roleLevels[role]
+	return ok
+}
+
+// RoleAllows returns true if role has permissions of required role
+func RoleAllows(role string, required string) bool {
+	return IsRole(role) && roleLevels[role] >= roleLevels[required]
+}
+
+// APIKeyPrefix distinguishes API keys from JWT bearer tokens
+const APIKeyPrefix string = "pak_"
+
+// APIKey authenticates API client with its role
+// swagger:model
+type APIKey struct {
+	UUID uuid.UUID `json:"uuid"`
+	// Name describes client of the key
+	Name string `json:"name"`
+	Role string `json:"role"`
+	// KeyHash is SHA-256 of the key, the key itself is not stored
+	KeyHash   []byte     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// NewAPIKey creates APIKey with new random key, which is returned only here
+func NewAPIKey(name string, role string) (*APIKey, string, error) {
+	var err error
+	k := &APIKey{Name: name, Role: role}
+	if k.UUID, err = uuid.NewV4(); err != nil {
+		return nil, "", err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	key := APIKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	k.KeyHash = HashAPIKey(key)
+	return k, key, nil
+}
+
+// HashAPIKey returns hash of the key to be stored and searched for.
+// Keys are random, so fast hash is enough to protect them.
+func HashAPIKey(key string) []byte {
+	hash := sha256.Sum256([]byte(key))
+	return hash[:]
+}
@@ -0,0 +1,132 @@
+package entity
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Audited entity types
+const (
+	AuditEntityPublisher   string = "publisher"
+	AuditEntityPublication string = "publication"
+)
+
+// Audited actions
+const (
+	AuditActionCreate  string = "create"
+	AuditActionUpdate  string = "update"
+	AuditActionDelete  string = "delete"
+	AuditActionRestore string = "restore"
+)
+
+// auditIgnoredFields are maintained by db and are not part of changes
+var auditIgnoredFields = map[string]bool{"created_at": true, "modified_at": true, "deleted_at": true}
+
+// AuditEntry records single change of publisher or publication
+// swagger:model
+type AuditEntry struct {
+	ID         int64     `json:"id"`
+	EntityType string    `json:"entity_type"`
+	EntityUUID uuid.UUID `json:"entity_uuid"`
+	Action     string    `json:"action"`
+	// Actor is the identity, which made the change
+	Actor string `json:"actor"`
+	// RequestID is the ID of API request, which made the change
+	RequestID string `json:"request_id"`
+	// Changes maps changed fields to their values before and after the change.
+	// Created entity has all fields without values before, deleted entity has all fields without values after.
+	// Restoration and deletion together with publisher have no changes.
+	Changes   map[string]AuditChange `json:"changes"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// AuditChange is the change of field value, null if field is absent
+type AuditChange struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// AuditQuery defines filtering of audit log. Empty fields are not filtered on.
+type AuditQuery struct {
+	Limit int
+	// AfterID is the ID of the last entry of previous page, entries are ordered by ID
+	AfterID    int64
+	EntityType string
+	EntityUUID uuid.UUID
+	// Since and Until limit entries creation time, Until is exclusive
+	Since time.Time
+	Until time.Time
+}
+
+// NewAuditEntry creates AuditEntry with changes between JSON representations of entity before and after the action, nil means absent entity
+func NewAuditEntry(entityType string, entityUUID uuid.UUID, action string, actor string, requestID string, before interface{}, after interface{}) (*AuditEntry, error) {
+	beforeFields, err := auditFields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := auditFields(after)
+	if err != nil {
+		return nil, err
+	}
+	changes := map[string]AuditChange{}
+	for field, value := range beforeFields {
+		equal, err := jsonEqual(value, afterFields[field])
+		if err != nil {
+			return nil, err
+		}
+		if !equal {
+			changes[field] = AuditChange{Before: value, After: afterFields[field]}
+		}
+	}
+	for field, value := range afterFields {
+		if _, ok := beforeFields[field]; !ok {
+			changes[field] = AuditChange{After: value}
+		}
+	}
+	return &AuditEntry{
+		EntityType: entityType,
+		EntityUUID: entityUUID,
+		Action:     action,
+		Actor:      actor,
+		RequestID:  requestID,
+		Changes:    changes,
+	}, nil
+}
+
+// jsonEqual compares JSON values semantically, ignoring whitespace and order of object keys,
+// e.g. config normalized by jsonb is equal to the requested one. Absent value is not equal to any value.
+func jsonEqual(a json.RawMessage, b json.RawMessage) (bool, error) {
+	if a == nil || b == nil {
+		return a == nil && b == nil, nil
+	}
+	var aValue, bValue interface{}
+	if err := json.Unmarshal(a, &aValue); err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(b, &bValue); err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(aValue, bValue), nil
+}
+
+// auditFields returns JSON fields of entity, except for ignored ones
+func auditFields(v interface{}) (map[string]json.RawMessage, error) {
+	fields := map[string]json.RawMessage{}
+	if v == nil {
+		return fields, nil
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	for field := range auditIgnoredFields {
+		delete(fields, field)
+	}
+	return fields, nil
+}
@@ -0,0 +1,34 @@
+package entity
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gofrs/uuid"
+)
+
+// ErrVersionMismatch is returned when entity was changed by someone else since it was read
+var ErrVersionMismatch = errors.New("entity version mismatch")
+
+// ErrAlreadyExists is returned when entity with the same unique fields exists
+var ErrAlreadyExists = errors.New("entity already exists")
+
+// ErrNotFound is returned when entity to be changed doesn't exist
+var ErrNotFound = errors.New("entity not found")
+
+// ConflictError is ErrAlreadyExists with UUID of the existing entity, which conflicts with created or changed one
+type ConflictError struct {
+	// UUID is nil, if the existing entity couldn't be found, e.g. it was deleted since conflict
+	UUID uuid.UUID
+	// EntityType of the existing entity, AuditEntityPublisher or AuditEntityPublication
+	EntityType string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrAlreadyExists, e.UUID)
+}
+
+// Is makes ConflictError match ErrAlreadyExists
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrAlreadyExists
+}
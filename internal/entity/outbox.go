@@ -0,0 +1,86 @@
+package entity
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gofrs/uuid"
+)
+
+// Changes of publications, delivered as outbox events to backing service of publication type
+const (
+	OutboxChangeCreated string = "created"
+	OutboxChangeUpdated string = "updated"
+	OutboxChangeDeleted string = "deleted"
+)
+
+// PublicationOutboxEventType returns type of outbox event of publication change: publication type name and change, e.g. rss.created
+func PublicationOutboxEventType(publicationType string, change string) string {
+	return publicationType + "." + change
+}
+
+// PublicationsOutboxEvents returns outbox events of publications changed together with their publisher.
+// Repository calls it with the changed publications inside of the transaction of the change.
+type PublicationsOutboxEvents func([]*Publication) ([]*OutboxEvent, error)
+
+// OutboxEvent is a change to be delivered to downstream service, stored together with the change itself
+type OutboxEvent struct {
+	ID int64
+	// AggregateUUID is UUID of changed entity, events of the same entity are delivered in order
+	AggregateUUID uuid.UUID
+	Type          string
+	Payload       json.RawMessage
+	Attempts      int
+	LastError     string
+}
+
+func (e *OutboxEvent) String() string {
+	return fmt.Sprintf("{ID: %v, AggregateUUID: %v, Type: %v, Payload: %s, Attempts: %v}", e.ID, e.AggregateUUID, e.Type, e.Payload, e.Attempts)
+}
+
+// PublicationChange returns publication type name and change of event, events are delivered by publication type
+func (e *OutboxEvent) PublicationChange() (string, string) {
+	i := strings.LastIndex(e.Type, ".")
+	if i < 0 {
+		return e.Type, ""
+	}
+	return e.Type[:i], e.Type[i+1:]
+}
+
+// RSSFeedPayload is payload of RSS Feed outbox events
+type RSSFeedPayload struct {
+	URL          string `json:"url,omitempty"`
+	LanguageCode string `json:"language_code,omitempty"`
+}
+
+// ScrapperPayload is payload of web scrapper outbox events
+type ScrapperPayload struct {
+	StartURL      string `json:"start_url,omitempty"`
+	ItemSelector  string `json:"item_selector,omitempty"`
+	TitleSelector string `json:"title_selector,omitempty"`
+	LinkSelector  string `json:"link_selector,omitempty"`
+	Schedule      string `json:"schedule,omitempty"`
+	LanguageCode  string `json:"language_code,omitempty"`
+}
+
+// APIFeedPayload is payload of API feed outbox events
+type APIFeedPayload struct {
+	URL string `json:"url,omitempty"`
+	// APIKeyRef is a reference to API key in secrets storage, not the key itself
+	APIKeyRef    string `json:"api_key_ref,omitempty"`
+	LanguageCode string `json:"language_code,omitempty"`
+}
+
+// NewOutboxEvent creates OutboxEvent with payload marshalled to json
+func NewOutboxEvent(eventType string, aggregateUUID uuid.UUID, payload interface{}) (*OutboxEvent, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &OutboxEvent{
+		AggregateUUID: aggregateUUID,
+		Type:          eventType,
+		Payload:       body,
+	}, nil
+}
@@ -0,0 +1,58 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Page defines keyset pagination parameters for listing entities.
+// Entities are ordered by sort field and UUID, After is the UUID of the last entity of previous page (uuid.Nil for the first page)
+// and AfterValue is its sort field value (nil when sorted by UUID only).
+type Page struct {
+	Limit      int
+	After      uuid.UUID
+	AfterValue interface{}
+}
+
+// Publications listing sort fields
+const (
+	PublicationsSortByName       string = "name"
+	PublicationsSortByCreatedAt  string = "created_at"
+	PublicationsSortByModifiedAt string = "modified_at"
+)
+
+// PublishersQuery defines filtering of publishers listing. Empty fields are not filtered on.
+type PublishersQuery struct {
+	Page
+	// UpdatedSince returns only entities modified after this time
+	UpdatedSince time.Time
+	// IncludeDeleted returns deleted entities too
+	IncludeDeleted bool
+}
+
+// PublicationsQuery defines filtering and sorting of publications listing. Empty fields are not filtered on.
+type PublicationsQuery struct {
+	Page
+	// UpdatedSince returns only entities modified after this time
+	UpdatedSince  time.Time
+	PublisherUUID uuid.UUID
+	Type          string
+	LanguageCode  string
+	// Name substring, case insensitive
+	Name string
+	// Sort is one of PublicationsSortBy* fields, or empty to sort by UUID
+	Sort       string
+	Descending bool
+	// IncludeDeleted returns deleted entities too
+	IncludeDeleted bool
+}
+
+// CollectionState summarizes entities table, it changes whenever entity is created, modified or deleted.
+// It is used as cheap validator of listing responses.
+type CollectionState struct {
+	// Change is incremented by every change of entities, it never goes back
+	Change int64
+	// LastModified is the time of the latest change, zero if entities have never been changed
+	LastModified time.Time
+}
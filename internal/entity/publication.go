@@ -1,7 +1,9 @@
 package entity
 
 import (
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/gofrs/uuid"
 )
@@ -15,11 +17,20 @@ type Publication struct {
 	LanguageCode  string    `json:"language_code"`
 	PublisherUUID uuid.UUID `json:"publisher_uuid"`
 	Type          string    `json:"publication_type"`
+	// Config is publication type specific configuration, e.g. RSS feed URL
+	Config json.RawMessage `json:"config,omitempty"`
+	// Timestamps are maintained by db
+	CreatedAt  time.Time `json:"created_at"`
+	ModifiedAt time.Time `json:"modified_at"`
+	// Version is incremented on every update, used for optimistic concurrency control
+	Version int `json:"-"`
+	// DeletedAt is set for deleted entities, which can be restored until purged
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 func (p *Publication) String() string {
-	return fmt.Sprintf("{UUID: %v, Name: %v, Description: %v, LanguageCode: %v, PublisherUUID: %v, Type: %v}",
-		p.UUID, p.Name, p.Description, p.LanguageCode, p.PublisherUUID, p.Type)
+	return fmt.Sprintf("{UUID: %v, Name: %v, Description: %v, LanguageCode: %v, PublisherUUID: %v, Type: %v, Config: %s}",
+		p.UUID, p.Name, p.Description, p.LanguageCode, p.PublisherUUID, p.Type, p.Config)
 }
 
 // NewPublication creates Publication with new UUID
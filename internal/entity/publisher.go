@@ -2,6 +2,7 @@ package entity
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/gofrs/uuid"
 )
@@ -12,6 +13,13 @@ type Publisher struct {
 	UUID uuid.UUID `json:"uuid"`
 	Name string    `json:"name"`
 	URL  string    `json:"url"`
+	// Timestamps are maintained by db
+	CreatedAt  time.Time `json:"created_at"`
+	ModifiedAt time.Time `json:"modified_at"`
+	// Version is incremented on every update, used for optimistic concurrency control
+	Version int `json:"-"`
+	// DeletedAt is set for deleted entities, which can be restored until purged
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 func (p *Publisher) String() string {
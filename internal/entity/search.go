@@ -0,0 +1,37 @@
+package entity
+
+import (
+	"github.com/gofrs/uuid"
+)
+
+// Search result types
+const (
+	SearchResultPublisher   string = "publisher"
+	SearchResultPublication string = "publication"
+)
+
+// SearchQuery defines search of publishers and publications. Empty filters are not filtered on.
+type SearchQuery struct {
+	// Text is searched by words and by similarity to names
+	Text string
+	// Type is one of SearchResult* types
+	Type string
+	// LanguageCode filters publications, publishers have no language and are not returned
+	LanguageCode string
+	Limit        int
+}
+
+// SearchResult is found publisher or publication
+// swagger:model
+type SearchResult struct {
+	// Type is publisher or publication
+	Type         string    `json:"type"`
+	UUID         uuid.UUID `json:"uuid"`
+	Name         string    `json:"name"`
+	LanguageCode string    `json:"language_code,omitempty"`
+	// Rank is relevance of result, results are ordered by it
+	Rank float64 `json:"rank"`
+	// Highlights are matched fields fragments by field name, matched words are wrapped in <mark></mark>.
+	// Fragments are HTML escaped, so they can be inserted into HTML as is.
+	Highlights map[string]string `json:"highlights,omitempty"`
+}
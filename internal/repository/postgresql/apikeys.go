@@ -0,0 +1,63 @@
+package postgresql
+
+import (
+	"context"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+// CreateAPIKey inserts new API key into db
+func (repo *Repository) CreateAPIKey(ctx context.Context, k *entity.APIKey) error {
+	return repo.pool.QueryRow(ctx, "insert into api_keys (uuid, name, role, key_hash) values ($1, $2, $3, $4) returning created_at", k.UUID, k.Name, k.Role, k.KeyHash).
+		Scan(&k.CreatedAt)
+}
+
+// GetAPIKeyByHash returns not revoked APIKey with the key hash from db
+func (repo *Repository) GetAPIKeyByHash(ctx context.Context, keyHash []byte) (*entity.APIKey, error) {
+	k := &entity.APIKey{}
+	err := repo.pool.QueryRow(ctx, "select uuid, name, role, key_hash, created_at, revoked_at from api_keys where key_hash=$1 and revoked_at is null", keyHash).
+		Scan(&k.UUID, &k.Name, &k.Role, &k.KeyHash, &k.CreatedAt, &k.RevokedAt)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// GetAPIKeys returns list of APIKey from db, including revoked ones
+func (repo *Repository) GetAPIKeys(ctx context.Context) ([]*entity.APIKey, error) {
+	rows, err := repo.pool.Query(ctx, "select uuid, name, role, key_hash, created_at, revoked_at from api_keys order by created_at")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []*entity.APIKey{}
+	for rows.Next() {
+		k := &entity.APIKey{}
+		if err := rows.Scan(&k.UUID, &k.Name, &k.Role, &k.KeyHash, &k.CreatedAt, &k.RevokedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey marks API key as revoked, it can't be used afterwards. Returns entity.ErrNotFound if there is no such not revoked key.
+func (repo *Repository) RevokeAPIKey(ctx context.Context, keyUUID uuid.UUID) error {
+	result, err := repo.pool.Exec(ctx, "update api_keys set revoked_at=now() where uuid=$1 and revoked_at is null", keyUUID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() != 1 {
+		return entity.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+)
+
+func TestRevokeAPIKey(t *testing.T) {
+	repo := newTestRepository(t, "008_api_keys.sql")
+	ctx := context.Background()
+	revoked, revokedKey, err := entity.NewAPIKey("revoked", entity.RoleEditor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, keptKey, err := entity.NewAPIKey("kept", entity.RoleReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []*entity.APIKey{revoked, kept} {
+		if err := repo.CreateAPIKey(ctx, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if k, err := repo.GetAPIKeyByHash(ctx, entity.HashAPIKey(revokedKey)); err != nil || k == nil || k.UUID != revoked.UUID {
+		t.Fatalf("key before revocation = %+v, %v", k, err)
+	}
+	if err := repo.RevokeAPIKey(ctx, revoked.UUID); err != nil {
+		t.Fatal(err)
+	}
+	if k, err := repo.GetAPIKeyByHash(ctx, entity.HashAPIKey(revokedKey)); err != nil || k != nil {
+		t.Errorf("revoked key = %+v, %v, want none", k, err)
+	}
+	if k, err := repo.GetAPIKeyByHash(ctx, entity.HashAPIKey(keptKey)); err != nil || k == nil || k.UUID != kept.UUID {
+		t.Errorf("not revoked key = %+v, %v", k, err)
+	}
+	if err := repo.RevokeAPIKey(ctx, revoked.UUID); !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("repeated revocation error = %v, want %v", err, entity.ErrNotFound)
+	}
+	keys, err := repo.GetAPIKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].RevokedAt == nil || keys[1].RevokedAt != nil {
+		t.Errorf("keys = %+v, want revoked and not revoked keys", keys)
+	}
+}
@@ -0,0 +1,70 @@
+package postgresql
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+// insertAuditEntry adds entry to audit log inside of the transaction of the change
+func insertAuditEntry(ctx context.Context, tx pgx.Tx, e *entity.AuditEntry) error {
+	return tx.QueryRow(ctx, "insert into audit_log (entity_type, entity_uuid, action, actor, request_id, changes) values ($1, $2, $3, $4, $5, $6) returning id, created_at",
+		e.EntityType, e.EntityUUID, e.Action, e.Actor, e.RequestID, e.Changes).Scan(&e.ID, &e.CreatedAt)
+}
+
+// insertPublisherPublicationsAuditEntries adds entries of publisher action to audit log for its publications deleted at the time,
+// since they're deleted and restored together with publisher
+func insertPublisherPublicationsAuditEntries(ctx context.Context, tx pgx.Tx, e *entity.AuditEntry, deletedAt *time.Time) error {
+	_, err := tx.Exec(ctx, `insert into audit_log (entity_type, entity_uuid, action, actor, request_id)
+		select $1, uuid, $2, $3, $4 from publications where publisher_uuid=$5 and deleted_at=$6`,
+		entity.AuditEntityPublication, e.Action, e.Actor, e.RequestID, e.EntityUUID, deletedAt)
+	return err
+}
+
+// GetAuditEntries returns audit log entries filtered according to query, ordered by ID
+func (repo *Repository) GetAuditEntries(ctx context.Context, query entity.AuditQuery) ([]*entity.AuditEntry, error) {
+	var args []interface{}
+	// arg adds query argument and returns its placeholder
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	conditions := []string{"id > " + arg(query.AfterID)}
+	if query.EntityType != "" {
+		conditions = append(conditions, "entity_type = "+arg(query.EntityType))
+	}
+	if query.EntityUUID != uuid.Nil {
+		conditions = append(conditions, "entity_uuid = "+arg(query.EntityUUID))
+	}
+	if !query.Since.IsZero() {
+		conditions = append(conditions, "created_at >= "+arg(query.Since))
+	}
+	if !query.Until.IsZero() {
+		conditions = append(conditions, "created_at < "+arg(query.Until))
+	}
+	sql := "select id, entity_type, entity_uuid, action, actor, request_id, changes, created_at from audit_log where " + strings.Join(conditions, " and ") +
+		" order by id limit " + arg(query.Limit)
+	rows, err := repo.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []*entity.AuditEntry{}
+	for rows.Next() {
+		e := &entity.AuditEntry{}
+		if err := rows.Scan(&e.ID, &e.EntityType, &e.EntityUUID, &e.Action, &e.Actor, &e.RequestID, &e.Changes, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,117 @@
+package postgresql
+
+import (
+	"context"
+	"time"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+// insertOutboxEvents adds events to outbox inside of the transaction of the change
+func insertOutboxEvents(ctx context.Context, tx pgx.Tx, events []*entity.OutboxEvent) error {
+	for _, e := range events {
+		if err := tx.QueryRow(ctx, "insert into outbox (aggregate_uuid, event_type, payload) values ($1, $2, $3) returning id",
+			e.AggregateUUID, e.Type, e.Payload).Scan(&e.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertPublicationsOutboxEvents adds outbox events of publications changed together with their publisher
+func insertPublicationsOutboxEvents(ctx context.Context, tx pgx.Tx, events entity.PublicationsOutboxEvents, publications []*entity.Publication) error {
+	publicationsEvents, err := events(publications)
+	if err != nil {
+		return err
+	}
+	return insertOutboxEvents(ctx, tx, publicationsEvents)
+}
+
+// inTx runs fn in transaction, which is committed if fn succeeds
+func (repo *Repository) inTx(ctx context.Context, fn func(pgx.Tx) error) error {
+	tx, err := repo.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ClaimOutboxEvents returns pending outbox events due for delivery and postpones their next attempt for lease duration,
+// so concurrent dispatchers don't get them. Events are returned only if there are no earlier pending events of the same aggregate.
+func (repo *Repository) ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]*entity.OutboxEvent, error) {
+	rows, err := repo.pool.Query(ctx, `update outbox set next_attempt_at = now() + $1::interval where id in (
+		select id from outbox o where dispatched_at is null and dead_lettered_at is null and next_attempt_at <= now()
+		and not exists (select 1 from outbox p where p.aggregate_uuid = o.aggregate_uuid and p.id < o.id and p.dispatched_at is null and p.dead_lettered_at is null)
+		order by id limit $2 for update skip locked)
+		returning id, aggregate_uuid, event_type, payload, attempts, last_error`, lease, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []*entity.OutboxEvent{}
+	for rows.Next() {
+		e := &entity.OutboxEvent{}
+		if err := rows.Scan(&e.ID, &e.AggregateUUID, &e.Type, &e.Payload, &e.Attempts, &e.LastError); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// MarkOutboxEventDispatched records successful delivery of event
+func (repo *Repository) MarkOutboxEventDispatched(ctx context.Context, e *entity.OutboxEvent) error {
+	_, err := repo.pool.Exec(ctx, "update outbox set dispatched_at = now(), attempts = $1 where id = $2", e.Attempts, e.ID)
+	return err
+}
+
+// MarkOutboxEventFailed records failed delivery attempt of event and schedules the next one
+func (repo *Repository) MarkOutboxEventFailed(ctx context.Context, e *entity.OutboxEvent, nextAttemptAt time.Time) error {
+	_, err := repo.pool.Exec(ctx, "update outbox set attempts = $1, last_error = $2, next_attempt_at = $3 where id = $4", e.Attempts, e.LastError, nextAttemptAt, e.ID)
+	return err
+}
+
+// DeadLetterOutboxEvent stops delivery attempts of event, keeping it in outbox for investigation
+func (repo *Repository) DeadLetterOutboxEvent(ctx context.Context, e *entity.OutboxEvent) error {
+	_, err := repo.pool.Exec(ctx, "update outbox set attempts = $1, last_error = $2, dead_lettered_at = now() where id = $3", e.Attempts, e.LastError, e.ID)
+	return err
+}
+
+// CountOutboxEvents returns number of pending and dead lettered outbox events
+func (repo *Repository) CountOutboxEvents(ctx context.Context) (pending int, deadLettered int, err error) {
+	err = repo.pool.QueryRow(ctx, `select count(*) filter (where dispatched_at is null and dead_lettered_at is null),
+		count(*) filter (where dead_lettered_at is not null) from outbox`).Scan(&pending, &deadLettered)
+	return pending, deadLettered, err
+}
+
+// GetPendingOutboxAggregates returns UUIDs of entities with outbox events waiting for delivery or dispatched since the time
+func (repo *Repository) GetPendingOutboxAggregates(ctx context.Context, dispatchedSince time.Time) ([]uuid.UUID, error) {
+	rows, err := repo.pool.Query(ctx, "select distinct aggregate_uuid from outbox where (dispatched_at is null and dead_lettered_at is null) or dispatched_at >= $1",
+		dispatchedSince)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	aggregates := []uuid.UUID{}
+	for rows.Next() {
+		var u uuid.UUID
+		if err := rows.Scan(&u); err != nil {
+			return nil, err
+		}
+		aggregates = append(aggregates, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return aggregates, nil
+}
@@ -0,0 +1,127 @@
+package postgresql
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// newTestRepository connects to database of PUBLICATIONS_TEST_DATABASE_URL and creates schema of the migrations in new db schema,
+// which is dropped after test. Test is skipped, if database is not configured.
+func newTestRepository(t *testing.T, migrations ...string) *Repository {
+	t.Helper()
+	databaseURL := os.Getenv("PUBLICATIONS_TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("PUBLICATIONS_TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+	schema := "test_" + strings.ReplaceAll(uuid.Must(uuid.NewV4()).String(), "-", "")
+	config, err := pgxpool.ParseConfig(databaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ConnConfig.RuntimeParams["search_path"] = schema
+	pool, err := pgxpool.ConnectConfig(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		pool.Exec(ctx, "drop schema "+schema+" cascade")
+		pool.Close()
+	})
+	if _, err := pool.Exec(ctx, "create schema "+schema); err != nil {
+		t.Fatal(err)
+	}
+	for _, migration := range migrations {
+		sql, err := ioutil.ReadFile(filepath.Join("..", "..", "..", "migrations", "migrations", migration))
+		if err != nil {
+			t.Fatal(err)
+		}
+		create := strings.SplitN(string(sql), "---- create above / drop below ----", 2)[0]
+		if _, err := pool.Exec(ctx, create); err != nil {
+			t.Fatalf("failure applying migration %s: %s", migration, err)
+		}
+	}
+	return &Repository{pool: pool}
+}
+
+func TestClaimOutboxEvents(t *testing.T) {
+	repo := newTestRepository(t, "003_outbox.sql")
+	ctx := context.Background()
+	first, second := uuid.Must(uuid.NewV4()), uuid.Must(uuid.NewV4())
+	insert := func(aggregateUUID uuid.UUID, eventType string) *entity.OutboxEvent {
+		t.Helper()
+		e := &entity.OutboxEvent{AggregateUUID: aggregateUUID, Type: eventType, Payload: []byte(`{}`)}
+		if err := repo.pool.QueryRow(ctx, "insert into outbox (aggregate_uuid, event_type, payload) values ($1, $2, $3) returning id",
+			e.AggregateUUID, e.Type, e.Payload).Scan(&e.ID); err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	claim := func(limit int, want ...*entity.OutboxEvent) []*entity.OutboxEvent {
+		t.Helper()
+		events, err := repo.ClaimOutboxEvents(ctx, limit, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, wanted := []string{}, []string{}
+		for _, e := range events {
+			got = append(got, fmt.Sprint(e.ID))
+		}
+		for _, e := range want {
+			wanted = append(wanted, fmt.Sprint(e.ID))
+		}
+		if strings.Join(got, ",") != strings.Join(wanted, ",") {
+			t.Fatalf("claimed events %v, want %v", got, wanted)
+		}
+		return events
+	}
+
+	firstCreated := insert(first, "rss.created")
+	firstUpdated := insert(first, "rss.updated")
+	secondCreated := insert(second, "rss.created")
+	secondUpdated := insert(second, "rss.updated")
+
+	// Events wait for earlier pending events of their aggregate and are returned in order
+	claim(1, firstCreated)
+	claim(10, secondCreated)
+	// Claimed events are leased, later events still wait for them
+	claim(10)
+
+	if err := repo.MarkOutboxEventDispatched(ctx, firstCreated); err != nil {
+		t.Fatal(err)
+	}
+	secondCreated.Attempts, secondCreated.LastError = 3, "failed"
+	if err := repo.DeadLetterOutboxEvent(ctx, secondCreated); err != nil {
+		t.Fatal(err)
+	}
+	// Dispatched and dead lettered events don't block later events
+	claim(10, firstUpdated, secondUpdated)
+
+	// Failed event is claimed again when its next attempt is due
+	secondUpdated.Attempts, secondUpdated.LastError = 1, "failed"
+	if err := repo.MarkOutboxEventFailed(ctx, secondUpdated, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	events := claim(10, secondUpdated)
+	if events[0].Attempts != 1 || events[0].LastError != "failed" {
+		t.Errorf("claimed event %v has attempts %d and last error %q, want 1 and failed", events[0], events[0].Attempts, events[0].LastError)
+	}
+
+	pending, deadLettered, err := repo.CountOutboxEvents(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending != 2 || deadLettered != 1 {
+		t.Errorf("counted %d pending and %d dead lettered events, want 2 and 1", pending, deadLettered)
+	}
+}
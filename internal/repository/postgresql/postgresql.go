@@ -2,8 +2,14 @@ package postgresql
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/Tarick/naca-publications/internal/entity"
+
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/log/zapadapter"
 	"github.com/jackc/pgx/v4/pgxpool"
@@ -28,6 +34,9 @@ type Config struct {
 	MaxConnections int32  `mapstructure:"max_connections"`
 }
 
+// likeEscaper escapes LIKE pattern special characters in user supplied substrings
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
 func NewZapLogger(logger *zap.Logger) *zapadapter.Logger {
 	return zapadapter.NewLogger(logger)
 }
@@ -62,3 +71,44 @@ func New(databaseConfig *Config, logger pgx.Logger) (*Repository, error) {
 	}
 	return &Repository{pool: pool}, nil
 }
+
+// uniqueViolation is SQLSTATE of unique constraint violation
+const uniqueViolation = "23505"
+
+// violatedConstraint returns name of unique constraint or index, if err is unique violation
+func violatedConstraint(err error) (string, bool) {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != uniqueViolation {
+		return "", false
+	}
+	return pgErr.ConstraintName, true
+}
+
+// conflictError returns entity.ConflictError for unique violation err, with UUID of existing entity of entityType found by query.
+// Other errors are returned as is. Query runs outside of failed transaction, so it sees committed conflicting entity.
+func (repo *Repository) conflictError(ctx context.Context, err error, entityType string, query string, args ...interface{}) error {
+	if _, ok := violatedConstraint(err); !ok {
+		return err
+	}
+	conflict := &entity.ConflictError{EntityType: entityType}
+	if err := repo.pool.QueryRow(ctx, query, args...).Scan(&conflict.UUID); err != nil && err != pgx.ErrNoRows {
+		return err
+	}
+	return conflict
+}
+
+// getCollectionState returns change counter of table, which is incremented by trigger in transaction of each change.
+// Counter row serializes writers of the table till commit (see 010_collection_changes migration), so transactions
+// changing publishers and publications are kept short.
+func (repo *Repository) getCollectionState(ctx context.Context, table string) (entity.CollectionState, error) {
+	state := entity.CollectionState{}
+	var lastModified *time.Time
+	if err := repo.pool.QueryRow(ctx, "select change, modified_at from collection_changes where collection = $1", table).
+		Scan(&state.Change, &lastModified); err != nil {
+		return state, err
+	}
+	if lastModified != nil {
+		state.LastModified = *lastModified
+	}
+	return state, nil
+}
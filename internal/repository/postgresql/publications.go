@@ -2,8 +2,8 @@ package postgresql
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/Tarick/naca-publications/internal/entity"
 
@@ -11,52 +11,115 @@ import (
 	"github.com/jackc/pgx/v4"
 )
 
-// CreatePublication inserts new publisher into db
-func (repo *Repository) CreatePublication(ctx context.Context, p *entity.Publication) error {
-	if repo.publicationExists(ctx, p) {
-		return errors.New("publication already exists")
-	}
-	_, err := repo.pool.Exec(ctx, "insert into publications (uuid, name, description, type, publisher_uuid, language_code) values ($1, $2, $3, $4, $5, $6)",
-		p.UUID, p.Name, p.Description, p.Type, p.PublisherUUID, p.LanguageCode)
-	return err
+// publicationConflictQuery finds other not deleted publication of the publisher with the same name
+const publicationConflictQuery = "select uuid from publications where publisher_uuid=$1 and name=$2 and uuid<>$3 and deleted_at is null limit 1"
+
+// publicationColumns are selected to scan them with scanPublications
+const publicationColumns = "uuid, name, description, language_code, publisher_uuid, type, config, created_at, modified_at, version, deleted_at"
+
+// CreatePublication inserts new publication into db together with audit entry and outbox events.
+// Returns entity.ErrNotFound if publisher doesn't exist, or entity.ConflictError if publication of the publisher with the same name exists.
+func (repo *Repository) CreatePublication(ctx context.Context, p *entity.Publication, audit *entity.AuditEntry, events ...*entity.OutboxEvent) error {
+	err := repo.inTx(ctx, func(tx pgx.Tx) error {
+		// Foreign key doesn't prevent adding publications to deleted publisher.
+		// Publisher is locked till commit, so it cannot be deleted concurrently without deleting the publication.
+		var publisherUUID uuid.UUID
+		err := tx.QueryRow(ctx, "select uuid from publishers where uuid=$1 and deleted_at is null for share", p.PublisherUUID).Scan(&publisherUUID)
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("publisher %v doesn't exist: %w", p.PublisherUUID, entity.ErrNotFound)
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.QueryRow(ctx, "insert into publications (uuid, name, description, type, publisher_uuid, language_code, config) values ($1, $2, $3, $4, $5, $6, $7) returning created_at, modified_at, version",
+			p.UUID, p.Name, p.Description, p.Type, p.PublisherUUID, p.LanguageCode, p.Config).Scan(&p.CreatedAt, &p.ModifiedAt, &p.Version); err != nil {
+			return err
+		}
+		if err := insertAuditEntry(ctx, tx, audit); err != nil {
+			return err
+		}
+		return insertOutboxEvents(ctx, tx, events)
+	})
+	return repo.conflictError(ctx, err, entity.AuditEntityPublication, publicationConflictQuery, p.PublisherUUID, p.Name, p.UUID)
 }
 
-func (repo *Repository) publicationExists(ctx context.Context, p *entity.Publication) bool {
-	var exists bool
-	row := repo.pool.QueryRow(ctx, "select exists (select 1 from publications where uuid=$1 or (publisher_uuid=$2 and name=$3))", p.UUID, p.PublisherUUID, p.Name)
-	if err := row.Scan(&exists); err != nil {
-		panic(err)
-	}
-	if exists == true {
-		return true
-	}
-	return false
+// UpdatePublication updates Publication in db together with audit entry and outbox events, if its version in db is still the same.
+// Returns entity.ErrVersionMismatch otherwise, or entity.ConflictError if other publication of the publisher with the same name exists.
+func (repo *Repository) UpdatePublication(ctx context.Context, p *entity.Publication, audit *entity.AuditEntry, events ...*entity.OutboxEvent) error {
+	err := repo.inTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, "update publications set name=$1, description=$2, language_code=$3, config=$4, version=version+1 where uuid=$5 and version=$6 and deleted_at is null returning modified_at, version",
+			p.Name, p.Description, p.LanguageCode, p.Config, p.UUID, p.Version).Scan(&p.ModifiedAt, &p.Version)
+		if err == pgx.ErrNoRows {
+			return entity.ErrVersionMismatch
+		}
+		if err != nil {
+			return err
+		}
+		if err := insertAuditEntry(ctx, tx, audit); err != nil {
+			return err
+		}
+		return insertOutboxEvents(ctx, tx, events)
+	})
+	return repo.conflictError(ctx, err, entity.AuditEntityPublication, publicationConflictQuery, p.PublisherUUID, p.Name, p.UUID)
 }
 
-// UpdatePublication updates Publication in db
-func (repo *Repository) UpdatePublication(ctx context.Context, p *entity.Publication) error {
-	_, err := repo.pool.Exec(ctx, "update publications set name=$1, description=$2, language_code=$3 where uuid=$4", p.Name, p.Description, p.LanguageCode, p.UUID)
-	return err
+// DeletePublication marks Publication as deleted in db together with adding audit entry and outbox events, if its version in db is still the same.
+// Returns entity.ErrVersionMismatch otherwise.
+func (repo *Repository) DeletePublication(ctx context.Context, p *entity.Publication, audit *entity.AuditEntry, events ...*entity.OutboxEvent) error {
+	return repo.inTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, "update publications set deleted_at=now(), version=version+1 where uuid=$1 and version=$2 and deleted_at is null returning modified_at, version, deleted_at",
+			p.UUID, p.Version).Scan(&p.ModifiedAt, &p.Version, &p.DeletedAt)
+		// Multiple requests could clash
+		if err == pgx.ErrNoRows {
+			return entity.ErrVersionMismatch
+		}
+		if err != nil {
+			return err
+		}
+		if err := insertAuditEntry(ctx, tx, audit); err != nil {
+			return err
+		}
+		return insertOutboxEvents(ctx, tx, events)
+	})
 }
 
-// DeletePublication removes Publications from db
-func (repo *Repository) DeletePublication(ctx context.Context, uuid uuid.UUID) error {
-	result, err := repo.pool.Exec(ctx, "delete from publications where uuid=$1", uuid)
-	if err != nil {
-		return err
-	}
-	// Should never be called since we find it before, but still (multiple requests could clash)
-	if result.RowsAffected() != 1 {
-		return fmt.Errorf("publication with UUID %v wasn't deleted from db", uuid)
+// RestorePublication restores deleted Publication together with adding audit entry and outbox events, if its version in db is still the same.
+// Returns entity.ErrVersionMismatch otherwise, or entity.ConflictError if publication with the same name was created since deletion.
+func (repo *Repository) RestorePublication(ctx context.Context, p *entity.Publication, audit *entity.AuditEntry, events ...*entity.OutboxEvent) error {
+	err := repo.inTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, "update publications set deleted_at=null, version=version+1 where uuid=$1 and version=$2 and deleted_at is not null returning modified_at, version",
+			p.UUID, p.Version).Scan(&p.ModifiedAt, &p.Version)
+		if err == pgx.ErrNoRows {
+			return entity.ErrVersionMismatch
+		}
+		if err != nil {
+			return err
+		}
+		if err := insertAuditEntry(ctx, tx, audit); err != nil {
+			return err
+		}
+		return insertOutboxEvents(ctx, tx, events)
+	})
+	if err == nil {
+		p.DeletedAt = nil
 	}
-	return err
+	return repo.conflictError(ctx, err, entity.AuditEntityPublication, publicationConflictQuery, p.PublisherUUID, p.Name, p.UUID)
 }
 
-// GetPublication returns Publication from db
+// GetPublication returns not deleted Publication from db
 func (repo *Repository) GetPublication(ctx context.Context, uuid uuid.UUID) (*entity.Publication, error) {
+	return repo.getPublication(ctx, "uuid=$1 and deleted_at is null", uuid)
+}
+
+// GetDeletedPublication returns deleted Publication from db
+func (repo *Repository) GetDeletedPublication(ctx context.Context, uuid uuid.UUID) (*entity.Publication, error) {
+	return repo.getPublication(ctx, "uuid=$1 and deleted_at is not null", uuid)
+}
+
+func (repo *Repository) getPublication(ctx context.Context, condition string, args ...interface{}) (*entity.Publication, error) {
 	p := &entity.Publication{}
-	err := repo.pool.QueryRow(ctx, "select uuid, name, description, language_code, publisher_uuid, type from publications where uuid=$1", uuid).
-		Scan(&p.UUID, &p.Name, &p.Description, &p.LanguageCode, &p.PublisherUUID, &p.Type)
+	err := repo.pool.QueryRow(ctx, "select "+publicationColumns+" from publications where "+condition, args...).
+		Scan(&p.UUID, &p.Name, &p.Description, &p.LanguageCode, &p.PublisherUUID, &p.Type, &p.Config, &p.CreatedAt, &p.ModifiedAt, &p.Version, &p.DeletedAt)
 	if err != nil && err == pgx.ErrNoRows {
 		return nil, nil
 	}
@@ -66,24 +129,85 @@ func (repo *Repository) GetPublication(ctx context.Context, uuid uuid.UUID) (*en
 	return p, nil
 }
 
-// GetPublications returns list of Publication from db
+// GetPublications returns list of not deleted Publication from db
 func (repo *Repository) GetPublications(ctx context.Context) ([]*entity.Publication, error) {
-	rows, err := repo.pool.Query(ctx, "select uuid, name, description, language_code, publisher_uuid, type from publications")
+	rows, err := repo.pool.Query(ctx, "select "+publicationColumns+" from publications where deleted_at is null")
 	if err != nil {
 		return nil, err
 	}
-	publications := []*entity.Publication{}
-	for rows.Next() {
-		p := &entity.Publication{}
-		if err := rows.Scan(&p.UUID, &p.Name, &p.Description, &p.LanguageCode, &p.PublisherUUID, &p.Type); err != nil {
-			return nil, err
+	return scanPublications(rows)
+}
+
+// publicationsSortColumns maps sort fields to db columns, only these are allowed in order by
+var publicationsSortColumns = map[string]string{
+	entity.PublicationsSortByName:       "name",
+	entity.PublicationsSortByCreatedAt:  "created_at",
+	entity.PublicationsSortByModifiedAt: "modified_at",
+}
+
+// GetPublicationsPage returns one page of Publication from db, filtered and sorted according to query
+func (repo *Repository) GetPublicationsPage(ctx context.Context, query entity.PublicationsQuery) ([]*entity.Publication, error) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	// arg adds query argument and returns its placeholder
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if !query.UpdatedSince.IsZero() {
+		conditions = append(conditions, "modified_at > "+arg(query.UpdatedSince))
+	}
+	if query.PublisherUUID != uuid.Nil {
+		conditions = append(conditions, "publisher_uuid = "+arg(query.PublisherUUID))
+	}
+	if query.Type != "" {
+		conditions = append(conditions, "type = "+arg(query.Type))
+	}
+	if query.LanguageCode != "" {
+		conditions = append(conditions, "language_code = "+arg(query.LanguageCode))
+	}
+	if query.Name != "" {
+		conditions = append(conditions, "name ilike "+arg("%"+likeEscaper.Replace(query.Name)+"%"))
+	}
+	if !query.IncludeDeleted {
+		conditions = append(conditions, "deleted_at is null")
+	}
+	direction, comparison := "asc", ">"
+	if query.Descending {
+		direction, comparison = "desc", "<"
+	}
+	orderBy := "uuid " + direction
+	if query.Sort != "" {
+		column, ok := publicationsSortColumns[query.Sort]
+		if !ok {
+			return nil, fmt.Errorf("unknown publications sort field %s", query.Sort)
 		}
-		publications = append(publications, p)
+		orderBy = fmt.Sprintf("%s %s, %s", column, direction, orderBy)
+		if query.After != uuid.Nil {
+			conditions = append(conditions, fmt.Sprintf("(%s, uuid) %s (%s, %s)", column, comparison, arg(query.AfterValue), arg(query.After)))
+		}
+	} else if query.After != uuid.Nil {
+		conditions = append(conditions, fmt.Sprintf("uuid %s %s", comparison, arg(query.After)))
+	}
+	sql := "select " + publicationColumns + " from publications"
+	if len(conditions) > 0 {
+		sql += " where " + strings.Join(conditions, " and ")
 	}
-	if rows.Err() != nil {
+	sql += fmt.Sprintf(" order by %s limit %s", orderBy, arg(query.Limit))
+
+	rows, err := repo.pool.Query(ctx, sql, args...)
+	if err != nil {
 		return nil, err
 	}
-	return publications, nil
+	return scanPublications(rows)
+}
+
+// EnsurePublicationTypes adds missing publication types to db, existing types are kept
+func (repo *Repository) EnsurePublicationTypes(ctx context.Context, publicationTypes []string) error {
+	_, err := repo.pool.Exec(ctx, "insert into publication_types (type) select unnest($1::text[]) on conflict do nothing", publicationTypes)
+	return err
 }
 
 // Healthcheck is needed for application healtchecks
@@ -98,3 +222,9 @@ func (repo *Repository) Healthcheck(ctx context.Context) error {
 	}
 	return fmt.Errorf("failure checking access to 'publications' table")
 }
+
+// GetPublicationsState returns change counter of publications and time of their latest change.
+// Deletion, restoration and purge of publications change it.
+func (repo *Repository) GetPublicationsState(ctx context.Context) (entity.CollectionState, error) {
+	return repo.getCollectionState(ctx, "publications")
+}
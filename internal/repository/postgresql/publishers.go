@@ -2,8 +2,9 @@ package postgresql
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/Tarick/naca-publications/internal/entity"
 
@@ -11,34 +12,135 @@ import (
 	"github.com/jackc/pgx/v4"
 )
 
-// CreatePublisher inserts new publisher into db
-func (repo *Repository) CreatePublisher(ctx context.Context, p *entity.Publisher) error {
-	_, err := repo.pool.Exec(ctx, "insert into publishers (uuid, name, url) values ($1, $2, $3)", p.UUID, p.Name, p.URL)
-	return err
+// publisherConflictQuery finds other not deleted publisher with the same name or url
+const publisherConflictQuery = "select uuid from publishers where (name=$1 or url=$2) and uuid<>$3 and deleted_at is null limit 1"
+
+// restoredPublicationConflictQuery finds not deleted publication with the same name as one of publisher publications deleted at the time
+const restoredPublicationConflictQuery = `select p.uuid from publications p join publications r on p.publisher_uuid=r.publisher_uuid and p.name=r.name
+	where r.publisher_uuid=$1 and r.deleted_at=$2 and p.deleted_at is null limit 1`
+
+// publisherColumns are selected to scan them with scanPublishers
+const publisherColumns = "uuid, name, url, created_at, modified_at, version, deleted_at"
+
+// publicationsNameKey is unique index of publication names of publisher
+const publicationsNameKey = "publications_name_publisher_uuid_key"
+
+// CreatePublisher inserts new publisher into db together with audit entry.
+// Returns entity.ConflictError if publisher with the same name or url exists.
+func (repo *Repository) CreatePublisher(ctx context.Context, p *entity.Publisher, audit *entity.AuditEntry) error {
+	err := repo.inTx(ctx, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(ctx, "insert into publishers (uuid, name, url) values ($1, $2, $3) returning created_at, modified_at, version", p.UUID, p.Name, p.URL).
+			Scan(&p.CreatedAt, &p.ModifiedAt, &p.Version); err != nil {
+			return err
+		}
+		return insertAuditEntry(ctx, tx, audit)
+	})
+	return repo.conflictError(ctx, err, entity.AuditEntityPublisher, publisherConflictQuery, p.Name, p.URL, p.UUID)
 }
 
-// UpdatePublisher updates Publisher in db
-func (repo *Repository) UpdatePublisher(ctx context.Context, p *entity.Publisher) error {
-	_, err := repo.pool.Exec(ctx, "update publishers set name=$1, url=$2 where uuid=$3", p.Name, p.URL, p.UUID)
-	return err
+// UpdatePublisher updates Publisher in db together with audit entry, if its version in db is still the same. Returns entity.ErrVersionMismatch otherwise,
+// or entity.ConflictError if other publisher with the same name or url exists.
+func (repo *Repository) UpdatePublisher(ctx context.Context, p *entity.Publisher, audit *entity.AuditEntry) error {
+	err := repo.inTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, "update publishers set name=$1, url=$2, version=version+1 where uuid=$3 and version=$4 and deleted_at is null returning modified_at, version",
+			p.Name, p.URL, p.UUID, p.Version).Scan(&p.ModifiedAt, &p.Version)
+		if err == pgx.ErrNoRows {
+			return entity.ErrVersionMismatch
+		}
+		if err != nil {
+			return err
+		}
+		return insertAuditEntry(ctx, tx, audit)
+	})
+	return repo.conflictError(ctx, err, entity.AuditEntityPublisher, publisherConflictQuery, p.Name, p.URL, p.UUID)
 }
 
-// DeletePublisher removes Publishers from db
-func (repo *Repository) DeletePublisher(ctx context.Context, uuid uuid.UUID) error {
-	result, err := repo.pool.Exec(ctx, "delete from publishers where uuid=$1", uuid)
-	if err != nil {
-		return err
+// DeletePublisher marks Publisher and its publications as deleted in db together with adding audit entries and outbox events
+// of deleted publications, if its version in db is still the same. Returns entity.ErrVersionMismatch otherwise.
+func (repo *Repository) DeletePublisher(ctx context.Context, p *entity.Publisher, audit *entity.AuditEntry, events entity.PublicationsOutboxEvents) error {
+	return repo.inTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, "update publishers set deleted_at=now(), version=version+1 where uuid=$1 and version=$2 and deleted_at is null returning modified_at, version, deleted_at",
+			p.UUID, p.Version).Scan(&p.ModifiedAt, &p.Version, &p.DeletedAt)
+		if err == pgx.ErrNoRows {
+			return entity.ErrVersionMismatch
+		}
+		if err != nil {
+			return err
+		}
+		// Publications get the same deletion time to be restored together with publisher
+		rows, err := tx.Query(ctx, "update publications set deleted_at=$1, version=version+1 where publisher_uuid=$2 and deleted_at is null returning "+publicationColumns,
+			p.DeletedAt, p.UUID)
+		if err != nil {
+			return err
+		}
+		publications, err := scanPublications(rows)
+		if err != nil {
+			return err
+		}
+		if err := insertAuditEntry(ctx, tx, audit); err != nil {
+			return err
+		}
+		if err := insertPublisherPublicationsAuditEntries(ctx, tx, audit, p.DeletedAt); err != nil {
+			return err
+		}
+		return insertPublicationsOutboxEvents(ctx, tx, events, publications)
+	})
+}
+
+// RestorePublisher restores deleted Publisher and publications deleted together with it, adding audit entries and outbox events
+// of restored publications, if its version in db is still the same. Returns entity.ErrVersionMismatch otherwise,
+// or entity.ConflictError if publisher with the same name or url, or publication with the same name was created since deletion.
+func (repo *Repository) RestorePublisher(ctx context.Context, p *entity.Publisher, audit *entity.AuditEntry, events entity.PublicationsOutboxEvents) error {
+	deletedAt := p.DeletedAt
+	err := repo.inTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, "update publishers set deleted_at=null, version=version+1 where uuid=$1 and version=$2 and deleted_at is not null returning modified_at, version",
+			p.UUID, p.Version).Scan(&p.ModifiedAt, &p.Version)
+		if err == pgx.ErrNoRows {
+			return entity.ErrVersionMismatch
+		}
+		if err != nil {
+			return err
+		}
+		if err := insertAuditEntry(ctx, tx, audit); err != nil {
+			return err
+		}
+		if err := insertPublisherPublicationsAuditEntries(ctx, tx, audit, deletedAt); err != nil {
+			return err
+		}
+		rows, err := tx.Query(ctx, "update publications set deleted_at=null, version=version+1 where publisher_uuid=$1 and deleted_at=$2 returning "+publicationColumns,
+			p.UUID, deletedAt)
+		if err != nil {
+			return err
+		}
+		publications, err := scanPublications(rows)
+		if err != nil {
+			return err
+		}
+		return insertPublicationsOutboxEvents(ctx, tx, events, publications)
+	})
+	if err == nil {
+		p.DeletedAt = nil
 	}
-	if result.RowsAffected() != 1 {
-		return errors.New(fmt.Sprint("publisher delete from db execution didn't delete record for UUID ", uuid))
+	if constraint, _ := violatedConstraint(err); constraint == publicationsNameKey {
+		return repo.conflictError(ctx, err, entity.AuditEntityPublication, restoredPublicationConflictQuery, p.UUID, deletedAt)
 	}
-	return err
+	return repo.conflictError(ctx, err, entity.AuditEntityPublisher, publisherConflictQuery, p.Name, p.URL, p.UUID)
 }
 
-// GetPublisher returns Publisher from db
+// GetPublisher returns not deleted Publisher from db
 func (repo *Repository) GetPublisher(ctx context.Context, uuid uuid.UUID) (*entity.Publisher, error) {
+	return repo.getPublisher(ctx, "uuid=$1 and deleted_at is null", uuid)
+}
+
+// GetDeletedPublisher returns deleted Publisher from db
+func (repo *Repository) GetDeletedPublisher(ctx context.Context, uuid uuid.UUID) (*entity.Publisher, error) {
+	return repo.getPublisher(ctx, "uuid=$1 and deleted_at is not null", uuid)
+}
+
+func (repo *Repository) getPublisher(ctx context.Context, condition string, args ...interface{}) (*entity.Publisher, error) {
 	p := &entity.Publisher{}
-	err := repo.pool.QueryRow(ctx, "select uuid, name, url from publishers where uuid=$1", uuid).Scan(&p.UUID, &p.Name, &p.URL)
+	err := repo.pool.QueryRow(ctx, "select "+publisherColumns+" from publishers where "+condition, args...).
+		Scan(&p.UUID, &p.Name, &p.URL, &p.CreatedAt, &p.ModifiedAt, &p.Version, &p.DeletedAt)
 	if err != nil && err == pgx.ErrNoRows {
 		return nil, nil
 	}
@@ -48,42 +150,106 @@ func (repo *Repository) GetPublisher(ctx context.Context, uuid uuid.UUID) (*enti
 	return p, nil
 }
 
-// GetPublishers returns list of Publisher from db
+// GetPublishers returns list of not deleted Publisher from db
 func (repo *Repository) GetPublishers(ctx context.Context) ([]*entity.Publisher, error) {
-	rows, err := repo.pool.Query(ctx, "select uuid, name, url from publishers")
+	rows, err := repo.pool.Query(ctx, "select "+publisherColumns+" from publishers where deleted_at is null")
+	if err != nil {
+		return nil, err
+	}
+	return scanPublishers(rows)
+}
+
+// GetPublishersPage returns one page of Publisher from db, ordered by UUID
+func (repo *Repository) GetPublishersPage(ctx context.Context, query entity.PublishersQuery) ([]*entity.Publisher, error) {
+	var args []interface{}
+	// arg adds query argument and returns its placeholder
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	conditions := []string{"uuid > " + arg(query.After)}
+	if !query.UpdatedSince.IsZero() {
+		conditions = append(conditions, "modified_at > "+arg(query.UpdatedSince))
+	}
+	if !query.IncludeDeleted {
+		conditions = append(conditions, "deleted_at is null")
+	}
+	sql := "select " + publisherColumns + " from publishers where " + strings.Join(conditions, " and ") +
+		" order by uuid limit " + arg(query.Limit)
+	rows, err := repo.pool.Query(ctx, sql, args...)
 	if err != nil {
 		return nil, err
 	}
+	return scanPublishers(rows)
+}
+
+// scanPublishers reads and closes rows of publisherColumns
+func scanPublishers(rows pgx.Rows) ([]*entity.Publisher, error) {
+	defer rows.Close()
 	publishers := []*entity.Publisher{}
 	for rows.Next() {
 		p := &entity.Publisher{}
-		if err := rows.Scan(&p.UUID, &p.Name, &p.URL); err != nil {
+		if err := rows.Scan(&p.UUID, &p.Name, &p.URL, &p.CreatedAt, &p.ModifiedAt, &p.Version, &p.DeletedAt); err != nil {
 			return nil, err
 		}
 		publishers = append(publishers, p)
 	}
-	if rows.Err() != nil {
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 	return publishers, nil
 }
 
-// GetPublicationsByPublisher returns list of Publication filterered by publisher uuid
+// GetPublicationsByPublisher returns list of not deleted Publication filterered by publisher uuid
 func (repo *Repository) GetPublicationsByPublisher(ctx context.Context, publisherUUID uuid.UUID) ([]*entity.Publication, error) {
-	rows, err := repo.pool.Query(ctx, "select uuid, name, description, language_code, publisher_uuid, pt.type from publications  join publication_types pt on type_id = id where publisher_uuid=$1", publisherUUID)
+	return repo.getPublicationsByPublisher(ctx, "publisher_uuid=$1 and deleted_at is null", publisherUUID)
+}
+
+func (repo *Repository) getPublicationsByPublisher(ctx context.Context, condition string, args ...interface{}) ([]*entity.Publication, error) {
+	rows, err := repo.pool.Query(ctx, "select "+publicationColumns+" from publications where "+condition, args...)
 	if err != nil {
 		return nil, err
 	}
+	return scanPublications(rows)
+}
+
+// scanPublications reads and closes rows of publicationColumns
+func scanPublications(rows pgx.Rows) ([]*entity.Publication, error) {
+	defer rows.Close()
 	publications := []*entity.Publication{}
 	for rows.Next() {
 		p := &entity.Publication{}
-		if err := rows.Scan(&p.UUID, &p.Name, &p.Description, &p.LanguageCode, &p.PublisherUUID, &p.Type); err != nil {
+		if err := rows.Scan(&p.UUID, &p.Name, &p.Description, &p.LanguageCode, &p.PublisherUUID, &p.Type, &p.Config, &p.CreatedAt, &p.ModifiedAt, &p.Version, &p.DeletedAt); err != nil {
 			return nil, err
 		}
 		publications = append(publications, p)
 	}
-	if rows.Err() != nil {
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 	return publications, nil
 }
+
+// GetPublishersState returns change counter of publishers and time of their latest change.
+// Deletion, restoration and purge of publishers change it.
+func (repo *Repository) GetPublishersState(ctx context.Context) (entity.CollectionState, error) {
+	return repo.getCollectionState(ctx, "publishers")
+}
+
+// PurgeDeleted permanently removes publishers and publications deleted before the time
+func (repo *Repository) PurgeDeleted(ctx context.Context, deletedBefore time.Time) (publishers int64, publications int64, err error) {
+	err = repo.inTx(ctx, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, "delete from publications where deleted_at < $1", deletedBefore)
+		if err != nil {
+			return err
+		}
+		publications = result.RowsAffected()
+		result, err = tx.Exec(ctx, "delete from publishers where deleted_at < $1", deletedBefore)
+		if err != nil {
+			return err
+		}
+		publishers = result.RowsAffected()
+		return nil
+	})
+	return publishers, publications, err
+}
@@ -0,0 +1,97 @@
+package postgresql
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+)
+
+// Expressions must be the same as in indexes of search migration
+const (
+	publishersSearchVector   = "to_tsvector('simple', name || ' ' || url)"
+	publicationsSearchVector = "to_tsvector('simple', name || ' ' || description)"
+	searchQuery              = "plainto_tsquery('simple', $1)"
+)
+
+const (
+	// Matched words are delimited by private use characters, which are removed from fields before highlighting,
+	// so that fragments are HTML escaped before delimiters are replaced with marks
+	highlightStart  = "\ue000"
+	highlightStop   = "\ue001"
+	headlineOptions = "'StartSel=" + highlightStart + ", StopSel=" + highlightStop + "'"
+)
+
+// headlineSource returns field without highlight delimiters
+func headlineSource(field string) string {
+	return fmt.Sprintf("translate(%s, '%s%s', '')", field, highlightStart, highlightStop)
+}
+
+// highlight escapes fragment returned by ts_headline and wraps matched words in <mark></mark>
+func highlight(fragment string) string {
+	fragment = html.EscapeString(fragment)
+	return strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>").Replace(fragment)
+}
+
+// Search finds publishers and publications by words in their fields and by names similarity, ordered by relevance
+func (repo *Repository) Search(ctx context.Context, query entity.SearchQuery) ([]*entity.SearchResult, error) {
+	args := []interface{}{query.Text}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	selects := []string{}
+	// Publishers have no language
+	if (query.Type == "" || query.Type == entity.SearchResultPublisher) && query.LanguageCode == "" {
+		selects = append(selects, fmt.Sprintf(`select '%s', uuid, name, '',
+			(ts_rank(%s, %s) + word_similarity($1, name))::float8,
+			ts_headline('simple', %s, %s, %s), ''
+			from publishers where (%s @@ %s or $1 <%% name) and deleted_at is null`,
+			entity.SearchResultPublisher, publishersSearchVector, searchQuery, headlineSource("name"), searchQuery, headlineOptions,
+			publishersSearchVector, searchQuery))
+	}
+	if query.Type == "" || query.Type == entity.SearchResultPublication {
+		sql := fmt.Sprintf(`select '%s', uuid, name, language_code,
+			(ts_rank(%s, %s) + word_similarity($1, name))::float8,
+			ts_headline('simple', %s, %s, %s), ts_headline('simple', %s, %s, %s)
+			from publications where (%s @@ %s or $1 <%% name) and deleted_at is null`,
+			entity.SearchResultPublication, publicationsSearchVector, searchQuery,
+			headlineSource("name"), searchQuery, headlineOptions, headlineSource("description"), searchQuery, headlineOptions,
+			publicationsSearchVector, searchQuery)
+		if query.LanguageCode != "" {
+			sql += " and language_code = " + arg(query.LanguageCode)
+		}
+		selects = append(selects, sql)
+	}
+	results := []*entity.SearchResult{}
+	if len(selects) == 0 {
+		return results, nil
+	}
+	sql := strings.Join(selects, " union all ") + " order by 5 desc, 3 limit " + arg(query.Limit)
+	rows, err := repo.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var nameHighlight, descriptionHighlight string
+		r := &entity.SearchResult{Highlights: map[string]string{}}
+		if err := rows.Scan(&r.Type, &r.UUID, &r.Name, &r.LanguageCode, &r.Rank, &nameHighlight, &descriptionHighlight); err != nil {
+			return nil, err
+		}
+		// Fields matched by similarity only have nothing to highlight
+		if strings.Contains(nameHighlight, highlightStart) {
+			r.Highlights["name"] = highlight(nameHighlight)
+		}
+		if strings.Contains(descriptionHighlight, highlightStart) {
+			r.Highlights["description"] = highlight(descriptionHighlight)
+		}
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
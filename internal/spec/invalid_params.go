@@ -0,0 +1,31 @@
+package spec
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/Tarick/naca-publications/pkg/problem"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// InvalidParams flattens nested validation errors, ordered by field names. Names are prefixed with prefix.
+// Errors without validation code have problem.CodeValidationInvalid code.
+func InvalidParams(prefix string, errs validation.Errors) []problem.InvalidParam {
+	params := []problem.InvalidParam{}
+	for field, err := range errs {
+		name := prefix + field
+		var nested validation.Errors
+		var validationErr validation.Error
+		switch {
+		case errors.As(err, &nested):
+			params = append(params, InvalidParams(name+".", nested)...)
+		case errors.As(err, &validationErr):
+			params = append(params, problem.InvalidParam{Name: name, Code: validationErr.Code(), Reason: validationErr.Error()})
+		default:
+			params = append(params, problem.InvalidParam{Name: name, Code: problem.CodeValidationInvalid, Reason: err.Error()})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool { return params[i].Name < params[j].Name })
+	return params
+}
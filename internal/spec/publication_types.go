@@ -0,0 +1,136 @@
+package spec
+
+// This file contains built-in publication types and their configs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+)
+
+// Built-in publication types
+const (
+	PublicationTypeRSS      string = "rss"
+	PublicationTypeScrapped string = "scrapped"
+	PublicationTypeAPI      string = "api"
+)
+
+// RSSPublicationConfig defines config for RSS Feeds
+type RSSPublicationConfig struct {
+	URL string `json:"url"`
+}
+
+func (c *RSSPublicationConfig) Validate() error {
+	return validation.ValidateStruct(c,
+		validation.Field(&c.URL, validation.Required, validation.Length(5, 100), is.URL),
+	)
+}
+
+// ScrappedPublicationConfig defines config for web pages scrapping
+type ScrappedPublicationConfig struct {
+	// StartURL is the page with publication items list
+	StartURL string `json:"start_url"`
+	// CSS selectors of the item on the page and of its title and link inside of the item
+	ItemSelector  string `json:"item_selector"`
+	TitleSelector string `json:"title_selector"`
+	LinkSelector  string `json:"link_selector"`
+	// Schedule is cron expression with 5 fields
+	Schedule string `json:"schedule"`
+}
+
+func (c *ScrappedPublicationConfig) Validate() error {
+	return validation.ValidateStruct(c,
+		validation.Field(&c.StartURL, validation.Required, validation.Length(5, 300), is.URL),
+		validation.Field(&c.ItemSelector, validation.Required, validation.Length(1, 300)),
+		validation.Field(&c.TitleSelector, validation.Required, validation.Length(1, 300)),
+		validation.Field(&c.LinkSelector, validation.Required, validation.Length(1, 300)),
+		validation.Field(&c.Schedule, validation.Required, validation.By(checkCronSchedule)),
+	)
+}
+
+// APIPublicationConfig defines config for publications fetched from third-party APIs
+type APIPublicationConfig struct {
+	URL string `json:"url"`
+	// APIKeyRef is a reference to API key in secrets storage, API key itself is never stored
+	APIKeyRef string `json:"api_key_ref"`
+	// LanguageCode is passed to API, if it serves several languages
+	LanguageCode string `json:"language_code"`
+}
+
+func (c *APIPublicationConfig) Validate() error {
+	return validation.ValidateStruct(c,
+		validation.Field(&c.URL, validation.Required, validation.Length(5, 300), is.URL),
+		validation.Field(&c.APIKeyRef, validation.Required, validation.Length(1, 200), validation.Match(apiKeyRefRegexp)),
+		validation.Field(&c.LanguageCode, validation.Length(2, 2), IsLanguageCode),
+	)
+}
+
+var apiKeyRefRegexp = regexp.MustCompile(`^[A-Za-z0-9_./-]+$`)
+
+// cronFieldRegexp matches one field of cron expression: list of values, ranges or '*' with optional step
+var cronFieldRegexp = regexp.MustCompile(`^(\*|\d+(-\d+)?)(/\d+)?(,(\*|\d+(-\d+)?)(/\d+)?)*$`)
+
+// validation helper to check cron schedule expression
+func checkCronSchedule(value interface{}) error {
+	s, _ := value.(string)
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return errors.New("must be a cron expression with 5 fields")
+	}
+	for _, field := range fields {
+		if !cronFieldRegexp.MatchString(field) {
+			return fmt.Errorf("invalid cron expression field %s", field)
+		}
+	}
+	return nil
+}
+
+func init() {
+	RegisterPublicationType(&PublicationType{
+		Name:        PublicationTypeRSS,
+		Description: "RSS or Atom feed, fetched by RSS Feeds service",
+		Decode:      NewJSONConfigDecoder(func() PublicationConfig { return &RSSPublicationConfig{} }),
+		ConfigSchema: json.RawMessage(`{
+			"type": "object",
+			"required": ["url"],
+			"properties": {
+				"url": {"type": "string", "format": "uri", "minLength": 5, "maxLength": 100}
+			}
+		}`),
+	})
+	RegisterPublicationType(&PublicationType{
+		Name:        PublicationTypeScrapped,
+		Description: "Web pages, scrapped by schedule using CSS selectors",
+		Decode:      NewJSONConfigDecoder(func() PublicationConfig { return &ScrappedPublicationConfig{} }),
+		ConfigSchema: json.RawMessage(`{
+			"type": "object",
+			"required": ["start_url", "item_selector", "title_selector", "link_selector", "schedule"],
+			"properties": {
+				"start_url": {"type": "string", "format": "uri", "minLength": 5, "maxLength": 300},
+				"item_selector": {"type": "string", "minLength": 1, "maxLength": 300},
+				"title_selector": {"type": "string", "minLength": 1, "maxLength": 300},
+				"link_selector": {"type": "string", "minLength": 1, "maxLength": 300},
+				"schedule": {"type": "string", "description": "cron expression with 5 fields"}
+			}
+		}`),
+	})
+	RegisterPublicationType(&PublicationType{
+		Name:        PublicationTypeAPI,
+		Description: "Third-party API, fetched by API feeds service",
+		Decode:      NewJSONConfigDecoder(func() PublicationConfig { return &APIPublicationConfig{} }),
+		ConfigSchema: json.RawMessage(`{
+			"type": "object",
+			"required": ["url", "api_key_ref"],
+			"properties": {
+				"url": {"type": "string", "format": "uri", "minLength": 5, "maxLength": 300},
+				"api_key_ref": {"type": "string", "pattern": "^[A-Za-z0-9_./-]+$", "maxLength": 200},
+				"language_code": {"type": "string", "minLength": 2, "maxLength": 2}
+			}
+		}`),
+	})
+}
@@ -0,0 +1,114 @@
+// Package spec defines request bodies of Publications API, registry of publication types with their configs,
+// and their validation rules. It is shared by API server and its clients, so they validate the same way.
+package spec
+
+// This file contains registry of publication types
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// PublicationConfig is used to pass around different config structs
+type PublicationConfig interface{}
+
+// PublicationType defines publication type config
+type PublicationType struct {
+	Name        string
+	Description string
+	// Decode parses config of publication type
+	Decode func(json.RawMessage) (PublicationConfig, error)
+	// Validate checks decoded config, ozzo-validation of config is used if it is nil
+	Validate func(PublicationConfig) error
+	// ConfigSchema is JSON Schema of config
+	ConfigSchema json.RawMessage
+}
+
+// NewJSONConfigDecoder returns decoder, which unmarshals json into config returned by newConfig
+func NewJSONConfigDecoder(newConfig func() PublicationConfig) func(json.RawMessage) (PublicationConfig, error) {
+	return func(body json.RawMessage) (PublicationConfig, error) {
+		config := newConfig()
+		if err := json.Unmarshal(body, config); err != nil {
+			return nil, err
+		}
+		return config, nil
+	}
+}
+
+// publicationTypes is registry of all known publication types
+var publicationTypes = struct {
+	sync.RWMutex
+	types map[string]*PublicationType
+}{types: map[string]*PublicationType{}}
+
+// RegisterPublicationType adds publication type to registry. Types are inserted into publication_types table on API start.
+func RegisterPublicationType(t *PublicationType) {
+	if t.Name == "" || t.Decode == nil {
+		panic("publication type must have name and config decoder")
+	}
+	publicationTypes.Lock()
+	defer publicationTypes.Unlock()
+	if _, ok := publicationTypes.types[t.Name]; ok {
+		panic(fmt.Sprintf("publication type %s is already registered", t.Name))
+	}
+	publicationTypes.types[t.Name] = t
+}
+
+// PublicationTypeNames returns sorted names of registered publication types
+func PublicationTypeNames() []string {
+	publicationTypes.RLock()
+	defer publicationTypes.RUnlock()
+	names := make([]string, 0, len(publicationTypes.types))
+	for name := range publicationTypes.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetPublicationType returns registered publication type by name
+func GetPublicationType(name string) (*PublicationType, bool) {
+	publicationTypes.RLock()
+	defer publicationTypes.RUnlock()
+	t, ok := publicationTypes.types[name]
+	return t, ok
+}
+
+// CheckPublicationType is validation helper to check that publication type is registered
+func CheckPublicationType(value interface{}) error {
+	s, _ := value.(string)
+	if _, ok := GetPublicationType(s); !ok {
+		return fmt.Errorf("unknow publication type: %s", s)
+	}
+	return nil
+}
+
+// DecodePublicationConfig parses and validates config of publication type
+func DecodePublicationConfig(publicationType string, body json.RawMessage) (PublicationConfig, error) {
+	t, ok := GetPublicationType(publicationType)
+	if !ok {
+		return nil, fmt.Errorf("incorrect 'publication_type' specified in request: %v", publicationType)
+	}
+	config, err := t.Decode(body)
+	if err != nil {
+		return nil, err
+	}
+	validate := t.Validate
+	if validate == nil {
+		validate = func(config PublicationConfig) error { return validation.Validate(config) }
+	}
+	if err := validate(config); err != nil {
+		// Validation errors of config fields are nested in config field
+		var validationErrors validation.Errors
+		if errors.As(err, &validationErrors) {
+			return nil, validation.Errors{"config": validationErrors}
+		}
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	return config, nil
+}
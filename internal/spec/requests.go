@@ -0,0 +1,75 @@
+package spec
+
+// This file contains request bodies of publishers and publications changes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/asaskevich/govalidator"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+	"github.com/gofrs/uuid"
+)
+
+// PublisherRequestBody contains information on publisher creation
+type PublisherRequestBody struct {
+	// swagger:allOf
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// Bind implements Bind interface for chi Bind to map request body to request body struct, with simple validator
+func (p *PublisherRequestBody) Bind(r *http.Request) error {
+	if p == nil {
+		return errors.New("request body is empty")
+	}
+	return p.Validate()
+}
+
+// Validate body
+func (p *PublisherRequestBody) Validate() error {
+	return validation.ValidateStruct(p,
+		validation.Field(&p.Name, validation.Required),
+		validation.Field(&p.URL, validation.Required),
+	)
+}
+
+// PublicationRequestBody contains information on publication creation
+type PublicationRequestBody struct {
+	// swagger:allOf
+	Name          string    `json:"name"`
+	Description   string    `json:"description"`
+	LanguageCode  string    `json:"language_code"`
+	PublisherUUID uuid.UUID `json:"publisher_uuid"`
+	Type          string    `json:"publication_type"`
+	// Config content is different for different publication types.
+	// when parsing, we decide on Type
+	Config PublicationConfig `json:"config"`
+}
+
+// IsLanguageCode is validation rule of two-letter language code
+var IsLanguageCode = validation.NewStringRuleWithError(
+	govalidator.IsISO693Alpha2,
+	validation.NewError("validation_is_language_code_2_letter", "must be a valid two-letter ISO693Alpha2 language code"))
+
+// Validate body
+func (b *PublicationRequestBody) Validate() error {
+	return validation.ValidateStruct(b,
+		validation.Field(&b.Name, validation.Required, validation.Length(2, 300)),
+		validation.Field(&b.Description, validation.Required, validation.Length(5, 300)),
+		validation.Field(&b.PublisherUUID, validation.Required, is.UUID, validation.By(checkUUIDNotNil)),
+		validation.Field(&b.LanguageCode, validation.Required, validation.Length(2, 2), IsLanguageCode),
+		validation.Field(&b.Type, validation.Required, validation.By(CheckPublicationType)),
+		validation.Field(&b.Config),
+	)
+}
+
+// validation helper to check UUID
+func checkUUIDNotNil(value interface{}) error {
+	u, _ := value.(uuid.UUID)
+	if u == uuid.Nil {
+		return errors.New("uuid is nil")
+	}
+	return nil
+}
@@ -0,0 +1,6 @@
+-- Publication type specific configuration, e.g. RSS feed URL. Validated by API per publication type.
+ALTER TABLE publications ADD COLUMN config jsonb NOT NULL DEFAULT '{}'::jsonb CHECK (jsonb_typeof(config) = 'object');
+
+---- create above / drop below ----
+
+ALTER TABLE publications DROP COLUMN config;
@@ -0,0 +1,20 @@
+-- Transactional outbox: events to downstream services are written in the same transaction as publications changes
+-- and delivered by API dispatcher with retries.
+CREATE TABLE outbox (
+  id bigserial PRIMARY KEY,
+  aggregate_uuid uuid NOT NULL,
+  event_type text NOT NULL,
+  payload jsonb NOT NULL DEFAULT '{}'::jsonb,
+  attempts integer NOT NULL DEFAULT 0,
+  last_error text NOT NULL DEFAULT '',
+  next_attempt_at timestamptz NOT NULL DEFAULT NOW(),
+  created_at timestamptz NOT NULL DEFAULT NOW(),
+  dispatched_at timestamptz,
+  dead_lettered_at timestamptz
+);
+
+CREATE INDEX outbox_pending_idx ON outbox (aggregate_uuid, id) WHERE dispatched_at IS NULL AND dead_lettered_at IS NULL;
+
+---- create above / drop below ----
+
+DROP TABLE outbox;
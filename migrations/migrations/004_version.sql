@@ -0,0 +1,8 @@
+-- Row versions for optimistic concurrency control, incremented by API on every update
+ALTER TABLE publishers ADD COLUMN version integer NOT NULL DEFAULT 1;
+ALTER TABLE publications ADD COLUMN version integer NOT NULL DEFAULT 1;
+
+---- create above / drop below ----
+
+ALTER TABLE publications DROP COLUMN version;
+ALTER TABLE publishers DROP COLUMN version;
@@ -0,0 +1,16 @@
+-- Full-text search by words and trigram fuzzy search by partial or misspelled names.
+-- 'simple' configuration is used, since publications are in different languages.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX publishers_search_idx ON publishers USING gin (to_tsvector('simple', name || ' ' || url));
+CREATE INDEX publishers_name_trgm_idx ON publishers USING gin (name gin_trgm_ops);
+CREATE INDEX publications_search_idx ON publications USING gin (to_tsvector('simple', name || ' ' || description));
+CREATE INDEX publications_name_trgm_idx ON publications USING gin (name gin_trgm_ops);
+
+---- create above / drop below ----
+
+DROP INDEX publications_name_trgm_idx;
+DROP INDEX publications_search_idx;
+DROP INDEX publishers_name_trgm_idx;
+DROP INDEX publishers_search_idx;
+DROP EXTENSION IF EXISTS pg_trgm;
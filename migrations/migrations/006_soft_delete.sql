@@ -0,0 +1,32 @@
+-- Soft deletion: deleted rows are kept until purged, so they can be restored.
+-- Unique constraints apply to not deleted rows only, so names of deleted entities can be reused.
+ALTER TABLE publishers ADD COLUMN deleted_at timestamptz;
+ALTER TABLE publications ADD COLUMN deleted_at timestamptz;
+
+ALTER TABLE publishers DROP CONSTRAINT publishers_name_key;
+ALTER TABLE publishers DROP CONSTRAINT publishers_url_key;
+ALTER TABLE publications DROP CONSTRAINT publications_name_publisher_uuid_key;
+CREATE UNIQUE INDEX publishers_name_key ON publishers (name) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX publishers_url_key ON publishers (url) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX publications_name_publisher_uuid_key ON publications (name, publisher_uuid) WHERE deleted_at IS NULL;
+
+CREATE INDEX publishers_deleted_at_idx ON publishers (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX publications_deleted_at_idx ON publications (deleted_at) WHERE deleted_at IS NOT NULL;
+
+---- create above / drop below ----
+
+DELETE FROM publications WHERE deleted_at IS NOT NULL;
+DELETE FROM publishers WHERE deleted_at IS NOT NULL;
+
+DROP INDEX publications_deleted_at_idx;
+DROP INDEX publishers_deleted_at_idx;
+
+DROP INDEX publications_name_publisher_uuid_key;
+DROP INDEX publishers_url_key;
+DROP INDEX publishers_name_key;
+ALTER TABLE publications ADD CONSTRAINT publications_name_publisher_uuid_key UNIQUE (name, publisher_uuid);
+ALTER TABLE publishers ADD CONSTRAINT publishers_url_key UNIQUE (url);
+ALTER TABLE publishers ADD CONSTRAINT publishers_name_key UNIQUE (name);
+
+ALTER TABLE publications DROP COLUMN deleted_at;
+ALTER TABLE publishers DROP COLUMN deleted_at;
@@ -0,0 +1,28 @@
+-- Append-only log of publishers and publications changes made through API
+CREATE TABLE audit_log (
+  id bigserial PRIMARY KEY,
+  entity_type text NOT NULL,
+  entity_uuid uuid NOT NULL,
+  action text NOT NULL,
+  actor text NOT NULL,
+  request_id text NOT NULL DEFAULT '',
+  changes jsonb NOT NULL DEFAULT '{}'::jsonb,
+  created_at timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX audit_log_entity_uuid_idx ON audit_log (entity_uuid, id);
+CREATE INDEX audit_log_created_at_idx ON audit_log (created_at);
+
+CREATE FUNCTION trigger_audit_log_append_only() RETURNS TRIGGER AS $$ BEGIN RAISE EXCEPTION 'audit_log is append-only';
+
+END;
+
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER append_only BEFORE UPDATE OR DELETE ON audit_log FOR EACH ROW EXECUTE PROCEDURE trigger_audit_log_append_only();
+CREATE TRIGGER append_only_truncate BEFORE TRUNCATE ON audit_log FOR EACH STATEMENT EXECUTE PROCEDURE trigger_audit_log_append_only();
+
+---- create above / drop below ----
+
+DROP TABLE audit_log;
+DROP FUNCTION trigger_audit_log_append_only;
@@ -0,0 +1,13 @@
+-- API keys of clients, only SHA-256 hashes of keys are stored
+CREATE TABLE api_keys (
+  uuid uuid PRIMARY KEY,
+  name text NOT NULL,
+  role text NOT NULL CHECK (role IN ('reader', 'editor', 'admin')),
+  key_hash bytea NOT NULL UNIQUE,
+  created_at timestamptz NOT NULL DEFAULT NOW(),
+  revoked_at timestamptz
+);
+
+---- create above / drop below ----
+
+DROP TABLE api_keys;
@@ -0,0 +1,28 @@
+-- Outbox events are routed by publication type name, event types are <publication type>.<change>
+UPDATE outbox SET event_type = CASE event_type
+  WHEN 'rss_feed_created' THEN 'rss.created'
+  WHEN 'rss_feed_updated' THEN 'rss.updated'
+  WHEN 'rss_feed_deleted' THEN 'rss.deleted'
+  WHEN 'scrapper_created' THEN 'scrapped.created'
+  WHEN 'scrapper_updated' THEN 'scrapped.updated'
+  WHEN 'scrapper_deleted' THEN 'scrapped.deleted'
+  WHEN 'api_feed_created' THEN 'api.created'
+  WHEN 'api_feed_updated' THEN 'api.updated'
+  WHEN 'api_feed_deleted' THEN 'api.deleted'
+  ELSE event_type
+END;
+
+---- create above / drop below ----
+
+UPDATE outbox SET event_type = CASE event_type
+  WHEN 'rss.created' THEN 'rss_feed_created'
+  WHEN 'rss.updated' THEN 'rss_feed_updated'
+  WHEN 'rss.deleted' THEN 'rss_feed_deleted'
+  WHEN 'scrapped.created' THEN 'scrapper_created'
+  WHEN 'scrapped.updated' THEN 'scrapper_updated'
+  WHEN 'scrapped.deleted' THEN 'scrapper_deleted'
+  WHEN 'api.created' THEN 'api_feed_created'
+  WHEN 'api.updated' THEN 'api_feed_updated'
+  WHEN 'api.deleted' THEN 'api_feed_deleted'
+  ELSE event_type
+END;
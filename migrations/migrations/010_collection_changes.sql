@@ -0,0 +1,38 @@
+-- Change counters of publishers and publications tables, used as validators of listings.
+-- Counter is incremented by every statement changing the table in the same transaction, row lock of the counter
+-- orders concurrent changes, so counter never goes back, unlike max(modified_at), which is the transaction start time.
+-- Trade-off: the counter row of a table stays locked from the first changing statement till commit, so transactions
+-- writing the same table are serialized. Writes of publishers and publications are rare administrative changes
+-- made in short transactions, so throughput is not limited by it, while listings are read often and need cheap
+-- validator. Long transactions changing these tables block all other writers of the table and must be avoided.
+CREATE TABLE collection_changes (
+    collection text PRIMARY KEY,
+    change bigint NOT NULL DEFAULT 0,
+    modified_at timestamptz
+);
+INSERT INTO collection_changes (collection, change, modified_at)
+SELECT 'publishers', count(*), max(modified_at) FROM publishers;
+INSERT INTO collection_changes (collection, change, modified_at)
+SELECT 'publications', count(*), max(modified_at) FROM publications;
+
+-- modified_at is the counter change time, it is not earlier than previous one, as counter row is locked till commit
+CREATE OR REPLACE FUNCTION trigger_collection_change() RETURNS TRIGGER AS $$ BEGIN
+UPDATE collection_changes
+SET change = change + 1, modified_at = greatest(modified_at, clock_timestamp())
+WHERE collection = TG_TABLE_NAME;
+
+RETURN NULL;
+
+END;
+
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER collection_change AFTER INSERT OR UPDATE OR DELETE ON "publishers" FOR EACH STATEMENT EXECUTE PROCEDURE trigger_collection_change();
+CREATE TRIGGER collection_change AFTER INSERT OR UPDATE OR DELETE ON "publications" FOR EACH STATEMENT EXECUTE PROCEDURE trigger_collection_change();
+
+---- create above / drop below ----
+
+DROP TRIGGER collection_change ON "publications";
+DROP TRIGGER collection_change ON "publishers";
+DROP FUNCTION trigger_collection_change;
+DROP TABLE collection_changes;
@@ -4,31 +4,86 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/Tarick/naca-publications/internal/application/server"
 	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/Tarick/naca-publications/internal/spec"
 	"github.com/gofrs/uuid"
 )
 
 const publicationsPath string = "publications"
 const publishersPath string = "publishers"
 
+// listPageLimit is number of entities requested in one page of listing
+const listPageLimit int = 1000
+
 // TODO: WithTimeout?
-// New creates API http client
+// New creates API http client without authentication
 func New(serviceAPIURL string) *client {
+	return NewWithToken(serviceAPIURL, "")
+}
+
+// NewWithToken creates API http client, token is API key or JWT sent as Authorization bearer token, if not empty
+func NewWithToken(serviceAPIURL string, token string) *client {
 	return &client{
 		publishersURL:   fmt.Sprintf("%s/%s", serviceAPIURL, publishersPath),
 		publicationsURL: fmt.Sprintf("%s/%s", serviceAPIURL, publicationsPath),
 		httpClient: &http.Client{
-			Timeout: time.Minute,
+			Timeout:   time.Minute,
+			Transport: &bearerTransport{token: token, next: http.DefaultTransport},
 		},
 	}
 }
 
+// maxRetries is number of retries of requests rejected by API rate limit
+const maxRetries = 3
+
+// maxRetryAfter caps wait time before retry, requested by API
+const maxRetryAfter = 10 * time.Second
+
+// bearerTransport adds Authorization header to requests and retries requests rejected by rate limit after
+// time in Retry-After header
+type bearerTransport struct {
+	token string
+	next  http.RoundTripper
+}
+
+func (t *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		retryReq := req.Clone(req.Context())
+		if t.token != "" {
+			retryReq.Header.Set("Authorization", "Bearer "+t.token)
+		}
+		if attempt > 0 && req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			retryReq.Body = body
+		}
+		res, err := t.next.RoundTrip(retryReq)
+		if err != nil || res.StatusCode != http.StatusTooManyRequests || attempt == maxRetries ||
+			(req.Body != nil && req.GetBody == nil) {
+			return res, err
+		}
+		wait := maxRetryAfter
+		if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && time.Duration(seconds)*time.Second < wait {
+			wait = time.Duration(seconds) * time.Second
+		}
+		res.Body.Close()
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
 // TODO: add logger
 type client struct {
 	publishersURL   string
@@ -64,12 +119,7 @@ func (c *client) CreatePublisher(ctx context.Context, name string, url string) (
 		}
 		return *responsePublisher, nil
 	}
-	// handle error
-	var errRes server.ErrResponseBody
-	if err = json.NewDecoder(res.Body).Decode(&errRes); err == nil {
-		return entity.Publisher{}, errors.New(errRes.ErrorText)
-	}
-	return entity.Publisher{}, fmt.Errorf("unknown error, status code: %d, message: %v", res.StatusCode, res.Status)
+	return entity.Publisher{}, decodeError(res)
 }
 func (c *client) CreatePublication(
 	ctx context.Context,
@@ -79,7 +129,7 @@ func (c *client) CreatePublication(
 	publisherUUID uuid.UUID,
 	publicationType string,
 	config interface{}) (entity.Publication, error) {
-	publicationRequest := &server.PublicationRequestBody{
+	publicationRequest := &spec.PublicationRequestBody{
 		Name:          name,
 		Description:   description,
 		LanguageCode:  languageCode,
@@ -105,15 +155,184 @@ func (c *client) CreatePublication(
 	if res.StatusCode == http.StatusCreated {
 		// Create new publisher from response
 		responsePublication := &entity.Publication{}
-		if err = json.NewDecoder(res.Body).Decode(responsePublication); err == nil {
+		if err = json.NewDecoder(res.Body).Decode(responsePublication); err != nil {
 			return entity.Publication{}, err
 		}
 		return *responsePublication, nil
 	}
-	// handle error
-	var errRes server.ErrResponseBody
-	if err = json.NewDecoder(res.Body).Decode(&errRes); err != nil {
-		return entity.Publication{}, errors.New(errRes.ErrorText)
+	return entity.Publication{}, decodeError(res)
+}
+
+// GetPublishers returns all publishers, following pages of listing
+func (c *client) GetPublishers(ctx context.Context) ([]entity.Publisher, error) {
+	publishers := []entity.Publisher{}
+	pageURL := fmt.Sprintf("%s?limit=%d", c.publishersURL, listPageLimit)
+	for pageURL != "" {
+		page := []entity.Publisher{}
+		next, err := c.getList(ctx, pageURL, &page)
+		if err != nil {
+			return nil, err
+		}
+		publishers = append(publishers, page...)
+		pageURL = next
+	}
+	return publishers, nil
+}
+
+// GetPublisher returns publisher by UUID
+func (c *client) GetPublisher(ctx context.Context, publisherUUID uuid.UUID) (entity.Publisher, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/%s", c.publishersURL, publisherUUID), nil)
+	if err != nil {
+		return entity.Publisher{}, err
+	}
+	req = req.WithContext(ctx)
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return entity.Publisher{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return entity.Publisher{}, decodeError(res)
+	}
+	responsePublisher := entity.Publisher{}
+	if err = json.NewDecoder(res.Body).Decode(&responsePublisher); err != nil {
+		return entity.Publisher{}, err
+	}
+	return responsePublisher, nil
+}
+
+// GetPublisherPublications returns publications of publisher
+func (c *client) GetPublisherPublications(ctx context.Context, publisherUUID uuid.UUID) ([]entity.Publication, error) {
+	publications := []entity.Publication{}
+	if _, err := c.getList(ctx, fmt.Sprintf("%s/%s/publications", c.publishersURL, publisherUUID), &publications); err != nil {
+		return nil, err
+	}
+	return publications, nil
+}
+
+func (c *client) UpdatePublisher(ctx context.Context, publisherUUID uuid.UUID, name string, url string) (entity.Publisher, error) {
+	body, err := json.Marshal(&spec.PublisherRequestBody{Name: name, URL: url})
+	if err != nil {
+		return entity.Publisher{}, err
+	}
+	req, err := http.NewRequest("PUT", fmt.Sprintf("%s/%s", c.publishersURL, publisherUUID), bytes.NewReader(body))
+	if err != nil {
+		return entity.Publisher{}, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return entity.Publisher{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return entity.Publisher{}, decodeError(res)
+	}
+	responsePublisher := entity.Publisher{}
+	if err = json.NewDecoder(res.Body).Decode(&responsePublisher); err != nil {
+		return entity.Publisher{}, err
+	}
+	return responsePublisher, nil
+}
+
+func (c *client) UpdatePublication(
+	ctx context.Context,
+	publicationUUID uuid.UUID,
+	name string,
+	description string,
+	languageCode string,
+	publisherUUID uuid.UUID,
+	publicationType string,
+	config interface{}) (entity.Publication, error) {
+	body, err := json.Marshal(&spec.PublicationRequestBody{
+		Name:          name,
+		Description:   description,
+		LanguageCode:  languageCode,
+		PublisherUUID: publisherUUID,
+		Type:          publicationType,
+		Config:        config,
+	})
+	if err != nil {
+		return entity.Publication{}, err
+	}
+	req, err := http.NewRequest("PUT", fmt.Sprintf("%s/%s", c.publicationsURL, publicationUUID), bytes.NewReader(body))
+	if err != nil {
+		return entity.Publication{}, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return entity.Publication{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return entity.Publication{}, decodeError(res)
+	}
+	responsePublication := entity.Publication{}
+	if err = json.NewDecoder(res.Body).Decode(&responsePublication); err != nil {
+		return entity.Publication{}, err
+	}
+	return responsePublication, nil
+}
+
+// DeletePublisher deletes publisher together with its publications
+func (c *client) DeletePublisher(ctx context.Context, publisherUUID uuid.UUID) error {
+	return c.delete(ctx, fmt.Sprintf("%s/%s", c.publishersURL, publisherUUID))
+}
+
+func (c *client) DeletePublication(ctx context.Context, publicationUUID uuid.UUID) error {
+	return c.delete(ctx, fmt.Sprintf("%s/%s", c.publicationsURL, publicationUUID))
+}
+
+func (c *client) delete(ctx context.Context, resourceURL string) error {
+	req, err := http.NewRequest("DELETE", resourceURL, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		return decodeError(res)
+	}
+	return nil
+}
+
+// getList decodes JSON array from listing into v and returns URL of the next page from Link header, if there is one
+func (c *client) getList(ctx context.Context, listURL string, v interface{}) (string, error) {
+	req, err := http.NewRequest("GET", listURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", decodeError(res)
+	}
+	if err = json.NewDecoder(res.Body).Decode(v); err != nil {
+		return "", err
+	}
+	return nextPageURL(res)
+}
+
+// nextPageURL resolves rel="next" link of response against request URL
+func nextPageURL(res *http.Response) (string, error) {
+	link := res.Header.Get("Link")
+	if link == "" || !strings.HasSuffix(link, `>; rel="next"`) || !strings.HasPrefix(link, "<") {
+		return "", nil
+	}
+	next, err := url.Parse(strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`))
+	if err != nil {
+		return "", err
 	}
-	return entity.Publication{}, fmt.Errorf("unknown error, status code: %d, message: %v", res.StatusCode, res.Status)
+	return res.Request.URL.ResolveReference(next).String(), nil
 }
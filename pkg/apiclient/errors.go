@@ -0,0 +1,86 @@
+package apiclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Tarick/naca-publications/internal/entity"
+	"github.com/Tarick/naca-publications/pkg/problem"
+	"github.com/gofrs/uuid"
+)
+
+// Error is problem returned by Publications API. Compare it with errors.Is to sentinel errors below by code,
+// or get details with errors.As. Conflicts with existing resources unwrap to entity.ConflictError.
+type Error struct {
+	StatusCode int
+	Code       string
+	Detail     string
+	RequestID  string
+	// InvalidParams are failed validations of request fields
+	InvalidParams []problem.InvalidParam
+	// ExistingUUID is UUID of existing resource, which conflicts with created or changed one
+	ExistingUUID uuid.UUID
+}
+
+// Sentinel errors of stable API error codes
+var (
+	ErrInvalidRequest           = &Error{Code: problem.CodeInvalidRequest}
+	ErrValidationFailed         = &Error{Code: problem.CodeValidationFailed}
+	ErrNotFound                 = &Error{Code: problem.CodeNotFound}
+	ErrPublisherNotFound        = &Error{Code: problem.CodePublisherNotFound}
+	ErrPublicationNotFound      = &Error{Code: problem.CodePublicationNotFound}
+	ErrPublisherAlreadyExists   = &Error{Code: problem.CodePublisherAlreadyExists}
+	ErrPublicationAlreadyExists = &Error{Code: problem.CodePublicationAlreadyExists}
+	ErrPublisherDeleted         = &Error{Code: problem.CodePublisherDeleted}
+	ErrPreconditionFailed       = &Error{Code: problem.CodePreconditionFailed}
+	ErrUnauthorized             = &Error{Code: problem.CodeUnauthorized}
+	ErrForbidden                = &Error{Code: problem.CodeForbidden}
+	ErrRateLimited              = &Error{Code: problem.CodeRateLimited}
+	ErrTimeout                  = &Error{Code: problem.CodeTimeout}
+)
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("publications api: %s (status %d)", e.Code, e.StatusCode)
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	if e.RequestID != "" {
+		msg += ", request id " + e.RequestID
+	}
+	return msg
+}
+
+// Is matches errors with the same code
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Unwrap returns entity.ConflictError of conflicts, so they match entity.ErrAlreadyExists
+func (e *Error) Unwrap() error {
+	switch e.Code {
+	case problem.CodePublisherAlreadyExists, problem.CodePublicationAlreadyExists:
+		return &entity.ConflictError{UUID: e.ExistingUUID}
+	}
+	return nil
+}
+
+// decodeError reads problem details from error response
+func decodeError(res *http.Response) error {
+	problem := problem.Details{}
+	if err := json.NewDecoder(res.Body).Decode(&problem); err != nil || problem.Code == "" {
+		return fmt.Errorf("unknown error, status code: %d, message: %v", res.StatusCode, res.Status)
+	}
+	apiErr := &Error{
+		StatusCode:    res.StatusCode,
+		Code:          problem.Code,
+		Detail:        problem.Detail,
+		RequestID:     problem.RequestID,
+		InvalidParams: problem.InvalidParams,
+	}
+	if problem.ExistingUUID != nil {
+		apiErr.ExistingUUID = *problem.ExistingUUID
+	}
+	return apiErr
+}
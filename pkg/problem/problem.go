@@ -0,0 +1,67 @@
+// Package problem defines RFC 7807 problem details of Publications API errors, shared by API and its clients
+package problem
+
+import "github.com/gofrs/uuid"
+
+// ContentType is media type of error responses
+const ContentType = "application/problem+json"
+
+// TypePrefix forms problem type URI from error code
+const TypePrefix = "urn:naca-publications:problem:"
+
+// Error codes are stable machine-readable identifiers of errors, clients should rely on them instead of texts
+const (
+	CodeInvalidRequest           = "invalid_request"
+	CodeValidationFailed         = "validation_failed"
+	CodeRequestTooLarge          = "request_too_large"
+	CodeNotFound                 = "not_found"
+	CodePublisherNotFound        = "publisher_not_found"
+	CodePublicationNotFound      = "publication_not_found"
+	CodeAPIKeyNotFound           = "api_key_not_found"
+	CodePublisherAlreadyExists   = "publisher_already_exists"
+	CodePublicationAlreadyExists = "publication_already_exists"
+	CodePublisherDeleted         = "publisher_deleted"
+	CodePreconditionFailed       = "precondition_failed"
+	CodeUnauthorized             = "unauthorized"
+	CodeForbidden                = "forbidden"
+	CodeRateLimited              = "rate_limited"
+	CodeRenderFailed             = "render_failed"
+	CodeInternal                 = "internal_error"
+	CodeUnavailable              = "unavailable"
+	CodeTimeout                  = "timeout"
+)
+
+// CodeValidationInvalid is code of invalid param, which validation error has no code of its own
+const CodeValidationInvalid = "validation_invalid"
+
+// Details is RFC 7807 problem details object, readable to application/human
+type Details struct {
+	// URI of problem type, made of error code
+	Type string `json:"type"`
+	// user-level summary of problem type
+	Title string `json:"title"`
+	// HTTP status code
+	Status int `json:"status"`
+	// application-level error message, for debugging
+	Detail string `json:"detail,omitempty"`
+	// path of request, which has failed
+	Instance string `json:"instance,omitempty"`
+	// stable error code
+	Code string `json:"code"`
+	// ID of request, to find it in logs
+	RequestID string `json:"request_id,omitempty"`
+	// failed validations of request fields
+	InvalidParams []InvalidParam `json:"invalid_params,omitempty"`
+	// UUID of existing resource, which conflicts with created or changed one
+	ExistingUUID *uuid.UUID `json:"existing_uuid,omitempty"`
+}
+
+// InvalidParam is failed validation of request field
+type InvalidParam struct {
+	// field path, nested fields are separated by dots, e.g. config.url
+	Name string `json:"name"`
+	// validation error code, e.g. validation_required
+	Code string `json:"code"`
+	// readable validation error
+	Reason string `json:"reason"`
+}